@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL, -- base32 encoded TOTP secret
+    enabled_at TIMESTAMP, -- NULL until the user confirms a first code
+    last_used_step BIGINT NOT NULL DEFAULT 0, -- prevents replaying a code
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL, -- sha256 of the recovery code
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes(user_id);
+
+ALTER TABLE user_sessions
+ADD COLUMN mfa_used BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA UNIQUE NOT NULL,
+    public_key BYTEA NOT NULL,
+    attestation_type VARCHAR(50) NOT NULL DEFAULT '',
+    aaguid BYTEA,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    clone_warning BOOLEAN NOT NULL DEFAULT false,
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    backup_eligible BOOLEAN NOT NULL DEFAULT false,
+    backup_state BOOLEAN NOT NULL DEFAULT false,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- Challenges of registration/login ceremonies in progress
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+    id VARCHAR(64) PRIMARY KEY, -- sha256 of the ceremony cookie
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE, -- NULL for passwordless login
+    kind VARCHAR(20) NOT NULL, -- registration, login
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE user_sessions
+ADD COLUMN auth_method VARCHAR(20) NOT NULL DEFAULT 'password'; -- password, passkey
@@ -0,0 +1,17 @@
+-- Accounts at external OpenID Connect providers linked to local users
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL, -- provider name from OIDC_PROVIDERS
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email CITEXT, -- email claim at the time of the last login
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP,
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+
+ALTER TABLE user_sessions
+ADD COLUMN auth_provider VARCHAR(50); -- OIDC provider name when auth_method is oidc
@@ -0,0 +1,11 @@
+-- Keyring for signing JWTs, tokens carry the key's kid in their header.
+-- The newest key that is not retired signs, older ones only verify until retired.
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+    id SERIAL PRIMARY KEY,
+    kid VARCHAR(32) UNIQUE NOT NULL,
+    algorithm VARCHAR(10) NOT NULL, -- HS256, EdDSA or RS256
+    private_key BYTEA NOT NULL, -- HMAC secret, or PKCS#8 DER private key
+    public_key BYTEA, -- PKIX DER public key, NULL for HMAC
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMP -- tokens signed with a retired key are rejected
+);
@@ -0,0 +1,25 @@
+-- Every password sign in attempt, for spotting attacks
+CREATE TABLE IF NOT EXISTS sign_in_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    email CITEXT NOT NULL, -- as typed, the account may not exist
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    ip_address INET,
+    user_agent TEXT,
+    succeeded BOOLEAN NOT NULL,
+    failure_reason VARCHAR(30), -- unknown_email, bad_password, bad_code, locked
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sign_in_attempts_created_at ON sign_in_attempts(created_at);
+CREATE INDEX IF NOT EXISTS idx_sign_in_attempts_email ON sign_in_attempts(email, created_at);
+CREATE INDEX IF NOT EXISTS idx_sign_in_attempts_ip_address ON sign_in_attempts(ip_address, created_at);
+
+-- Consecutive failures per account (email) and per client IP, with the lockout they caused
+CREATE TABLE IF NOT EXISTS sign_in_lockouts (
+    scope VARCHAR(10) NOT NULL, -- account or ip
+    subject VARCHAR(255) NOT NULL, -- lower case email or IP address
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP,
+    PRIMARY KEY (scope, subject)
+);
@@ -0,0 +1,19 @@
+-- Low-privilege role for the admin SQL console. It can't log in until the operator gives it a password:
+--   ALTER ROLE steamed_admin_query LOGIN PASSWORD '...';
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'steamed_admin_query') THEN
+        CREATE ROLE steamed_admin_query NOLOGIN;
+    END IF;
+END
+$$;
+
+ALTER ROLE steamed_admin_query SET default_transaction_read_only = on;
+ALTER ROLE steamed_admin_query SET statement_timeout = '30s';
+
+GRANT USAGE ON SCHEMA public TO steamed_admin_query;
+GRANT SELECT ON ALL TABLES IN SCHEMA public TO steamed_admin_query;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT ON TABLES TO steamed_admin_query;
+
+-- Secrets that would let an admin sign in as someone else
+REVOKE SELECT ON jwt_signing_keys, user_sessions, user_totp, webauthn_ceremonies FROM steamed_admin_query;
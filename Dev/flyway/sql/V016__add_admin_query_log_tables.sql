@@ -0,0 +1,40 @@
+-- Named queries admins can run again, with $1, $2... filled from named parameters
+CREATE TABLE IF NOT EXISTS admin_saved_queries (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    query TEXT NOT NULL,
+    params TEXT[] NOT NULL DEFAULT '{}', -- parameter names, the first one is $1
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every query run through the admin SQL console, including failed ones
+CREATE TABLE IF NOT EXISTS admin_query_log (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    email CITEXT NOT NULL, -- kept when the user is deleted
+    saved_query_id INTEGER REFERENCES admin_saved_queries(id) ON DELETE SET NULL,
+    query TEXT NOT NULL,
+    params JSONB, -- values of the saved query's parameters
+    format VARCHAR(10) NOT NULL, -- json, csv or ndjson
+    duration_ms INTEGER NOT NULL,
+    row_count INTEGER NOT NULL,
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_query_log_created_at ON admin_query_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_admin_query_log_email ON admin_query_log(email, created_at);
+
+INSERT INTO permissions (name, description) VALUES
+    ('audit:read', 'See the admin query log')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, 'audit:read'
+FROM roles
+WHERE roles.name IN ('admin', 'auditor')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,45 @@
+-- Disabled accounts can't sign in, their sessions and tokens stop working
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
+
+-- Rooms, folders and notes go with their owner. Rows whose owner, room or folder is gone
+-- were unreachable already and are dropped so the foreign keys can be added.
+DELETE FROM notes
+WHERE user_id NOT IN (SELECT id FROM users)
+OR room_id NOT IN (SELECT id FROM rooms)
+OR folder_id NOT IN (SELECT id FROM folders);
+
+DELETE FROM folders
+WHERE user_id NOT IN (SELECT id FROM users)
+OR room_id NOT IN (SELECT id FROM rooms);
+
+DELETE FROM rooms
+WHERE user_id NOT IN (SELECT id FROM users);
+
+ALTER TABLE rooms
+ADD CONSTRAINT rooms_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE folders
+ADD CONSTRAINT folders_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+ADD CONSTRAINT folders_room_id_fkey FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE;
+
+ALTER TABLE notes
+ADD CONSTRAINT notes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+ADD CONSTRAINT notes_room_id_fkey FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+ADD CONSTRAINT notes_folder_id_fkey FOREIGN KEY (folder_id) REFERENCES folders(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_rooms_user_id ON rooms(user_id);
+CREATE INDEX IF NOT EXISTS idx_folders_user_id ON folders(user_id);
+CREATE INDEX IF NOT EXISTS idx_folders_room_id ON folders(room_id);
+CREATE INDEX IF NOT EXISTS idx_notes_user_id ON notes(user_id);
+CREATE INDEX IF NOT EXISTS idx_notes_folder_id ON notes(folder_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:manage', 'Disable, enable and delete users and force password resets')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, 'users:manage'
+FROM roles
+WHERE roles.name = 'admin'
+ON CONFLICT DO NOTHING;
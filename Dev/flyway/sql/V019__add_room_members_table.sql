@@ -0,0 +1,22 @@
+-- Who can use a room and how: owner (the creator), editor, commenter or viewer.
+-- Invited members have no access until they accept.
+CREATE TABLE IF NOT EXISTS room_members (
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'editor', 'commenter', 'viewer')),
+    invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL, -- NULL for owners
+    invited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    accepted_at TIMESTAMP, -- NULL while the invitation is pending
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_members_user_id ON room_members(user_id);
+
+-- Every room has exactly one owner
+CREATE UNIQUE INDEX IF NOT EXISTS idx_room_members_owner ON room_members(room_id) WHERE role = 'owner';
+
+-- The creators of existing rooms own them
+INSERT INTO room_members (room_id, user_id, role, invited_at, accepted_at)
+SELECT id, user_id, 'owner', COALESCE(created_at, CURRENT_TIMESTAMP), COALESCE(created_at, CURRENT_TIMESTAMP)
+FROM rooms
+ON CONFLICT DO NOTHING;
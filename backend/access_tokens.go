@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Scopes a personal access token can be granted
+const (
+	ScopeNotesRead  = "notes:read"  // read rooms, folders and notes
+	ScopeNotesWrite = "notes:write" // create, update and delete rooms, folders and notes
+	ScopeAdmin      = "admin"       // admin endpoints, still limited to the permissions of the user's roles
+)
+
+var personalAccessTokenScopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopeAdmin}
+
+const (
+	personalAccessTokenPrefix       = "snp_"
+	personalAccessTokenDisplayChars = 12 // stored in clear to recognise the token, e.g. snp_1a2b3c4d
+	maxPersonalAccessTokenDays      = 366
+	maxPersonalAccessTokenName      = 100 // personal_access_tokens.name is VARCHAR(100)
+)
+
+// ValidatePersonalAccessToken looks up an unexpired token and records its use
+func ValidatePersonalAccessToken(ctx context.Context, queries *db.Queries, token string) (db.PersonalAccessToken, error) {
+	if !strings.HasPrefix(token, personalAccessTokenPrefix) {
+		return db.PersonalAccessToken{}, errors.New("not a personal access token")
+	}
+
+	accessToken, err := queries.FindPersonalAccessTokenByHash(ctx, HashToken(token))
+	if err != nil {
+		return db.PersonalAccessToken{}, err
+	}
+
+	if err := queries.UpdatePersonalAccessTokenLastUsed(ctx, accessToken.ID); err != nil {
+		fmt.Printf("Failed to update last use of token %d: %v\n", accessToken.ID, err)
+	}
+	return accessToken, nil
+}
+
+type CreatePersonalAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 never expires
+}
+
+// PersonalAccessTokenDTO represents a token for JSON response, never includes the token itself
+type PersonalAccessTokenDTO struct {
+	ID          int32    `json:"id"`
+	Name        string   `json:"name"`
+	TokenPrefix string   `json:"token_prefix"`
+	Scopes      []string `json:"scopes"`
+	CreatedAt   string   `json:"created_at"`
+	ExpiresAt   string   `json:"expires_at,omitempty"`
+	LastUsedAt  string   `json:"last_used_at,omitempty"`
+}
+
+func newPersonalAccessTokenDTO(token db.PersonalAccessToken) PersonalAccessTokenDTO {
+	dto := PersonalAccessTokenDTO{
+		ID:          token.ID,
+		Name:        token.Name,
+		TokenPrefix: token.TokenPrefix,
+		Scopes:      token.Scopes,
+		CreatedAt:   token.CreatedAt.Time.Format(time.RFC3339),
+	}
+	if token.ExpiresAt.Valid {
+		dto.ExpiresAt = token.ExpiresAt.Time.Format(time.RFC3339)
+	}
+	if token.LastUsedAt.Valid {
+		dto.LastUsedAt = token.LastUsedAt.Time.Format(time.RFC3339)
+	}
+	return dto
+}
+
+// Create a personal access token, the token is only returned once
+func (conn ConnectionData) createPersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreatePersonalAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxPersonalAccessTokenName {
+		http.Error(w, fmt.Sprintf("Name must be between 1 and %d characters", maxPersonalAccessTokenName), http.StatusBadRequest)
+		return
+	}
+
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxPersonalAccessTokenDays {
+		http.Error(w, fmt.Sprintf("expires_in_days must be between 0 and %d", maxPersonalAccessTokenDays), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(personalAccessTokenScopes, scope) {
+			http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	// Only users with a role can hand out the admin scope
+	if slices.Contains(scopes, ScopeAdmin) {
+		permissions, err := conn.queries.ListUserPermissions(r.Context(), int32(userID))
+		if err != nil || len(permissions) == 0 {
+			http.Error(w, "You are not admin", http.StatusForbidden)
+			return
+		}
+	}
+
+	secretPart, err := GenerateSessionToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	token := personalAccessTokenPrefix + secretPart
+
+	var expiresAt pgtype.Timestamp
+	if req.ExpiresInDays > 0 {
+		expiresAt = pgtype.Timestamp{Time: time.Now().AddDate(0, 0, req.ExpiresInDays), Valid: true}
+	}
+
+	accessToken, err := conn.queries.CreatePersonalAccessToken(r.Context(), db.CreatePersonalAccessTokenParams{
+		UserID:      int32(userID),
+		Name:        req.Name,
+		TokenHash:   HashToken(token),
+		TokenPrefix: token[:personalAccessTokenDisplayChars],
+		Scopes:      scopes,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		fmt.Printf("Failed to create token for user %d: %v\n", userID, err)
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		PersonalAccessTokenDTO
+		Token string `json:"token"`
+	}{newPersonalAccessTokenDTO(accessToken), token})
+}
+
+// Get all personal access tokens of the current user
+func (conn ConnectionData) getPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := conn.queries.ListPersonalAccessTokensByUser(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to get tokens", http.StatusInternalServerError)
+		return
+	}
+
+	tokenDTOs := make([]PersonalAccessTokenDTO, len(tokens))
+	for i, token := range tokens {
+		tokenDTOs[i] = newPersonalAccessTokenDTO(token)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokenDTOs)
+}
+
+// Revoke one of the current user's personal access tokens
+func (conn ConnectionData) deletePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tokenIDStr := r.URL.Query().Get("token_id")
+	if tokenIDStr == "" {
+		http.Error(w, "Missing token_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	tokenID, err := strconv.Atoi(tokenIDStr)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := conn.queries.DeletePersonalAccessToken(r.Context(), db.DeletePersonalAccessTokenParams{
+		ID:     int32(tokenID),
+		UserID: int32(userID),
+	})
+	if err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Token revoked successfully"})
+}
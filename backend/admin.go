@@ -1,83 +1,521 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"steamednotes/db"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // QueryRequest is the expected JSON payload
 type QueryRequest struct {
-	Query string `json:"query"`
+	Query  string `json:"query"`
+	Format string `json:"format"` // json (default), csv or ndjson
 }
 
 // QueryResponse contains the query results or error
 type QueryResponse struct {
-	Columns []string        `json:"columns"`
-	Rows    [][]interface{} `json:"rows"`
-	Error   string          `json:"error,omitempty"`
+	Columns     []string        `json:"columns"`
+	ColumnTypes []string        `json:"column_types"`
+	Rows        [][]interface{} `json:"rows"`
+	Truncated   bool            `json:"truncated"` // there were more than max_rows rows
+	Error       string          `json:"error,omitempty"`
 }
 
-func (conn ConnectionDataAdmin) adminQuery(w http.ResponseWriter, r *http.Request) {
+// NewAdminQueryPool connects as the admin query role, nil when no database url is configured.
+// It refuses roles that could change data, e.g. the app's own role.
+func NewAdminQueryPool(ctx context.Context, cfg AdminQueryConfig) (*pgxpool.Pool, error) {
+	if cfg.DatabaseURL == "" {
+		return nil, nil
+	}
 
-	// we check if user is indeed admin
-	userEmail := r.Header.Get("email")
-	res, err := conn.queries.CheckIfAdmin(r.Context(), userEmail)
-	if err != nil || res != userEmail {
-		http.Error(w, "You are not admin", http.StatusForbidden)
-		return
+	poolConfig, err := pgxpool.ParseConfig(cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin query database url: %w", err)
 	}
+	poolConfig.MaxConns = 4
+	poolConfig.ConnConfig.RuntimeParams["application_name"] = "steamednotes admin query"
+	poolConfig.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	// Every query is different, caching their statements would only fill the cache
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var superuser, canWrite bool
+	err = pool.QueryRow(ctx, `SELECT rolsuper, has_table_privilege('notes', 'INSERT, UPDATE, DELETE, TRUNCATE')
+		FROM pg_roles WHERE rolname = current_user`).Scan(&superuser, &canWrite)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("checking the admin query role: %w", err)
+	}
+	if superuser || canWrite {
+		pool.Close()
+		return nil, errors.New("the admin query role must not be a superuser or able to write, use steamed_admin_query")
+	}
+	return pool, nil
+}
+
+// adminQueryFormats are the result formats, csv and ndjson are streamed for large results
+var adminQueryFormats = []string{"json", "csv", "ndjson"}
+
+// adminQueryFetchSize is the number of rows fetched from the cursor at once
+const adminQueryFetchSize = 1000
+
+// adminQueryRun is one run of the SQL console, as recorded in admin_query_log
+type adminQueryRun struct {
+	SavedQueryID int32 // 0 for ad-hoc queries
+	Query        string
+	Params       map[string]string // values of the saved query's parameters
+	Args         []interface{}     // the params in $n order
+	Format       string
+	RowCount     int
+	Truncated    bool
+}
 
+// Run a read-only query for admins
+func (conn ConnectionDataAdmin) adminQuery(w http.ResponseWriter, r *http.Request) {
 	var req QueryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Basic security: Allow only SELECT queries
-	query := strings.TrimSpace(req.Query)
-	if !strings.HasPrefix(strings.ToUpper(query), "SELECT") {
-		http.Error(w, "Only SELECT queries are allowed", http.StatusForbidden)
+	conn.serveAdminQuery(w, r, &adminQueryRun{Query: req.Query, Format: req.Format})
+}
+
+// serveAdminQuery runs the query, writes the result in the requested format and records the run
+func (conn ConnectionDataAdmin) serveAdminQuery(w http.ResponseWriter, r *http.Request, run *adminQueryRun) {
+	// A cursor takes a single SELECT or VALUES, without data-modifying CTEs
+	run.Query = strings.TrimRight(strings.TrimSpace(run.Query), "; \t\r\n")
+	if run.Query == "" {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if run.Format == "" {
+		run.Format = "json"
+	}
+	if !slices.Contains(adminQueryFormats, run.Format) {
+		http.Error(w, "format must be one of "+strings.Join(adminQueryFormats, ", "), http.StatusBadRequest)
 		return
 	}
 
-	rows, err := conn.pool.Query(r.Context(), query)
-	if err != nil {
+	var out adminQueryWriter
+	maxRows := appConfig.AdminQuery.ExportMaxRows
+	switch run.Format {
+	case "csv":
+		out = newCSVQueryWriter(w)
+	case "ndjson":
+		out = newNDJSONQueryWriter(w)
+	default:
+		out = &jsonQueryWriter{w: w}
+		maxRows = appConfig.AdminQuery.MaxRows
+	}
+
+	start := time.Now()
+	err := conn.runAdminQuery(r.Context(), run, maxRows, out)
+	if err == nil {
+		err = out.Finish(run.RowCount, run.Truncated)
+	}
+	conn.logAdminQuery(r, run, time.Since(start), err)
+
+	if err == nil {
+		return
+	}
+	if out.Started() {
+		// The status is gone already, breaking the response is all that tells the client
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Del("Content-Disposition")
+	w.Header().Del("Trailer")
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
 		http.Error(w, fmt.Sprintf("Query error: %v", err), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
+	if r.Context().Err() == nil {
+		fmt.Printf("Admin query failed: %v\n", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+	}
+}
+
+// runAdminQuery runs the query as the admin query role in a READ ONLY transaction and hands at most
+// maxRows rows to out, fetching them from a cursor in batches. The context cancels it when the client goes away.
+func (conn ConnectionDataAdmin) runAdminQuery(ctx context.Context, run *adminQueryRun, maxRows int, out adminQueryWriter) error {
+	tx, err := conn.pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	timeout := strconv.FormatInt(appConfig.AdminQuery.StatementTimeout.Milliseconds(), 10)
+	if _, err := tx.Exec(ctx, "SELECT set_config('statement_timeout', $1, true)", timeout); err != nil {
+		return err
+	}
 
-	// Get column names
+	if _, err := tx.Exec(ctx, "DECLARE admin_query NO SCROLL CURSOR FOR "+run.Query, run.Args...); err != nil {
+		return err
+	}
+
+	for first := true; ; first = false {
+		// One row more than allowed tells whether the result was truncated
+		batchSize := min(adminQueryFetchSize, maxRows+1-run.RowCount)
+		rows, err := tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM admin_query", batchSize))
+		if err != nil {
+			return err
+		}
+
+		if first {
+			if err := out.Columns(adminQueryColumns(rows)); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			if run.RowCount == maxRows {
+				run.Truncated = true
+				break
+			}
+			values, err := rows.Values()
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			for i, value := range values {
+				values[i] = adminQueryValue(value)
+			}
+			if err := out.Row(values); err != nil {
+				rows.Close()
+				return err
+			}
+			run.RowCount++
+		}
+		rows.Close()
+
+		// Check for errors after iteration, e.g. the statement timeout
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if run.Truncated || fetched < batchSize {
+			return nil
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// adminQueryColumns returns the column names and their Postgres type names
+func adminQueryColumns(rows pgx.Rows) ([]string, []string) {
 	columns := rows.FieldDescriptions()
 	columnNames := make([]string, len(columns))
+	columnTypes := make([]string, len(columns))
 	for i, col := range columns {
 		columnNames[i] = col.Name
+		if dataType, ok := rows.Conn().TypeMap().TypeForOID(col.DataTypeOID); ok {
+			columnTypes[i] = dataType.Name
+		} else {
+			columnTypes[i] = strconv.FormatUint(uint64(col.DataTypeOID), 10)
+		}
+	}
+	return columnNames, columnTypes
+}
+
+// logAdminQuery records the run in admin_query_log, failed and cancelled runs too
+func (conn ConnectionDataAdmin) logAdminQuery(r *http.Request, run *adminQueryRun, duration time.Duration, runErr error) {
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+
+	var params []byte
+	if run.Params != nil {
+		params, _ = json.Marshal(run.Params)
+	}
+	var errorText pgtype.Text
+	if runErr != nil {
+		errorText = pgtype.Text{String: runErr.Error(), Valid: true}
+	}
+
+	// The request context may be cancelled, the log entry is written anyway
+	err := conn.queries.CreateAdminQueryLog(context.Background(), db.CreateAdminQueryLogParams{
+		UserID:       pgtype.Int4{Int32: int32(userID), Valid: userID != 0},
+		Email:        r.Header.Get("email"),
+		SavedQueryID: pgtype.Int4{Int32: run.SavedQueryID, Valid: run.SavedQueryID != 0},
+		Query:        run.Query,
+		Params:       params,
+		Format:       run.Format,
+		DurationMs:   int32(duration.Milliseconds()),
+		RowCount:     int32(run.RowCount),
+		Truncated:    run.Truncated,
+		Error:        errorText,
+	})
+	if err != nil {
+		fmt.Printf("Failed to log admin query of %s: %v\n", r.Header.Get("email"), err)
+	}
+}
+
+// adminQueryWriter writes a query result while it is fetched
+type adminQueryWriter interface {
+	Columns(names, types []string) error
+	Row(values []interface{}) error
+	Flush() error // after every batch
+	Finish(rowCount int, truncated bool) error
+	Started() bool // whether the response was sent already
+}
+
+// jsonQueryWriter collects the rows into one QueryResponse
+type jsonQueryWriter struct {
+	w    http.ResponseWriter
+	resp QueryResponse
+}
+
+func (jw *jsonQueryWriter) Columns(names, types []string) error {
+	jw.resp = QueryResponse{Columns: names, ColumnTypes: types, Rows: [][]interface{}{}}
+	return nil
+}
+
+func (jw *jsonQueryWriter) Row(values []interface{}) error {
+	jw.resp.Rows = append(jw.resp.Rows, values)
+	return nil
+}
+
+func (jw *jsonQueryWriter) Flush() error { return nil }
+
+func (jw *jsonQueryWriter) Finish(rowCount int, truncated bool) error {
+	jw.resp.Truncated = truncated
+	jw.w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(jw.w).Encode(jw.resp)
+}
+
+func (jw *jsonQueryWriter) Started() bool { return false }
+
+// Streamed formats can't say in the body that they were truncated, they end with these trailers
+const (
+	adminQueryRowCountTrailer  = "X-Query-Row-Count"
+	adminQueryTruncatedTrailer = "X-Query-Truncated"
+)
+
+// streamQueryWriter sends the headers and flushes the response after every batch,
+// the format writers write through it to know when the response has started
+type streamQueryWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (sw *streamQueryWriter) Write(p []byte) (int, error) {
+	sw.started = true
+	return sw.w.Write(p)
+}
+
+func (sw *streamQueryWriter) start(contentType, extension string) {
+	sw.w.Header().Set("Content-Type", contentType)
+	sw.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="query-%s.%s"`, time.Now().UTC().Format("20060102-150405"), extension))
+	sw.w.Header().Set("Trailer", adminQueryRowCountTrailer+", "+adminQueryTruncatedTrailer)
+}
+
+func (sw *streamQueryWriter) flush() {
+	if flusher, ok := sw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sw *streamQueryWriter) finish(rowCount int, truncated bool) {
+	sw.w.Header().Set(adminQueryRowCountTrailer, strconv.Itoa(rowCount))
+	sw.w.Header().Set(adminQueryTruncatedTrailer, strconv.FormatBool(truncated))
+}
+
+func (sw *streamQueryWriter) Started() bool { return sw.started }
+
+// csvQueryWriter writes a header line with the column names, then one line per row
+type csvQueryWriter struct {
+	streamQueryWriter
+	csv *csv.Writer
+}
+
+func newCSVQueryWriter(w http.ResponseWriter) *csvQueryWriter {
+	cw := &csvQueryWriter{streamQueryWriter: streamQueryWriter{w: w}}
+	cw.csv = csv.NewWriter(&cw.streamQueryWriter)
+	return cw
+}
+
+func (cw *csvQueryWriter) Columns(names, types []string) error {
+	cw.start("text/csv; charset=utf-8", "csv")
+	return cw.csv.Write(names)
+}
+
+func (cw *csvQueryWriter) Row(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = adminQueryText(value)
+	}
+	return cw.csv.Write(record)
+}
+
+func (cw *csvQueryWriter) Flush() error {
+	cw.csv.Flush()
+	cw.flush()
+	return cw.csv.Error()
+}
+
+func (cw *csvQueryWriter) Finish(rowCount int, truncated bool) error {
+	if err := cw.Flush(); err != nil {
+		return err
 	}
+	cw.finish(rowCount, truncated)
+	return nil
+}
 
-	// Fetch rows
-	var resultRows [][]interface{}
-	for rows.Next() {
-		values, err := rows.Values()
+// ndjsonQueryWriter writes one JSON object per row, keyed by column name in column order
+type ndjsonQueryWriter struct {
+	streamQueryWriter
+	names []string
+	buf   *bufio.Writer
+}
+
+func newNDJSONQueryWriter(w http.ResponseWriter) *ndjsonQueryWriter {
+	nw := &ndjsonQueryWriter{streamQueryWriter: streamQueryWriter{w: w}}
+	nw.buf = bufio.NewWriter(&nw.streamQueryWriter)
+	return nw
+}
+
+func (nw *ndjsonQueryWriter) Columns(names, types []string) error {
+	nw.start("application/x-ndjson", "ndjson")
+	nw.names = make([]string, len(names))
+	for i, name := range names {
+		key, err := json.Marshal(name)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error scanning row: %v", err), http.StatusInternalServerError)
-			return
+			return err
 		}
-		resultRows = append(resultRows, values)
+		nw.names[i] = string(key)
 	}
+	return nil
+}
 
-	// Check for errors after iteration
-	if err := rows.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Row iteration error: %v", err), http.StatusInternalServerError)
-		return
+func (nw *ndjsonQueryWriter) Row(values []interface{}) error {
+	nw.buf.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			nw.buf.WriteByte(',')
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		nw.buf.WriteString(nw.names[i])
+		nw.buf.WriteByte(':')
+		nw.buf.Write(encoded)
 	}
+	_, err := nw.buf.WriteString("}\n")
+	return err
+}
+
+func (nw *ndjsonQueryWriter) Flush() error {
+	err := nw.buf.Flush()
+	nw.flush()
+	return err
+}
+
+func (nw *ndjsonQueryWriter) Finish(rowCount int, truncated bool) error {
+	if err := nw.Flush(); err != nil {
+		return err
+	}
+	nw.finish(rowCount, truncated)
+	return nil
+}
+
+// adminQueryText formats a value of adminQueryValue for a CSV cell, NULL is empty
+func adminQueryText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}, map[string]interface{}:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// maxSafeJSONInteger is the largest integer JavaScript numbers hold exactly
+const maxSafeJSONInteger = 1<<53 - 1
+
+// adminQueryValue turns a value from rows.Values() into something that encodes to readable JSON
+func adminQueryValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time: // timestamp, timestamptz, date
+		return v.Format(time.RFC3339Nano)
+	case netip.Prefix: // inet, cidr
+		if v.IsSingleIP() {
+			return v.Addr().String()
+		}
+		return v.String()
+	case net.HardwareAddr: // macaddr
+		return v.String()
+	case [16]byte: // uuid
+		return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])
+	case []byte: // bytea, in psql's hex format
+		return `\x` + hex.EncodeToString(v)
+	case int64:
+		if v > maxSafeJSONInteger || v < -maxSafeJSONInteger {
+			return strconv.FormatInt(v, 10)
+		}
+		return v
+	case float32:
+		return adminQueryFloat(float64(v))
+	case float64:
+		return adminQueryFloat(v)
+	case []interface{}: // arrays
+		for i := range v {
+			v[i] = adminQueryValue(v[i])
+		}
+		return v
+	case map[string]interface{}: // json, jsonb
+		return v
+	case driver.Valuer: // numeric, interval, time, keeping their exact text
+		text, err := v.Value()
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return text
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
 
-	// Send response
-	resp := QueryResponse{
-		Columns: columnNames,
-		Rows:    resultRows,
+// adminQueryFloat keeps NaN and Infinity as strings, JSON has no numbers for them
+func adminQueryFloat(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	return v
 }
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	maxSavedQueryName     = 100 // admin_saved_queries.name is VARCHAR(100)
+	maxSavedQueryParams   = 20
+	maxAdminQueryLogLimit = 500
+)
+
+// Parameter names of saved queries, e.g. email or created_after
+var savedQueryParamName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// SavedQueryRequest creates or updates a saved query, the query uses $1 for the first param
+type SavedQueryRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Query       string   `json:"query"`
+	Params      []string `json:"params"`
+}
+
+// RunSavedQueryRequest runs a saved query with a value for each of its params
+type RunSavedQueryRequest struct {
+	ID     int32             `json:"id"`
+	Params map[string]string `json:"params"`
+	Format string            `json:"format"` // json (default), csv or ndjson
+}
+
+// SavedQueryDTO represents a saved query for JSON response
+type SavedQueryDTO struct {
+	ID          int32    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Query       string   `json:"query"`
+	Params      []string `json:"params"`
+	CreatedBy   int32    `json:"created_by,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+func newSavedQueryDTO(query db.AdminSavedQuery) SavedQueryDTO {
+	return SavedQueryDTO{
+		ID:          query.ID,
+		Name:        query.Name,
+		Description: query.Description,
+		Query:       query.Query,
+		Params:      query.Params,
+		CreatedBy:   query.CreatedBy.Int32,
+		CreatedAt:   query.CreatedAt.Time.Format(time.RFC3339),
+		UpdatedAt:   query.UpdatedAt.Time.Format(time.RFC3339),
+	}
+}
+
+// AdminQueryLogDTO represents a run of the SQL console for JSON response
+type AdminQueryLogDTO struct {
+	ID           int64           `json:"id"`
+	UserID       int32           `json:"user_id,omitempty"`
+	Email        string          `json:"email"`
+	SavedQueryID int32           `json:"saved_query_id,omitempty"`
+	Query        string          `json:"query"`
+	Params       json.RawMessage `json:"params,omitempty"`
+	Format       string          `json:"format"`
+	DurationMs   int32           `json:"duration_ms"`
+	RowCount     int32           `json:"row_count"`
+	Truncated    bool            `json:"truncated"`
+	Error        string          `json:"error,omitempty"`
+	CreatedAt    string          `json:"created_at"`
+}
+
+// validate trims the request and reports the first problem
+func (req *SavedQueryRequest) validate() string {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Name == "" || len(req.Name) > maxSavedQueryName {
+		return fmt.Sprintf("Name must be between 1 and %d characters", maxSavedQueryName)
+	}
+	if req.Query == "" {
+		return "Query is required"
+	}
+	if len(req.Params) > maxSavedQueryParams {
+		return fmt.Sprintf("At most %d params are allowed", maxSavedQueryParams)
+	}
+	for i, param := range req.Params {
+		if !savedQueryParamName.MatchString(param) {
+			return "Invalid param name " + param
+		}
+		if slices.Contains(req.Params[:i], param) {
+			return "Duplicate param " + param
+		}
+	}
+	if req.Params == nil {
+		req.Params = []string{}
+	}
+	return ""
+}
+
+// writeSavedQueryError answers a failed create or update, names are unique
+func writeSavedQueryError(w http.ResponseWriter, err error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		http.Error(w, "A saved query with that name already exists", http.StatusConflict)
+		return
+	}
+	http.Error(w, "Failed to save query", http.StatusInternalServerError)
+}
+
+// savedQueryID reads the id query parameter
+func savedQueryID(r *http.Request) (int32, error) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	return int32(id), err
+}
+
+// Get all saved queries
+func (conn ConnectionDataAdmin) getSavedQueries(w http.ResponseWriter, r *http.Request) {
+	queries, err := conn.queries.ListAdminSavedQueries(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get saved queries", http.StatusInternalServerError)
+		return
+	}
+
+	queryDTOs := make([]SavedQueryDTO, len(queries))
+	for i, query := range queries {
+		queryDTOs[i] = newSavedQueryDTO(query)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queryDTOs)
+}
+
+// Save a named query
+func (conn ConnectionDataAdmin) createSavedQuery(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SavedQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if problem := req.validate(); problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	query, err := conn.queries.CreateAdminSavedQuery(r.Context(), db.CreateAdminSavedQueryParams{
+		Name:        req.Name,
+		Description: req.Description,
+		Query:       req.Query,
+		Params:      req.Params,
+		CreatedBy:   pgtype.Int4{Int32: int32(userID), Valid: true},
+	})
+	if err != nil {
+		writeSavedQueryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newSavedQueryDTO(query))
+}
+
+// Update a saved query, every field is replaced
+func (conn ConnectionDataAdmin) updateSavedQuery(w http.ResponseWriter, r *http.Request) {
+	id, err := savedQueryID(r)
+	if err != nil {
+		http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+		return
+	}
+
+	var req SavedQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if problem := req.validate(); problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	query, err := conn.queries.UpdateAdminSavedQuery(r.Context(), db.UpdateAdminSavedQueryParams{
+		ID:          id,
+		Name:        req.Name,
+		Description: req.Description,
+		Query:       req.Query,
+		Params:      req.Params,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Saved query not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeSavedQueryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSavedQueryDTO(query))
+}
+
+// Delete a saved query, its runs stay in the log
+func (conn ConnectionDataAdmin) deleteSavedQuery(w http.ResponseWriter, r *http.Request) {
+	id, err := savedQueryID(r)
+	if err != nil {
+		http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := conn.queries.DeleteAdminSavedQuery(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to delete saved query", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Saved query not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Saved query deleted successfully"})
+}
+
+// Run a saved query, its params are passed as $1, $2... in the order they were declared
+func (conn ConnectionDataAdmin) runSavedQuery(w http.ResponseWriter, r *http.Request) {
+	var req RunSavedQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	saved, err := conn.queries.GetAdminSavedQuery(r.Context(), req.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Saved query not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get saved query", http.StatusInternalServerError)
+		return
+	}
+
+	for name := range req.Params {
+		if !slices.Contains(saved.Params, name) {
+			http.Error(w, "Unknown param "+name, http.StatusBadRequest)
+			return
+		}
+	}
+	args := make([]interface{}, len(saved.Params))
+	for i, name := range saved.Params {
+		value, ok := req.Params[name]
+		if !ok {
+			http.Error(w, "Missing param "+name, http.StatusBadRequest)
+			return
+		}
+		args[i] = value // sent as text, Postgres converts it to the parameter's type
+	}
+	if req.Params == nil {
+		req.Params = map[string]string{}
+	}
+
+	conn.serveAdminQuery(w, r, &adminQueryRun{
+		SavedQueryID: saved.ID,
+		Query:        saved.Query,
+		Params:       req.Params,
+		Args:         args,
+		Format:       req.Format,
+	})
+}
+
+// Get the latest runs of the SQL console, optionally of one admin
+func (conn ConnectionData) getAdminQueryLog(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxAdminQueryLogLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAdminQueryLogLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := conn.queries.ListAdminQueryLog(r.Context(), db.ListAdminQueryLogParams{
+		Email:    r.URL.Query().Get("email"),
+		RowLimit: int32(limit),
+	})
+	if err != nil {
+		http.Error(w, "Failed to get query log", http.StatusInternalServerError)
+		return
+	}
+
+	entryDTOs := make([]AdminQueryLogDTO, len(entries))
+	for i, entry := range entries {
+		entryDTOs[i] = AdminQueryLogDTO{
+			ID:           entry.ID,
+			UserID:       entry.UserID.Int32,
+			Email:        entry.Email,
+			SavedQueryID: entry.SavedQueryID.Int32,
+			Query:        entry.Query,
+			Params:       entry.Params,
+			Format:       entry.Format,
+			DurationMs:   entry.DurationMs,
+			RowCount:     entry.RowCount,
+			Truncated:    entry.Truncated,
+			Error:        entry.Error.String,
+			CreatedAt:    entry.CreatedAt.Time.Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entryDTOs)
+}
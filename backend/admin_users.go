@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultAdminUsersLimit = 50
+	maxAdminUsersLimit     = 200
+)
+
+var errOwnAccount = errors.New("admins can't disable or delete their own account")
+
+// UserAccountDTO represents a user account for JSON response
+type UserAccountDTO struct {
+	ID              int32  `json:"id"`
+	Username        string `json:"username"`
+	Email           string `json:"email"`
+	CreatedAt       string `json:"created_at"`
+	EmailVerifiedAt string `json:"email_verified_at,omitempty"`
+	DisabledAt      string `json:"disabled_at,omitempty"`
+}
+
+// UserPageDTO is one page of the user list
+type UserPageDTO struct {
+	Users  []UserAccountDTO `json:"users"`
+	Total  int64            `json:"total"`
+	Limit  int              `json:"limit"`
+	Offset int              `json:"offset"`
+}
+
+// UserDetailsDTO is a user account with what it owns and where it's signed in
+type UserDetailsDTO struct {
+	UserAccountDTO
+	Rooms    int64        `json:"rooms"`
+	Folders  int64        `json:"folders"`
+	Notes    int64        `json:"notes"`
+	Roles    []string     `json:"roles"`
+	Sessions []SessionDTO `json:"sessions"`
+}
+
+func newUserAccountDTO(user db.GetUserAccountRow) UserAccountDTO {
+	dto := UserAccountDTO{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		CreatedAt: user.CreatedAt.Time.Format(time.RFC3339),
+	}
+	if user.EmailVerifiedAt.Valid {
+		dto.EmailVerifiedAt = user.EmailVerifiedAt.Time.Format(time.RFC3339)
+	}
+	if user.DisabledAt.Valid {
+		dto.DisabledAt = user.DisabledAt.Time.Format(time.RFC3339)
+	}
+	return dto
+}
+
+// userSearchPattern turns the search into an ILIKE pattern, its own % and _ match literally
+func userSearchPattern(search string) string {
+	search = strings.TrimSpace(search)
+	if search == "" {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + escaper.Replace(search) + "%"
+}
+
+// queryInt reads an optional integer query parameter between min and max
+func queryInt(r *http.Request, name string, fallback, minValue, maxValue int) (int, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return fallback, nil
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil || value < minValue || value > maxValue {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, minValue, maxValue)
+	}
+	return value, nil
+}
+
+// adminTargetUser reads the id query parameter and looks the user up, answering when it fails
+func (conn ConnectionData) adminTargetUser(w http.ResponseWriter, r *http.Request) (db.GetUserAccountRow, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+		return db.GetUserAccountRow{}, false
+	}
+
+	user, err := conn.queries.GetUserAccount(r.Context(), int32(id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return db.GetUserAccountRow{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return db.GetUserAccountRow{}, false
+	}
+	return user, true
+}
+
+// checkCanRemoveUser keeps admins from locking out themselves or the last admin
+func checkCanRemoveUser(ctx context.Context, queries *db.Queries, adminID, userID int32) error {
+	if adminID == userID {
+		return errOwnAccount
+	}
+
+	userRoles, err := queries.ListUserRoles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(userRoles, func(userRole db.ListUserRolesRow) bool { return userRole.Role == RoleAdmin }) {
+		return nil
+	}
+
+	role, err := queries.GetRoleByName(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	admins, err := queries.CountUsersWithRole(ctx, role.ID)
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+// writeRemoveUserError answers the errors of checkCanRemoveUser
+func writeRemoveUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errOwnAccount):
+		http.Error(w, "You can't disable or delete your own account", http.StatusBadRequest)
+	case errors.Is(err, errLastAdmin):
+		http.Error(w, "The last admin can't be disabled or deleted", http.StatusConflict)
+	default:
+		fmt.Printf("Failed to check admin roles: %v\n", err)
+		http.Error(w, "Failed to check roles", http.StatusInternalServerError)
+	}
+}
+
+// signOutEverywhere deletes every session and personal access token of the user and closes their WebSockets
+func (conn ConnectionData) signOutEverywhere(ctx context.Context, userID int32) error {
+	if err := DeleteAllSessions(ctx, conn.queries, userID); err != nil {
+		return err
+	}
+	conn.hub.RevokeSessions(userID, allSessions)
+	return conn.queries.DeleteAllPersonalAccessTokensForUser(ctx, userID)
+}
+
+// Get a page of users, newest first, optionally matching search in the username or email
+func (conn ConnectionData) getAdminUsers(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultAdminUsersLimit, 1, maxAdminUsersLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1<<31-1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	search := userSearchPattern(r.URL.Query().Get("search"))
+
+	users, err := conn.queries.SearchUsers(r.Context(), db.SearchUsersParams{
+		Search:    search,
+		RowLimit:  int32(limit),
+		RowOffset: int32(offset),
+	})
+	if err != nil {
+		http.Error(w, "Failed to list users", http.StatusInternalServerError)
+		return
+	}
+	total, err := conn.queries.CountUsers(r.Context(), search)
+	if err != nil {
+		http.Error(w, "Failed to list users", http.StatusInternalServerError)
+		return
+	}
+
+	page := UserPageDTO{
+		Users:  make([]UserAccountDTO, len(users)),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	for i, user := range users {
+		page.Users[i] = newUserAccountDTO(db.GetUserAccountRow(user))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// Get a user with their roles, active sessions and how many rooms, folders and notes they own
+func (conn ConnectionData) getAdminUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := conn.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	counts, err := conn.queries.GetUserContentCounts(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to count user content", http.StatusInternalServerError)
+		return
+	}
+	userRoles, err := conn.queries.ListUserRoles(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to get roles", http.StatusInternalServerError)
+		return
+	}
+	sessions, err := GetSessionForUser(r.Context(), conn.queries, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to get sessions", http.StatusInternalServerError)
+		return
+	}
+
+	details := UserDetailsDTO{
+		UserAccountDTO: newUserAccountDTO(user),
+		Rooms:          counts.Rooms,
+		Folders:        counts.Folders,
+		Notes:          counts.Notes,
+		Roles:          make([]string, len(userRoles)),
+		Sessions:       make([]SessionDTO, len(sessions)),
+	}
+	for i, userRole := range userRoles {
+		details.Roles[i] = userRole.Role
+	}
+	for i, session := range sessions {
+		details.Sessions[i] = newSessionDTO(session)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
+
+// Disable a user, they are signed out everywhere and can't sign in until enabled again
+func (conn ConnectionData) disableUser(w http.ResponseWriter, r *http.Request) {
+	adminID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	user, ok := conn.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	if err := checkCanRemoveUser(r.Context(), conn.queries, int32(adminID), user.ID); err != nil {
+		writeRemoveUserError(w, err)
+		return
+	}
+
+	disabled, err := conn.queries.DisableUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to disable user", http.StatusInternalServerError)
+		return
+	}
+	if err := conn.signOutEverywhere(r.Context(), user.ID); err != nil {
+		fmt.Printf("Failed to sign out disabled user %d: %v\n", user.ID, err)
+		http.Error(w, "Failed to sign out user", http.StatusInternalServerError)
+		return
+	}
+	if disabled == 0 {
+		json.NewEncoder(w).Encode(map[string]string{"message": "User is already disabled"})
+		return
+	}
+	fmt.Printf("User %s disabled by %s\n", user.Email, r.Header.Get("email"))
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "User disabled successfully"})
+}
+
+// Enable a disabled user
+func (conn ConnectionData) enableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := conn.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	enabled, err := conn.queries.EnableUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to enable user", http.StatusInternalServerError)
+		return
+	}
+	if enabled == 0 {
+		json.NewEncoder(w).Encode(map[string]string{"message": "User is not disabled"})
+		return
+	}
+	fmt.Printf("User %s enabled by %s\n", user.Email, r.Header.Get("email"))
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "User enabled successfully"})
+}
+
+// Sign a user out of every session, their personal access tokens keep working
+func (conn ConnectionData) logoutUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := conn.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := DeleteAllSessions(r.Context(), conn.queries, user.ID); err != nil {
+		http.Error(w, "Failed to delete sessions", http.StatusInternalServerError)
+		return
+	}
+	conn.hub.RevokeSessions(user.ID, allSessions)
+	fmt.Printf("User %s signed out everywhere by %s\n", user.Email, r.Header.Get("email"))
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "User signed out successfully"})
+}
+
+// Force a password reset: the password stops working, the user is signed out and emailed a reset link
+func (conn ConnectionData) forcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := conn.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Like provisionOIDCUser, a random password nobody knows
+	randomPassword, err := GenerateSessionToken()
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	hash, err := HashPassword(randomPassword)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	err = conn.queries.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
+		ID:           user.ID,
+		PasswordHash: hash,
+	})
+	if err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	if err := conn.signOutEverywhere(r.Context(), user.ID); err != nil {
+		fmt.Printf("Failed to sign out user %d after forced password reset: %v\n", user.ID, err)
+		http.Error(w, "Failed to sign out user", http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Password reset of %s forced by %s\n", user.Email, r.Header.Get("email"))
+
+	if err := conn.sendPasswordResetEmail(r.Context(), user.Email); err != nil {
+		fmt.Printf("Failed to send forced password reset email to %s: %v\n", user.Email, err)
+		http.Error(w, "Password was reset but the email could not be sent", http.StatusBadGateway)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset email sent"})
+}
+
+// Delete a user and everything they own
+func (conn ConnectionData) deleteUser(w http.ResponseWriter, r *http.Request) {
+	adminID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	user, ok := conn.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	if err := checkCanRemoveUser(r.Context(), conn.queries, int32(adminID), user.ID); err != nil {
+		writeRemoveUserError(w, err)
+		return
+	}
+
+	deleted, err := conn.queries.DeleteUser(r.Context(), user.ID)
+	if err != nil {
+		fmt.Printf("Failed to delete user %d: %v\n", user.ID, err)
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	conn.hub.RevokeSessions(user.ID, allSessions)
+	fmt.Printf("User %s deleted by %s\n", user.Email, r.Header.Get("email"))
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "User deleted successfully"})
+}
@@ -2,13 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"steamednotes/db"
 	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 func (conn ConnectionData) getNote(w http.ResponseWriter, r *http.Request) {
@@ -34,15 +36,9 @@ func (conn ConnectionData) getNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	note, err := conn.queries.FindNotesById(r.Context(), int32(noteID))
-
-	if note.UserID != int32(iuserID) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
+	note, err := conn.authz.CanReadNote(r.Context(), int32(iuserID), int32(noteID))
 	if err != nil {
-		http.Error(w, "Error in getting note by id", http.StatusBadRequest)
+		writeAuthzError(w, err, "Note")
 		return
 	}
 
@@ -72,17 +68,36 @@ func (conn ConnectionData) updateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = conn.queries.UpdateNoteNameAndContent(r.Context(), db.UpdateNoteNameAndContentParams{
+	note, err := conn.authz.CanWriteNote(r.Context(), int32(iuserID), noteUpdate.ID)
+	if err != nil {
+		writeAuthzError(w, err, "Note")
+		return
+	}
+
+	updated, err := conn.queries.UpdateNoteNameAndContent(r.Context(), db.UpdateNoteNameAndContentParams{
 		Title:   noteUpdate.Title,
 		Content: noteUpdate.Content,
 		ID:      noteUpdate.ID,
-		UserID:  int32(iuserID),
 	})
 
 	if err != nil {
 		http.Error(w, "Something went wrong, unable to update note", http.StatusBadRequest)
+		return
+	}
+	if updated == 0 {
+		// Deleted since the access check
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
 	}
 
+	// Keep the collaborative doc in line, whoever is editing the note gets the change
+	if err := conn.collab.SetText(r.Context(), noteUpdate.ID, noteUpdate.Content); err != nil {
+		fmt.Printf("Failed to update collaborative doc of note %d: %v\n", noteUpdate.ID, err)
+	}
+
+	conn.hub.Publish(wsEvent{Type: wsEventNoteUpdated, RoomID: note.RoomID, FolderID: note.FolderID, NoteID: note.ID, Title: noteUpdate.Title},
+		roomTopic(note.RoomID), folderTopic(note.FolderID), noteTopic(note.ID))
+
 }
 
 func (conn ConnectionData) deleteNote(w http.ResponseWriter, r *http.Request) {
@@ -108,15 +123,25 @@ func (conn ConnectionData) deleteNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = conn.queries.DeleteNote(r.Context(), db.DeleteNoteParams{
-		ID:     int32(noteID),
-		UserID: int32(iuserID),
-	})
+	note, err := conn.authz.CanWriteNote(r.Context(), int32(iuserID), int32(noteID))
+	if err != nil {
+		writeAuthzError(w, err, "Note")
+		return
+	}
+
+	deleted, err := conn.queries.DeleteNote(r.Context(), int32(noteID))
 
 	if err != nil {
 		http.Error(w, "Error deleting note", http.StatusBadRequest)
 		return
 	}
+	if deleted == 0 {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+
+	conn.hub.Publish(wsEvent{Type: wsEventNoteDeleted, RoomID: note.RoomID, FolderID: note.FolderID, NoteID: note.ID},
+		roomTopic(note.RoomID), folderTopic(note.FolderID), noteTopic(note.ID))
 
 }
 
@@ -144,8 +169,12 @@ func (conn ConnectionData) getNotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res, err := conn.queries.FindNotesByFolder(r.Context(),
-		db.FindNotesByFolderParams{FolderID: int32(folderID), UserID: int32(iuserID)})
+	if _, err := conn.authz.CanReadFolder(r.Context(), int32(iuserID), int32(folderID)); err != nil {
+		writeAuthzError(w, err, "Folder")
+		return
+	}
+
+	res, err := conn.queries.FindNotesByFolder(r.Context(), int32(folderID))
 
 	if err != nil {
 		http.Error(w, "Error in getting folders by room", http.StatusBadRequest)
@@ -179,15 +208,9 @@ func (conn ConnectionData) getFolderDetails(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	folder, err := conn.queries.FindFolderById(r.Context(), int32(folderID))
-
+	folder, err := conn.authz.CanReadFolder(r.Context(), int32(iuserID), int32(folderID))
 	if err != nil {
-		http.Error(w, "Error finding folder by id", http.StatusBadRequest)
-		return
-	}
-
-	if folder.UserID != int32(iuserID) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeAuthzError(w, err, "Folder")
 		return
 	}
 
@@ -219,22 +242,16 @@ func (conn ConnectionData) createNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	folder, err := conn.queries.FindFolderById(r.Context(), note.FolderId)
-
+	folder, err := conn.authz.CanWriteFolder(r.Context(), int32(iuserID), note.FolderId)
 	if err != nil {
-		http.Error(w, "Error getting room details", http.StatusBadRequest)
-		return
-	}
-
-	if folder.UserID != int32(iuserID) {
-		http.Error(w, "Unauthorized request - no write access to folder", http.StatusForbidden)
+		writeAuthzError(w, err, "Folder")
 		return
 	}
 
 	res, err := conn.queries.CreateNote(r.Context(),
 		db.CreateNoteParams{
 			RoomID:     folder.RoomID,
-			UserID:     int32(iuserID),
+			UserID:     pgtype.Int4{Int32: int32(iuserID), Valid: true},
 			Title:      note.Name,
 			Content:    "",
 			FolderName: folder.Name,
@@ -247,6 +264,9 @@ func (conn ConnectionData) createNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	conn.hub.Publish(wsEvent{Type: wsEventNoteCreated, RoomID: folder.RoomID, FolderID: folder.ID, NoteID: res.ID, Title: note.Name},
+		roomTopic(folder.RoomID), folderTopic(folder.ID))
+
 	json.NewEncoder(w).Encode(res)
 
 }
@@ -259,70 +279,91 @@ func (conn ConnectionData) signIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("Attempting login for email: %s\n", creds.Email)
-	user, err := conn.queries.FindUserByEmail(r.Context(), creds.Email)
+	ctx := r.Context()
+	if wait := signInLockedFor(ctx, conn.queries, creds.Email, GetClientIP(r)); wait > 0 {
+		fmt.Printf("Sign in for %s is locked for %v\n", creds.Email, wait)
+		recordSignInAttempt(ctx, conn.queries, r, creds.Email, 0, "locked")
+		writeSignInLocked(w, wait)
+		return
+	}
 
+	fmt.Printf("Attempting login for email: %s\n", creds.Email)
+	user, err := conn.queries.FindUserByEmail(ctx, creds.Email)
 	if err != nil {
-		fmt.Printf("Error finding user by email: %v\n", err)
-		http.Error(w, "Invalid email", http.StatusInternalServerError)
+		if !errors.Is(err, pgx.ErrNoRows) {
+			fmt.Printf("Error finding user by email: %v\n", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		// Same work and same answer as a wrong password, so emails can't be probed
+		fmt.Printf("No user with email %s\n", creds.Email)
+		VerifyDummyPassword(creds.Password)
+		recordSignInFailure(ctx, conn.queries, r, creds.Email, 0, "unknown_email")
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
-	} else {
-		fmt.Printf("Found user with email %s, ID: %d\n", creds.Email, user.ID)
-		fmt.Printf("Stored password hash: %s\n", user.PasswordHash)
-		fmt.Printf("Provided password: %s\n", creds.Password)
 	}
 
-	if user.PasswordHash == creds.Password {
-		fmt.Printf("Password match for user %s\n", creds.Email)
-		// Create session in database
-		session, err := CreateSession(r.Context(), conn.queries, user.ID, r)
-		if err != nil {
-			fmt.Printf("Failed to create session: %v\n", err)
-			http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
-			return
+	match, needsRehash := VerifyPassword(user.PasswordHash, creds.Password)
+	if !match {
+		fmt.Printf("Password mismatch for user %s\n", creds.Email)
+		recordSignInFailure(ctx, conn.queries, r, creds.Email, user.ID, "bad_password")
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	fmt.Printf("Password match for user %s\n", creds.Email)
+
+	// Only tell who knows the password that the account is disabled
+	if err := CheckUserEnabled(ctx, conn.queries, user.ID); err != nil {
+		if errors.Is(err, errAccountDisabled) {
+			recordSignInAttempt(ctx, conn.queries, r, creds.Email, user.ID, "disabled")
 		}
-		fmt.Printf("Session created successfully with ID %d\n", session.ID)
-
-		// Create JWT with session ID
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-			Email:     creds.Email,
-			ID:        strconv.Itoa(int(user.ID)),
-			SessionID: strconv.Itoa(int(session.ID)),
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
-			},
-		})
-		tokenString, err := token.SignedString(secret)
+		writeSessionError(w, err)
+		return
+	}
+
+	// Upgrade legacy plaintext or weaker hashes in place
+	if needsRehash {
+		if hash, err := HashPassword(creds.Password); err != nil {
+			fmt.Printf("Failed to rehash password for user %d: %v\n", user.ID, err)
+		} else if err := conn.queries.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{
+			ID:           user.ID,
+			PasswordHash: hash,
+		}); err != nil {
+			fmt.Printf("Failed to store rehashed password for user %d: %v\n", user.ID, err)
+		}
+	}
+
+	// With 2FA enabled the password only gets a short-lived token for the second step,
+	// failures are only forgotten once the code is right too
+	totp, err := conn.queries.GetTOTPByUser(ctx, user.ID)
+	if err == nil && totp.EnabledAt.Valid {
+		mfaToken, err := NewMFAPendingToken(user.ID, creds.Email, SessionAuth{})
 		if err != nil {
 			http.Error(w, "Server error", http.StatusInternalServerError)
 			return
 		}
-
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    tokenString,
-			Expires:  time.Now().Add(7 * 24 * time.Hour), // Match session expiry
-			HttpOnly: true,                               // Prevent JS access
-			Path:     "/",
-			Secure:   false, // Allow for HTTP (set to true in production with HTTPS)
-			SameSite: http.SameSiteLaxMode,
+		fmt.Printf("Password match for user %s, waiting for second factor\n", creds.Email)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
 		})
+		return
+	}
 
-		fmt.Printf("Signed in as %s with session %d\n", creds.Email, session.ID)
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"username":   user.Username,
-			"session_id": strconv.Itoa(int(session.ID)),
-		})
+	// Create session in database and set the JWT cookie
+	session, err := IssueSessionCookie(w, r, conn.queries, user.ID, creds.Email, SessionAuth{})
+	if err != nil {
+		writeSessionError(w, err)
 		return
-	} else {
-		fmt.Printf("Password mismatch for user %s. Expected: %s, Got: %s\n", creds.Email, user.PasswordHash, creds.Password)
 	}
-	http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-}
+	recordSignInSuccess(ctx, conn.queries, r, creds.Email, user.ID)
 
-func createUser(w http.ResponseWriter, r *http.Request) {
+	fmt.Printf("Signed in as %s with session %d\n", creds.Email, session.ID)
 
+	json.NewEncoder(w).Encode(map[string]string{
+		"username":   user.Username,
+		"session_id": strconv.Itoa(int(session.ID)),
+	})
 }
 
 type CreateRoomRequest struct {
@@ -406,17 +447,16 @@ func (conn ConnectionData) createFolder(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	room, err := conn.queries.FindRoomById(r.Context(), folder.RoomId)
-
+	room, err := conn.authz.CanWriteRoom(r.Context(), int32(iuserID), folder.RoomId)
 	if err != nil {
-		http.Error(w, "Error getting room details", http.StatusBadRequest)
+		writeAuthzError(w, err, "Room")
 		return
 	}
 
 	res, err := conn.queries.CreateFolder(r.Context(),
 		db.CreateFolderParams{
 			RoomID:   folder.RoomId,
-			UserID:   int32(iuserID),
+			UserID:   pgtype.Int4{Int32: int32(iuserID), Valid: true},
 			Name:     folder.Name,
 			RoomName: room.Name})
 
@@ -425,6 +465,8 @@ func (conn ConnectionData) createFolder(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	conn.hub.Publish(wsEvent{Type: wsEventFolderCreated, RoomID: room.ID, FolderID: res.ID, Name: folder.Name}, roomTopic(room.ID))
+
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -452,8 +494,12 @@ func (conn ConnectionData) getFoldersByRoom(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	res, err := conn.queries.FindFoldersByRoom(r.Context(),
-		db.FindFoldersByRoomParams{RoomID: int32(roomID), UserID: int32(iuserID)})
+	if _, err := conn.authz.CanReadRoom(r.Context(), int32(iuserID), int32(roomID)); err != nil {
+		writeAuthzError(w, err, "Room")
+		return
+	}
+
+	res, err := conn.queries.FindFoldersByRoom(r.Context(), int32(roomID))
 
 	if err != nil {
 		http.Error(w, "Error in getting folders by room", http.StatusBadRequest)
@@ -465,6 +511,7 @@ func (conn ConnectionData) getFoldersByRoom(w http.ResponseWriter, r *http.Reque
 
 type RoomDetailsRes struct {
 	RoomName string `json:"room_name"`
+	IsOwner  bool   `json:"is_owner"` // only the owner manages members and invite links
 }
 
 func (conn ConnectionData) getRoomDetails(w http.ResponseWriter, r *http.Request) {
@@ -491,19 +538,13 @@ func (conn ConnectionData) getRoomDetails(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	room, err := conn.queries.FindRoomById(r.Context(), int32(roomID))
-
+	room, err := conn.authz.CanReadRoom(r.Context(), int32(iuserID), int32(roomID))
 	if err != nil {
-		http.Error(w, "Error finding room by id", http.StatusBadRequest)
+		writeAuthzError(w, err, "Room")
 		return
 	}
 
-	if room.UserID != int32(iuserID) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	json.NewEncoder(w).Encode(RoomDetailsRes{RoomName: room.Name})
+	json.NewEncoder(w).Encode(RoomDetailsRes{RoomName: room.Name, IsOwner: room.UserID == int32(iuserID)})
 }
 
 func (conn ConnectionData) logout(w http.ResponseWriter, r *http.Request) {
@@ -521,6 +562,7 @@ func (conn ConnectionData) logout(w http.ResponseWriter, r *http.Request) {
 				ID:     int32(sessionID),
 				UserID: int32(userID),
 			})
+			conn.hub.RevokeSessions(int32(userID), func(id int32) bool { return id == int32(sessionID) })
 		}
 	}
 
@@ -531,6 +573,8 @@ func (conn ConnectionData) logout(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   -1,              // Immediately delete in all browsers
 		HttpOnly: true,
 		Path:     "/",
+		Domain:   appConfig.Cookie.Domain,
+		Secure:   appConfig.Cookie.Secure,
 	})
 }
 
@@ -550,6 +594,35 @@ type SessionDTO struct {
 	LastUsedAt     string `json:"last_used_at"`
 	ExpiresAt      string `json:"expires_at"`
 	IsActive       bool   `json:"is_active"`
+	MFAUsed        bool   `json:"mfa_used"`
+	AuthMethod     string `json:"auth_method"`
+	AuthProvider   string `json:"auth_provider,omitempty"`
+}
+
+func newSessionDTO(session db.UserSession) SessionDTO {
+	ipAddress := ""
+	if session.IpAddress != nil {
+		ipAddress = session.IpAddress.String()
+	}
+	return SessionDTO{
+		ID:             session.ID,
+		UserID:         session.UserID,
+		DeviceName:     session.DeviceName.String,
+		DeviceType:     session.DeviceType.String,
+		BrowserName:    session.BrowserName.String,
+		BrowserVersion: session.BrowserVersion.String,
+		OSName:         session.OsName.String,
+		OSVersion:      session.OsVersion.String,
+		IPAddress:      ipAddress,
+		UserAgent:      session.UserAgent.String,
+		CreatedAt:      session.CreatedAt.Time.Format(time.RFC3339),
+		LastUsedAt:     session.LastUsedAt.Time.Format(time.RFC3339),
+		ExpiresAt:      session.ExpiresAt.Time.Format(time.RFC3339),
+		IsActive:       session.IsActive.Bool,
+		MFAUsed:        session.MfaUsed,
+		AuthMethod:     session.AuthMethod,
+		AuthProvider:   session.AuthProvider.String,
+	}
 }
 
 // Get all sessions for the current user
@@ -576,22 +649,7 @@ func (conn ConnectionData) getSessions(w http.ResponseWriter, r *http.Request) {
 	// Convert to clean DTOs
 	sessionDTOs := make([]SessionDTO, len(sessions))
 	for i, session := range sessions {
-		sessionDTOs[i] = SessionDTO{
-			ID:             session.ID,
-			UserID:         session.UserID,
-			DeviceName:     session.DeviceName.String,
-			DeviceType:     session.DeviceType.String,
-			BrowserName:    session.BrowserName.String,
-			BrowserVersion: session.BrowserVersion.String,
-			OSName:         session.OsName.String,
-			OSVersion:      session.OsVersion.String,
-			IPAddress:      session.IpAddress.String(),
-			UserAgent:      session.UserAgent.String,
-			CreatedAt:      session.CreatedAt.Time.Format(time.RFC3339),
-			LastUsedAt:     session.LastUsedAt.Time.Format(time.RFC3339),
-			ExpiresAt:      session.ExpiresAt.Time.Format(time.RFC3339),
-			IsActive:       session.IsActive.Bool,
-		}
+		sessionDTOs[i] = newSessionDTO(session)
 
 		fmt.Printf("Session %d: ID=%d, Device=%s, Browser=%s, Active=%v\n",
 			i+1, session.ID, session.DeviceName.String, session.BrowserName.String, session.IsActive.Bool)
@@ -628,6 +686,7 @@ func (conn ConnectionData) deleteSession(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Failed to delete session", http.StatusInternalServerError)
 		return
 	}
+	conn.hub.RevokeSessions(int32(userID), func(id int32) bool { return id == int32(sessionID) })
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Session deleted successfully"})
@@ -654,6 +713,7 @@ func (conn ConnectionData) deleteAllOtherSessions(w http.ResponseWriter, r *http
 		http.Error(w, "Failed to delete other sessions", http.StatusInternalServerError)
 		return
 	}
+	conn.hub.RevokeSessions(int32(userID), func(id int32) bool { return id != int32(currentSessionID) })
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Other sessions deleted successfully"})
@@ -693,15 +753,26 @@ func (conn ConnectionData) changePassword(w http.ResponseWriter, r *http.Request
 	}
 
 	// Verify current password
-	if user.PasswordHash != req.CurrentPassword {
+	if match, _ := VerifyPassword(user.PasswordHash, req.CurrentPassword); !match {
 		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
 		return
 	}
 
+	if err := ValidatePassword(req.NewPassword, user.Username, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
 	// Update password (keeping sessions active)
 	err = conn.queries.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
 		ID:           int32(userID),
-		PasswordHash: req.NewPassword,
+		PasswordHash: hash,
 	})
 	if err != nil {
 		http.Error(w, "Failed to update password", http.StatusInternalServerError)
@@ -713,5 +784,13 @@ func (conn ConnectionData) changePassword(w http.ResponseWriter, r *http.Request
 }
 
 func isSignedIn(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(map[string]string{"Result": "Success"})
+	res := map[string]interface{}{
+		"Result":        "Success",
+		"impersonating": isImpersonating(r), // the frontend shows a banner
+	}
+	if isImpersonating(r) {
+		res["email"] = r.Header.Get("email")
+		res["impersonator_email"] = r.Header.Get("impersonator_email")
+	}
+	json.NewEncoder(w).Encode(res)
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"steamednotes/db"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Access is what a user may do with a room and everything in it, each level includes the ones below
+type Access int
+
+const (
+	AccessNone    Access = iota
+	AccessRead           // viewers
+	AccessComment        // commenters
+	AccessWrite          // editors
+	AccessOwner          // the owner, who also manages the members
+)
+
+// Roles of room_members (see V019)
+const (
+	RoomRoleOwner     = "owner"
+	RoomRoleEditor    = "editor"
+	RoomRoleCommenter = "commenter"
+	RoomRoleViewer    = "viewer"
+)
+
+var roomRoleAccess = map[string]Access{
+	RoomRoleOwner:     AccessOwner,
+	RoomRoleEditor:    AccessWrite,
+	RoomRoleCommenter: AccessComment,
+	RoomRoleViewer:    AccessRead,
+}
+
+var (
+	// errNotFound is returned for missing resources and for resources the user has no access to,
+	// so the ids of other users' rooms, folders and notes can't be probed
+	errNotFound  = errors.New("not found")
+	errForbidden = errors.New("forbidden") // the user can read the resource but not change it
+)
+
+// Authorizer is the one place that decides who may read and write rooms, folders and notes.
+// Access is decided per room by room_members, folders and notes get the access of the room they are in.
+type Authorizer struct {
+	queries authzQueries
+}
+
+// authzQueries are the queries the Authorizer decides with, *db.Queries implements them
+type authzQueries interface {
+	FindRoomById(ctx context.Context, id int32) (db.Room, error)
+	FindFolderById(ctx context.Context, id int32) (db.Folder, error)
+	FindNotesById(ctx context.Context, id int32) (db.Note, error)
+	GetRoomMemberRole(ctx context.Context, arg db.GetRoomMemberRoleParams) (string, error)
+}
+
+func NewAuthorizer(queries authzQueries) *Authorizer {
+	return &Authorizer{queries: queries}
+}
+
+// RoomAccess is the user's access to the room, from their role if they accepted an invitation to it
+func (authz *Authorizer) RoomAccess(ctx context.Context, userID int32, room db.Room) (Access, error) {
+	if room.UserID == userID {
+		return AccessOwner, nil
+	}
+	role, err := authz.queries.GetRoomMemberRole(ctx, db.GetRoomMemberRoleParams{RoomID: room.ID, UserID: userID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return AccessNone, nil
+	}
+	if err != nil {
+		return AccessNone, err
+	}
+	return roomRoleAccess[role], nil
+}
+
+// checkRoom turns the user's access to the room into errNotFound or errForbidden when it isn't enough
+func (authz *Authorizer) checkRoom(ctx context.Context, userID int32, room db.Room, need Access) error {
+	access, err := authz.RoomAccess(ctx, userID, room)
+	if err != nil {
+		return err
+	}
+	if access == AccessNone {
+		return errNotFound
+	}
+	if access < need {
+		return errForbidden
+	}
+	return nil
+}
+
+func (authz *Authorizer) room(ctx context.Context, userID, roomID int32, need Access) (db.Room, error) {
+	room, err := authz.queries.FindRoomById(ctx, roomID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Room{}, errNotFound
+	}
+	if err != nil {
+		return db.Room{}, err
+	}
+	if err := authz.checkRoom(ctx, userID, room, need); err != nil {
+		return db.Room{}, err
+	}
+	return room, nil
+}
+
+func (authz *Authorizer) folder(ctx context.Context, userID, folderID int32, need Access) (db.Folder, error) {
+	folder, err := authz.queries.FindFolderById(ctx, folderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Folder{}, errNotFound
+	}
+	if err != nil {
+		return db.Folder{}, err
+	}
+	if _, err := authz.room(ctx, userID, folder.RoomID, need); err != nil {
+		return db.Folder{}, err
+	}
+	return folder, nil
+}
+
+func (authz *Authorizer) note(ctx context.Context, userID, noteID int32, need Access) (db.Note, error) {
+	note, err := authz.queries.FindNotesById(ctx, noteID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Note{}, errNotFound
+	}
+	if err != nil {
+		return db.Note{}, err
+	}
+	if _, err := authz.room(ctx, userID, note.RoomID, need); err != nil {
+		return db.Note{}, err
+	}
+	return note, nil
+}
+
+// CanReadRoom returns the room when the user may see it and what is in it
+func (authz *Authorizer) CanReadRoom(ctx context.Context, userID, roomID int32) (db.Room, error) {
+	return authz.room(ctx, userID, roomID, AccessRead)
+}
+
+// CanWriteRoom returns the room when the user may create folders and notes in it
+func (authz *Authorizer) CanWriteRoom(ctx context.Context, userID, roomID int32) (db.Room, error) {
+	return authz.room(ctx, userID, roomID, AccessWrite)
+}
+
+// CanManageRoom returns the room when the user may invite, change and remove its members
+func (authz *Authorizer) CanManageRoom(ctx context.Context, userID, roomID int32) (db.Room, error) {
+	return authz.room(ctx, userID, roomID, AccessOwner)
+}
+
+// CanReadFolder returns the folder when the user may see it and its notes
+func (authz *Authorizer) CanReadFolder(ctx context.Context, userID, folderID int32) (db.Folder, error) {
+	return authz.folder(ctx, userID, folderID, AccessRead)
+}
+
+// CanWriteFolder returns the folder when the user may create notes in it
+func (authz *Authorizer) CanWriteFolder(ctx context.Context, userID, folderID int32) (db.Folder, error) {
+	return authz.folder(ctx, userID, folderID, AccessWrite)
+}
+
+// CanReadNote returns the note when the user may see it
+func (authz *Authorizer) CanReadNote(ctx context.Context, userID, noteID int32) (db.Note, error) {
+	return authz.note(ctx, userID, noteID, AccessRead)
+}
+
+// CanWriteNote returns the note when the user may change or delete it
+func (authz *Authorizer) CanWriteNote(ctx context.Context, userID, noteID int32) (db.Note, error) {
+	return authz.note(ctx, userID, noteID, AccessWrite)
+}
+
+// writeAuthzError answers a failed Can* check, resource is e.g. "Note"
+func writeAuthzError(w http.ResponseWriter, err error, resource string) {
+	switch {
+	case errors.Is(err, errNotFound):
+		http.Error(w, resource+" not found", http.StatusNotFound)
+	case errors.Is(err, errForbidden):
+		http.Error(w, "No write access to this "+strings.ToLower(resource), http.StatusForbidden)
+	default:
+		fmt.Printf("Failed to check access to %s: %v\n", resource, err)
+		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+	}
+}
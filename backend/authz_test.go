@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"steamednotes/db"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// fakeAuthzQueries answers like the database, members only count once they accepted
+type fakeAuthzQueries struct {
+	rooms   map[int32]db.Room
+	folders map[int32]db.Folder
+	notes   map[int32]db.Note
+	members map[db.GetRoomMemberRoleParams]fakeRoomMember
+}
+
+type fakeRoomMember struct {
+	role     string
+	accepted bool
+}
+
+func (q fakeAuthzQueries) FindRoomById(ctx context.Context, id int32) (db.Room, error) {
+	room, ok := q.rooms[id]
+	if !ok {
+		return db.Room{}, pgx.ErrNoRows
+	}
+	return room, nil
+}
+
+func (q fakeAuthzQueries) FindFolderById(ctx context.Context, id int32) (db.Folder, error) {
+	folder, ok := q.folders[id]
+	if !ok {
+		return db.Folder{}, pgx.ErrNoRows
+	}
+	return folder, nil
+}
+
+func (q fakeAuthzQueries) FindNotesById(ctx context.Context, id int32) (db.Note, error) {
+	note, ok := q.notes[id]
+	if !ok {
+		return db.Note{}, pgx.ErrNoRows
+	}
+	return note, nil
+}
+
+func (q fakeAuthzQueries) GetRoomMemberRole(ctx context.Context, arg db.GetRoomMemberRoleParams) (string, error) {
+	member, ok := q.members[arg]
+	if !ok || !member.accepted {
+		return "", pgx.ErrNoRows
+	}
+	return member.role, nil
+}
+
+func TestAuthorizer(t *testing.T) {
+	const (
+		owner int32 = iota + 1
+		editor
+		commenter
+		viewer
+		invited // hasn't accepted yet
+		stranger
+	)
+	const (
+		room        = 10
+		foreignRoom = 11
+		folder      = 20
+		note        = 30
+		foreignNote = 31
+	)
+	authz := NewAuthorizer(fakeAuthzQueries{
+		rooms: map[int32]db.Room{
+			room:        {ID: room, UserID: owner},
+			foreignRoom: {ID: foreignRoom, UserID: stranger},
+		},
+		folders: map[int32]db.Folder{
+			folder: {ID: folder, RoomID: room},
+		},
+		notes: map[int32]db.Note{
+			note:        {ID: note, RoomID: room, FolderID: folder},
+			foreignNote: {ID: foreignNote, RoomID: foreignRoom},
+		},
+		members: map[db.GetRoomMemberRoleParams]fakeRoomMember{
+			{RoomID: room, UserID: owner}:     {role: RoomRoleOwner, accepted: true},
+			{RoomID: room, UserID: editor}:    {role: RoomRoleEditor, accepted: true},
+			{RoomID: room, UserID: commenter}: {role: RoomRoleCommenter, accepted: true},
+			{RoomID: room, UserID: viewer}:    {role: RoomRoleViewer, accepted: true},
+			{RoomID: room, UserID: invited}:   {role: RoomRoleEditor},
+		},
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		check   func() error
+		wantErr error
+	}{
+		{name: "owner manages the room", check: func() error { _, err := authz.CanManageRoom(ctx, owner, room); return err }},
+		{name: "owner writes a note", check: func() error { _, err := authz.CanWriteNote(ctx, owner, note); return err }},
+		{name: "editor writes a note", check: func() error { _, err := authz.CanWriteNote(ctx, editor, note); return err }},
+		{name: "editor writes in a folder", check: func() error { _, err := authz.CanWriteFolder(ctx, editor, folder); return err }},
+		{name: "editor can't manage the room", check: func() error { _, err := authz.CanManageRoom(ctx, editor, room); return err }, wantErr: errForbidden},
+		{name: "commenter reads a note", check: func() error { _, err := authz.CanReadNote(ctx, commenter, note); return err }},
+		{name: "commenter can't write a note", check: func() error { _, err := authz.CanWriteNote(ctx, commenter, note); return err }, wantErr: errForbidden},
+		{name: "viewer reads a note", check: func() error { _, err := authz.CanReadNote(ctx, viewer, note); return err }},
+		{name: "viewer reads a folder", check: func() error { _, err := authz.CanReadFolder(ctx, viewer, folder); return err }},
+		{name: "viewer can't write a note", check: func() error { _, err := authz.CanWriteNote(ctx, viewer, note); return err }, wantErr: errForbidden},
+		{name: "viewer can't write in the room", check: func() error { _, err := authz.CanWriteRoom(ctx, viewer, room); return err }, wantErr: errForbidden},
+		{name: "pending invite can't read the room", check: func() error { _, err := authz.CanReadRoom(ctx, invited, room); return err }, wantErr: errNotFound},
+		{name: "pending invite can't read a note", check: func() error { _, err := authz.CanReadNote(ctx, invited, note); return err }, wantErr: errNotFound},
+		{name: "stranger can't read a note", check: func() error { _, err := authz.CanReadNote(ctx, stranger, note); return err }, wantErr: errNotFound},
+		{name: "foreign room looks missing", check: func() error { _, err := authz.CanReadRoom(ctx, owner, foreignRoom); return err }, wantErr: errNotFound},
+		{name: "foreign note looks missing", check: func() error { _, err := authz.CanWriteNote(ctx, editor, foreignNote); return err }, wantErr: errNotFound},
+		{name: "missing note", check: func() error { _, err := authz.CanReadNote(ctx, owner, 99); return err }, wantErr: errNotFound},
+		{name: "missing folder", check: func() error { _, err := authz.CanReadFolder(ctx, owner, 99); return err }, wantErr: errNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.check(); !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+type clientIPContextKey struct{}
+
+// Forwarding headers a proxy can write, only the one set in forwarded_header is read
+const (
+	ForwardedHeaderXForwardedFor = "X-Forwarded-For"
+	ForwardedHeaderForwarded     = "Forwarded" // RFC 7239
+	ForwardedHeaderXRealIP       = "X-Real-IP"
+)
+
+var forwardedHeaders = []string{ForwardedHeaderXForwardedFor, ForwardedHeaderForwarded, ForwardedHeaderXRealIP}
+
+// ParseTrustedProxies parses CIDRs and single IPs, e.g. 172.16.0.0/12 or 10.0.0.5
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// clientIPMiddleware resolves the client IP once and stores it in the request context
+func clientIPMiddleware(trustedProxies []netip.Prefix, forwardedHeader string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := ResolveClientIP(r, trustedProxies, forwardedHeader); ok {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPContextKey{}, addr))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the IP resolved by clientIPMiddleware, or the peer address without it
+func ClientIP(r *http.Request) (netip.Addr, bool) {
+	if addr, ok := r.Context().Value(clientIPContextKey{}).(netip.Addr); ok {
+		return addr, true
+	}
+	return ResolveClientIP(r, nil, "")
+}
+
+// GetClientIP returns the client IP as a string, RemoteAddr when it can't be parsed
+func GetClientIP(r *http.Request) string {
+	if addr, ok := ClientIP(r); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// ResolveClientIP walks forwardedHeader from the right, starting at the peer.
+// Every hop added by a trusted proxy is believed, the first untrusted hop is the client.
+// Headers from untrusted peers are ignored, they could say anything. The other forwarding
+// headers are ignored too, the proxy passes them on from the client as they are.
+func ResolveClientIP(r *http.Request, trustedProxies []netip.Prefix, forwardedHeader string) (netip.Addr, bool) {
+	addr, ok := parseHopAddr(r.RemoteAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if !isTrustedProxy(addr, trustedProxies) {
+		return addr, true
+	}
+
+	var hops []string
+	switch forwardedHeader {
+	case ForwardedHeaderForwarded:
+		hops = forwardedForHops(r.Header.Values(ForwardedHeaderForwarded))
+	case ForwardedHeaderXRealIP:
+		hops = splitHeaderList(r.Header.Values(ForwardedHeaderXRealIP))
+	default:
+		hops = splitHeaderList(r.Header.Values(ForwardedHeaderXForwardedFor))
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHopAddr(hops[i])
+		if !ok {
+			break // "unknown" or an obfuscated identifier, the last good hop is all we know
+		}
+		addr = hop
+		if !isTrustedProxy(addr, trustedProxies) {
+			break
+		}
+	}
+	return addr, true
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	return slices.ContainsFunc(trustedProxies, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+// parseHopAddr parses an IP with optional port, brackets and quotes, e.g. "[2001:db8::1]:4711"
+func parseHopAddr(value string) (netip.Addr, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// splitHeaderList joins the header lines and splits them at commas
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// forwardedForHops returns the for= parameter of every Forwarded element, in order.
+// Elements without for= count as unknown hops.
+func forwardedForHops(values []string) []string {
+	var hops []string
+	for _, element := range splitHeaderList(values) {
+		hop := "unknown"
+		for _, pair := range strings.Split(element, ";") {
+			name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(name, "for") {
+				hop = value
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"172.16.0.0/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		forwardedHeader string
+		remoteAddr      string
+		headers         map[string]string
+		want            string
+	}{
+		{
+			// nginx only appends X-Forwarded-For, a Forwarded header comes from the client
+			name:            "spoofed Forwarded is ignored",
+			forwardedHeader: ForwardedHeaderXForwardedFor,
+			remoteAddr:      "172.18.0.3:51234",
+			headers:         map[string]string{"Forwarded": "for=6.6.6.6", "X-Forwarded-For": "203.0.113.9"},
+			want:            "203.0.113.9",
+		},
+		{
+			name:            "spoofed X-Forwarded-For is ignored",
+			forwardedHeader: ForwardedHeaderForwarded,
+			remoteAddr:      "172.18.0.3:51234",
+			headers:         map[string]string{"Forwarded": "for=203.0.113.9", "X-Forwarded-For": "6.6.6.6"},
+			want:            "203.0.113.9",
+		},
+		{
+			name:            "client prepends hops",
+			forwardedHeader: ForwardedHeaderXForwardedFor,
+			remoteAddr:      "172.18.0.3:51234",
+			headers:         map[string]string{"X-Forwarded-For": "6.6.6.6, 203.0.113.9"},
+			want:            "203.0.113.9",
+		},
+		{
+			name:            "untrusted peer",
+			forwardedHeader: ForwardedHeaderXForwardedFor,
+			remoteAddr:      "198.51.100.7:51234",
+			headers:         map[string]string{"X-Forwarded-For": "6.6.6.6"},
+			want:            "198.51.100.7",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/notes", nil)
+			r.RemoteAddr = test.remoteAddr
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			addr, ok := ResolveClientIP(r, trustedProxies, test.forwardedHeader)
+			if !ok || addr.String() != test.want {
+				t.Errorf("got %v, want %s", addr, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"steamednotes/db"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Collaborative editing of notes over /api/ws. Every note someone edits has one YjsDoc in
+// memory that merges the updates of everyone who joined it. The doc is saved to
+// note_crdt_states every few seconds, with its text as a snapshot in notes.content.
+
+const (
+	collabTextName       = "content" // clients edit ydoc.getText("content")
+	collabSaveInterval   = 10 * time.Second
+	collabAccessRecheck  = 30 * time.Second // how long a peer's session and write access are trusted
+	maxCollabUpdateSize  = 1 << 20
+	maxCollabNotesPerWS  = 20 // notes one connection can join at once
+	collabSaveTimeout    = 10 * time.Second
+	collabNoteDeletedMsg = "Note was deleted"
+)
+
+var (
+	errNotJoined      = errors.New("join the note first")
+	errUpdateTooLarge = errors.New("update is too large")
+)
+
+// Collab holds the notes that are being edited
+type Collab struct {
+	queries *db.Queries
+	authz   *Authorizer
+
+	mu    sync.Mutex
+	notes map[int32]*collabNote
+}
+
+type collabNote struct {
+	mu     sync.Mutex
+	id     int32
+	roomID int32
+	doc    *YjsDoc
+	client uint64 // the Yjs client id of the server's own edits, e.g. PATCH /api/note/update
+	peers  map[*wsClient]*collabPeer
+	dirty  bool // changed since it was saved
+	closed bool // unloaded, whoever waited for it gets it again
+}
+
+type collabPeer struct {
+	userID    int32
+	canWrite  bool
+	checkedAt time.Time
+}
+
+func NewCollab(queries *db.Queries, authz *Authorizer) *Collab {
+	return &Collab{
+		queries: queries,
+		authz:   authz,
+		notes:   map[int32]*collabNote{},
+	}
+}
+
+// note returns the note locked, loading it when nobody edits it yet
+func (c *Collab) note(ctx context.Context, noteID int32) (*collabNote, error) {
+	for {
+		c.mu.Lock()
+		n, ok := c.notes[noteID]
+		if !ok {
+			n = &collabNote{id: noteID, peers: map[*wsClient]*collabPeer{}}
+			n.mu.Lock()
+			c.notes[noteID] = n
+			c.mu.Unlock()
+
+			if err := c.load(ctx, n); err != nil {
+				c.close(n)
+				n.mu.Unlock()
+				return nil, err
+			}
+			return n, nil
+		}
+		c.mu.Unlock()
+
+		n.mu.Lock()
+		if !n.closed {
+			return n, nil
+		}
+		n.mu.Unlock()
+	}
+}
+
+// loadedNote returns the note locked when it is being edited, nil otherwise
+func (c *Collab) loadedNote(noteID int32) *collabNote {
+	c.mu.Lock()
+	n := c.notes[noteID]
+	c.mu.Unlock()
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	return n
+}
+
+// load reads the saved doc, notes edited for the first time start from their content
+func (c *Collab) load(ctx context.Context, n *collabNote) error {
+	note, err := c.queries.FindNotesById(ctx, n.id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+	n.roomID = note.RoomID
+
+	n.doc = NewYjsDoc()
+	state, err := c.queries.GetNoteCrdtState(ctx, n.id)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		n.client = newYjsClientID(n.doc)
+		n.doc.SetText(collabTextName, note.Content, n.client)
+		n.dirty = true
+		return c.save(ctx, n)
+	case err != nil:
+		return err
+	}
+
+	if err := n.doc.ApplyUpdate(state); err != nil {
+		return fmt.Errorf("saved state of note %d: %w", n.id, err)
+	}
+	n.client = newYjsClientID(n.doc)
+	return nil
+}
+
+// newYjsClientID picks a random client id like Yjs does, one the doc hasn't seen
+func newYjsClientID(doc *YjsDoc) uint64 {
+	for {
+		client := uint64(rand.Uint32())
+		if doc.state(client) == 0 {
+			return client
+		}
+	}
+}
+
+// save stores the doc and its text, the caller holds n.mu. Peers of a deleted note are told and dropped.
+func (c *Collab) save(ctx context.Context, n *collabNote) error {
+	n.doc.Compact()
+	saved, err := c.queries.SaveNoteCrdtState(ctx, db.SaveNoteCrdtStateParams{
+		Content: n.doc.Text(collabTextName),
+		NoteID:  n.id,
+		State:   n.doc.EncodeStateAsUpdate(nil),
+	})
+	if err != nil {
+		return err
+	}
+	n.dirty = false
+	if saved == 0 {
+		for client := range n.peers {
+			client.send(wsMessage{Type: wsTypeError, NoteID: n.id, Message: collabNoteDeletedMsg})
+		}
+		clear(n.peers)
+		c.close(n)
+		return errNotFound
+	}
+	return nil
+}
+
+// close unloads the note, the caller holds n.mu
+func (c *Collab) close(n *collabNote) {
+	n.closed = true
+	c.mu.Lock()
+	if c.notes[n.id] == n {
+		delete(c.notes, n.id)
+	}
+	c.mu.Unlock()
+}
+
+// broadcast sends the message to everyone who joined the note but from, the caller holds n.mu.
+// Peers whose access wasn't checked for collabAccessRecheck are checked first, those who can't
+// read the note anymore are dropped instead.
+func (c *Collab) broadcast(ctx context.Context, n *collabNote, from *wsClient, msg wsMessage) {
+	for client, peer := range n.peers {
+		if client == from {
+			continue
+		}
+		if time.Since(peer.checkedAt) > collabAccessRecheck {
+			if err := c.recheck(ctx, client, peer, n.id); err != nil {
+				if errors.Is(err, errNotFound) || errors.Is(err, errSessionRevoked) {
+					client.send(wsMessage{Type: wsTypeError, NoteID: n.id, Message: wsErrorMessage(err)})
+					delete(n.peers, client)
+					continue
+				}
+				// Keep them until the next check works
+				fmt.Printf("Failed to check access of user %d to note %d: %v\n", peer.userID, n.id, err)
+			}
+		}
+		client.send(msg)
+	}
+}
+
+// recheck checks the peer's session and access to the note again, the caller holds n.mu
+func (c *Collab) recheck(ctx context.Context, client *wsClient, peer *collabPeer, noteID int32) error {
+	if err := c.checkSignedIn(ctx, client); err != nil {
+		return err
+	}
+	canWrite, err := c.canWrite(ctx, peer.userID, noteID)
+	if err != nil {
+		return err
+	}
+	peer.canWrite, peer.checkedAt = canWrite, time.Now()
+	return nil
+}
+
+// Join adds the client to the note's peers. It returns what the client is missing from the
+// state vector it has and the server's state vector, so it can send what the server is missing.
+func (c *Collab) Join(ctx context.Context, client *wsClient, noteID int32, stateVector []byte) (update, serverStateVector []byte, canWrite bool, err error) {
+	sv := map[uint64]int{}
+	if len(stateVector) > 0 {
+		if sv, err = decodeYjsStateVector(stateVector); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	canWrite, err = c.canWrite(ctx, client.userID, noteID)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	n, err := c.note(ctx, noteID)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer n.mu.Unlock()
+
+	n.peers[client] = &collabPeer{userID: client.userID, canWrite: canWrite, checkedAt: time.Now()}
+	return n.doc.EncodeStateAsUpdate(sv), encodeYjsStateVector(n.doc.StateVector()), canWrite, nil
+}
+
+// canWrite checks the user's access to the note, errNotFound when they can't read it
+func (c *Collab) canWrite(ctx context.Context, userID, noteID int32) (bool, error) {
+	_, err := c.authz.CanWriteNote(ctx, userID, noteID)
+	if errors.Is(err, errForbidden) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkSignedIn returns errSessionRevoked when the client's session ended or its account was
+// disabled or deleted since it connected. Connections with a personal access token have no session.
+func (c *Collab) checkSignedIn(ctx context.Context, client *wsClient) error {
+	if client.sessionID != 0 {
+		session, err := c.queries.GetSessionByID(ctx, client.sessionID)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && (session.UserID != client.userID || session.ExpiresAt.Time.Before(time.Now()))) {
+			return errSessionRevoked
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := CheckUserEnabled(ctx, c.queries, client.userID)
+	if errors.Is(err, errAccountDisabled) || errors.Is(err, pgx.ErrNoRows) {
+		return errSessionRevoked
+	}
+	return err
+}
+
+// Update merges an update of the client into the note and sends it on to the other peers
+func (c *Collab) Update(ctx context.Context, client *wsClient, noteID int32, update []byte) error {
+	if len(update) > maxCollabUpdateSize {
+		return errUpdateTooLarge
+	}
+	n := c.loadedNote(noteID)
+	if n == nil {
+		return errNotJoined
+	}
+	defer n.mu.Unlock()
+
+	peer, ok := n.peers[client]
+	if !ok {
+		return errNotJoined
+	}
+	if time.Since(peer.checkedAt) > collabAccessRecheck {
+		err := c.recheck(ctx, client, peer, noteID)
+		if errors.Is(err, errNotFound) || errors.Is(err, errSessionRevoked) {
+			// Removed from the room or signed out since joining
+			c.leave(n, client)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !peer.canWrite {
+		return errForbidden
+	}
+
+	if err := n.doc.ApplyUpdate(update); err != nil {
+		return err
+	}
+	n.dirty = true
+	c.broadcast(ctx, n, client, wsMessage{Type: wsTypeUpdate, NoteID: noteID, Update: update})
+	return nil
+}
+
+// Awareness sends the client's cursor and presence to the other peers, the server doesn't keep it
+func (c *Collab) Awareness(ctx context.Context, client *wsClient, noteID int32, update []byte) error {
+	if len(update) > maxCollabUpdateSize {
+		return errUpdateTooLarge
+	}
+	n := c.loadedNote(noteID)
+	if n == nil {
+		return errNotJoined
+	}
+	defer n.mu.Unlock()
+
+	if _, ok := n.peers[client]; !ok {
+		return errNotJoined
+	}
+	c.broadcast(ctx, n, client, wsMessage{Type: wsTypeAwareness, NoteID: noteID, Update: update})
+	return nil
+}
+
+// Leave removes the client from the note's peers
+func (c *Collab) Leave(client *wsClient, noteID int32) {
+	n := c.loadedNote(noteID)
+	if n == nil {
+		return
+	}
+	defer n.mu.Unlock()
+	c.leave(n, client)
+}
+
+// leave saves and unloads the note when the last peer left, the caller holds n.mu
+func (c *Collab) leave(n *collabNote, client *wsClient) {
+	delete(n.peers, client)
+	if len(n.peers) > 0 {
+		return
+	}
+	if n.dirty {
+		ctx, cancel := context.WithTimeout(context.Background(), collabSaveTimeout)
+		defer cancel()
+		if err := c.save(ctx, n); err != nil && !errors.Is(err, errNotFound) {
+			// Keep it loaded, the next save tries again
+			fmt.Printf("Failed to save note %d: %v\n", n.id, err)
+			return
+		}
+	}
+	c.close(n)
+}
+
+// LeaveAll removes the client from every note it joined, when it disconnects
+func (c *Collab) LeaveAll(client *wsClient, noteIDs map[int32]bool) {
+	for noteID := range noteIDs {
+		c.Leave(client, noteID)
+	}
+}
+
+// LeaveRoom removes the user from every note of the room they are editing, when they lost access to it
+func (c *Collab) LeaveRoom(userID, roomID int32) {
+	for _, n := range c.loadedNotes() {
+		n.mu.Lock()
+		if !n.closed && n.roomID == roomID {
+			for client, peer := range n.peers {
+				if peer.userID == userID {
+					client.send(wsMessage{Type: wsTypeError, NoteID: n.id, Message: wsErrorMessage(errNotFound)})
+					c.leave(n, client)
+				}
+			}
+		}
+		n.mu.Unlock()
+	}
+}
+
+// RecheckRoom makes the user's next update to a note of the room check their access again, when their role changed
+func (c *Collab) RecheckRoom(userID, roomID int32) {
+	for _, n := range c.loadedNotes() {
+		n.mu.Lock()
+		if n.roomID == roomID {
+			for _, peer := range n.peers {
+				if peer.userID == userID {
+					peer.checkedAt = time.Time{}
+				}
+			}
+		}
+		n.mu.Unlock()
+	}
+}
+
+// loadedNotes returns the notes being edited, unlocked
+func (c *Collab) loadedNotes() []*collabNote {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	notes := make([]*collabNote, 0, len(c.notes))
+	for _, n := range c.notes {
+		notes = append(notes, n)
+	}
+	return notes
+}
+
+// SetText replaces the text of the note, for edits that don't come from Yjs clients.
+// Only the part that changed is replaced, peers get it as a normal update.
+func (c *Collab) SetText(ctx context.Context, noteID int32, text string) error {
+	n, err := c.note(ctx, noteID)
+	if err != nil {
+		return err
+	}
+	defer n.mu.Unlock()
+
+	sv := n.doc.StateVector()
+	n.doc.SetText(collabTextName, text, n.client)
+	n.dirty = true
+	c.broadcast(ctx, n, nil, wsMessage{Type: wsTypeUpdate, NoteID: noteID, Update: n.doc.EncodeStateAsUpdate(sv)})
+
+	if err := c.save(ctx, n); err != nil {
+		return err
+	}
+	if len(n.peers) == 0 {
+		c.close(n)
+	}
+	return nil
+}
+
+// SaveLoop saves the notes that changed every collabSaveInterval
+func (c *Collab) SaveLoop() {
+	for range time.Tick(collabSaveInterval) {
+		for _, n := range c.loadedNotes() {
+			n.mu.Lock()
+			if n.dirty && !n.closed {
+				ctx, cancel := context.WithTimeout(context.Background(), collabSaveTimeout)
+				if err := c.save(ctx, n); err != nil && !errors.Is(err, errNotFound) {
+					fmt.Printf("Failed to save note %d: %v\n", n.id, err)
+				}
+				cancel()
+			}
+			n.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"steamednotes/db"
+	"time"
+)
+
+// runCommand runs one-off maintenance commands, e.g. `./steamednotes hash-passwords`
+func runCommand(ctx context.Context, queries *db.Queries, args []string) error {
+	switch args[0] {
+	case "hash-passwords":
+		return hashLegacyPasswords(ctx, queries)
+	case "list-jwt-keys":
+		return listJWTKeys(ctx, queries)
+	case "rotate-jwt-key":
+		algorithm := appConfig.JWTAlgorithm
+		if len(args) > 1 {
+			algorithm = args[1]
+		}
+		return rotateJWTKey(ctx, queries, algorithm)
+	case "retire-jwt-key":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: retire-jwt-key <kid>")
+		}
+		return (&Keyring{queries: queries}).Retire(ctx, args[1])
+	case "grant-role":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: grant-role <email> <role>")
+		}
+		return grantRole(ctx, queries, args[1], args[2])
+	case "revoke-role":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: revoke-role <email> <role>")
+		}
+		return revokeRole(ctx, queries, args[1], args[2])
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+// hashLegacyPasswords hashes every password_hash row that still holds plaintext
+func hashLegacyPasswords(ctx context.Context, queries *db.Queries) error {
+	users, err := queries.ListUsersWithLegacyPasswords(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Found %d users with legacy passwords", len(users))
+
+	for _, user := range users {
+		hash, err := HashPassword(user.PasswordHash)
+		if err != nil {
+			return err
+		}
+
+		err = queries.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{
+			ID:           user.ID,
+			PasswordHash: hash,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update password for user %d: %w", user.ID, err)
+		}
+	}
+
+	log.Printf("Hashed %d legacy passwords", len(users))
+	return nil
+}
+
+// listJWTKeys prints the signing keys, the active one is marked with *
+func listJWTKeys(ctx context.Context, queries *db.Queries) error {
+	k := &Keyring{queries: queries}
+	if err := k.Reload(ctx); err != nil {
+		return err
+	}
+
+	active := k.Active()
+	for _, key := range k.Keys() {
+		status := "accepted"
+		if !key.RetiredAt.IsZero() {
+			status = "retired " + key.RetiredAt.Format(time.RFC3339)
+		} else if key == active {
+			status = "* active"
+		}
+		fmt.Printf("%s  %-5s  created %s  %s\n", key.Kid, key.Algorithm, key.CreatedAt.Format(time.RFC3339), status)
+	}
+	return nil
+}
+
+// rotateJWTKey creates a new active signing key, running servers pick it up within a minute
+func rotateJWTKey(ctx context.Context, queries *db.Queries, algorithm string) error {
+	key, err := (&Keyring{queries: queries}).Rotate(ctx, algorithm)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Created %s signing key %s", key.Algorithm, key.Kid)
+	return nil
+}
+
+// grantRole gives a user a role, e.g. the first admin
+func grantRole(ctx context.Context, queries *db.Queries, email, role string) error {
+	granted, err := GrantRole(ctx, queries, email, role, 0)
+	if err != nil {
+		return err
+	}
+
+	if granted {
+		log.Printf("Granted %s to %s", role, email)
+	} else {
+		log.Printf("%s already has %s", email, role)
+	}
+	return nil
+}
+
+// revokeRole takes a role from a user
+func revokeRole(ctx context.Context, queries *db.Queries, email, role string) error {
+	revoked, err := RevokeRole(ctx, queries, email, role)
+	if err != nil {
+		return err
+	}
+
+	if revoked {
+		log.Printf("Revoked %s from %s", role, email)
+	} else {
+		log.Printf("%s doesn't have %s", email, role)
+	}
+	return nil
+}
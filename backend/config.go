@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server configuration.
+// Sources in increasing priority: defaults, the config file (YAML or TOML), environment variables, flags.
+type Config struct {
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+	AppBaseURL string `yaml:"app_base_url" toml:"app_base_url"` // where links in emails and redirects point to
+	// Proxies whose forwarding headers are believed, e.g. the nginx frontend's network
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// The header the trusted proxies write the client IP to: X-Forwarded-For, Forwarded or X-Real-IP
+	ForwardedHeader string `yaml:"forwarded_header" toml:"forwarded_header"`
+	// Signing keys live in jwt_signing_keys, JWTSecret only verifies tokens issued before them
+	JWTSecret    string `yaml:"jwt_secret" toml:"jwt_secret"`
+	JWTAlgorithm string `yaml:"jwt_algorithm" toml:"jwt_algorithm"` // for new signing keys
+
+	Database   DatabaseConfig   `yaml:"database" toml:"database"`
+	Cookie     CookieConfig     `yaml:"cookie" toml:"cookie"`
+	Session    SessionConfig    `yaml:"session" toml:"session"`
+	CORS       CORSConfig       `yaml:"cors" toml:"cors"`
+	CSRF       CSRFConfig       `yaml:"csrf" toml:"csrf"`
+	RateLimit  RateLimitConfig  `yaml:"rate_limit" toml:"rate_limit"`
+	AdminQuery AdminQueryConfig `yaml:"admin_query" toml:"admin_query"`
+	Features   FeaturesConfig   `yaml:"features" toml:"features"`
+}
+
+type DatabaseConfig struct {
+	URL      string `yaml:"url" toml:"url"` // full DSN, takes precedence over the fields below
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	Name     string `yaml:"name" toml:"name"`
+	SSLMode  string `yaml:"sslmode" toml:"sslmode"`
+}
+
+type CookieConfig struct {
+	Secure   bool   `yaml:"secure" toml:"secure"`
+	Domain   string `yaml:"domain" toml:"domain"`
+	SameSite string `yaml:"same_site" toml:"same_site"` // lax or strict
+}
+
+type SessionConfig struct {
+	TTL time.Duration `yaml:"ttl" toml:"ttl"`
+	// Sessions with less than RenewThreshold left are extended by TTL on use
+	RenewThreshold time.Duration `yaml:"renew_threshold" toml:"renew_threshold"`
+	// Impersonation sessions end after ImpersonationTTL and are never extended
+	ImpersonationTTL time.Duration `yaml:"impersonation_ttl" toml:"impersonation_ttl"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"` // empty disables CORS
+}
+
+type CSRFConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Origins allowed to send mutating requests besides app_base_url and the CORS origins
+	TrustedOrigins []string `yaml:"trusted_origins" toml:"trusted_origins"`
+}
+
+type RateLimitConfig struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled"`
+	Store    string `yaml:"store" toml:"store"`         // memory, postgres or redis, shared stores for several replicas
+	RedisURL string `yaml:"redis_url" toml:"redis_url"` // e.g. redis://redis:6379/0
+}
+
+// The admin SQL console connects as its own read-only role (see V015), not as the app
+type AdminQueryConfig struct {
+	DatabaseURL      string        `yaml:"database_url" toml:"database_url"` // empty disables the console
+	StatementTimeout time.Duration `yaml:"statement_timeout" toml:"statement_timeout"`
+	MaxRows          int           `yaml:"max_rows" toml:"max_rows"`               // longer results are truncated
+	ExportMaxRows    int           `yaml:"export_max_rows" toml:"export_max_rows"` // the same for streamed csv and ndjson
+}
+
+type FeaturesConfig struct {
+	Signup                   bool `yaml:"signup" toml:"signup"`
+	RequireEmailVerification bool `yaml:"require_email_verification" toml:"require_email_verification"`
+	AdminQuery               bool `yaml:"admin_query" toml:"admin_query"`
+}
+
+var validSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+var validRateLimitStores = []string{"memory", "postgres", "redis"}
+
+// appConfig is the loaded configuration, set once in main
+var appConfig = DefaultConfig()
+
+// DefaultConfig returns the settings used when nothing overrides them
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:      ":8080",
+		AppBaseURL:      "http://localhost",
+		ForwardedHeader: ForwardedHeaderXForwardedFor,
+		JWTAlgorithm:    JWTAlgorithmHS256,
+		Database: DatabaseConfig{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "prefer",
+		},
+		Cookie: CookieConfig{
+			SameSite: "lax",
+		},
+		Session: SessionConfig{
+			TTL:              7 * 24 * time.Hour,
+			RenewThreshold:   5 * 24 * time.Hour,
+			ImpersonationTTL: 15 * time.Minute,
+		},
+		CSRF: CSRFConfig{
+			Enabled: true,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Store:   "memory",
+		},
+		AdminQuery: AdminQueryConfig{
+			StatementTimeout: 10 * time.Second,
+			MaxRows:          1000,
+			ExportMaxRows:    100000,
+		},
+		Features: FeaturesConfig{
+			Signup:     true,
+			AdminQuery: true,
+		},
+	}
+}
+
+// LoadConfig builds the configuration from all sources and validates it.
+// It returns the arguments left after the flags, e.g. a maintenance command.
+func LoadConfig(args []string) (Config, []string, error) {
+	cfg := DefaultConfig()
+
+	fs := flag.NewFlagSet("steamednotes", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	listenAddr := fs.String("listen-addr", "", "address to listen on, e.g. :8080")
+	databaseURL := fs.String("database-url", "", "PostgreSQL connection string")
+	cookieSecure := fs.Bool("cookie-secure", false, "only send cookies over HTTPS")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile, &cfg); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return Config{}, nil, err
+	}
+
+	// Only flags given on the command line override the other sources
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen-addr":
+			cfg.ListenAddr = *listenAddr
+		case "database-url":
+			cfg.Database.URL = *databaseURL
+		case "cookie-secure":
+			cfg.Cookie.Secure = *cookieSecure
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadConfigFile decodes the file by extension
+func loadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// GetenvSecret reads NAME, or the file named by NAME_FILE (Docker secrets).
+// Trailing newlines of the file are ignored.
+func GetenvSecret(name string) (string, error) {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		if os.Getenv(name) != "" {
+			return "", fmt.Errorf("only one of %s and %s_FILE can be set", name, name)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading %s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return os.Getenv(name), nil
+}
+
+// applyEnv overrides cfg with the environment variables that are set
+func applyEnv(cfg *Config) error {
+	var errs []error
+
+	setString := func(name string, target *string) {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			*target = value
+		}
+	}
+	setSecret := func(name string, target *string) {
+		value, err := GetenvSecret(name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if value != "" {
+			*target = value
+		}
+	}
+	setBool := func(name string, target *bool) {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setInt := func(name string, target *int) {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setDuration := func(name string, target *time.Duration) {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	setString("LISTEN_ADDR", &cfg.ListenAddr)
+	setString("APP_BASE_URL", &cfg.AppBaseURL)
+	setSecret("JWT_SECRET", &cfg.JWTSecret)
+	setString("JWT_ALGORITHM", &cfg.JWTAlgorithm)
+
+	setSecret("DATABASE_URL", &cfg.Database.URL)
+	setString("DB_HOST", &cfg.Database.Host)
+	setInt("DB_PORT", &cfg.Database.Port)
+	setString("DB_USER", &cfg.Database.User)
+	setSecret("DB_PASSWORD", &cfg.Database.Password)
+	setString("DB_NAME", &cfg.Database.Name)
+	setString("DB_SSLMODE", &cfg.Database.SSLMode)
+
+	setBool("COOKIE_SECURE", &cfg.Cookie.Secure)
+	setString("COOKIE_DOMAIN", &cfg.Cookie.Domain)
+	setString("COOKIE_SAMESITE", &cfg.Cookie.SameSite)
+
+	setDuration("SESSION_TTL", &cfg.Session.TTL)
+	setDuration("SESSION_RENEW_THRESHOLD", &cfg.Session.RenewThreshold)
+	setDuration("SESSION_IMPERSONATION_TTL", &cfg.Session.ImpersonationTTL)
+
+	setList := func(name string, target *[]string) {
+		if value := os.Getenv(name); value != "" {
+			*target = splitHeaderList([]string{value})
+		}
+	}
+
+	setList("TRUSTED_PROXIES", &cfg.TrustedProxies)
+	setString("FORWARDED_HEADER", &cfg.ForwardedHeader)
+	setList("CORS_ALLOWED_ORIGINS", &cfg.CORS.AllowedOrigins)
+
+	setBool("CSRF_ENABLED", &cfg.CSRF.Enabled)
+	setList("CSRF_TRUSTED_ORIGINS", &cfg.CSRF.TrustedOrigins)
+
+	setBool("RATE_LIMIT_ENABLED", &cfg.RateLimit.Enabled)
+	setString("RATE_LIMIT_STORE", &cfg.RateLimit.Store)
+	setSecret("REDIS_URL", &cfg.RateLimit.RedisURL)
+
+	setSecret("ADMIN_QUERY_DATABASE_URL", &cfg.AdminQuery.DatabaseURL)
+	setDuration("ADMIN_QUERY_STATEMENT_TIMEOUT", &cfg.AdminQuery.StatementTimeout)
+	setInt("ADMIN_QUERY_MAX_ROWS", &cfg.AdminQuery.MaxRows)
+	setInt("ADMIN_QUERY_EXPORT_MAX_ROWS", &cfg.AdminQuery.ExportMaxRows)
+
+	setBool("ENABLE_SIGNUP", &cfg.Features.Signup)
+	setBool("REQUIRE_EMAIL_VERIFICATION", &cfg.Features.RequireEmailVerification)
+	setBool("ENABLE_ADMIN_QUERY", &cfg.Features.AdminQuery)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if cfg.JWTSecret != "" && len(cfg.JWTSecret) < 32 {
+		errs = append(errs, errors.New("jwt_secret (JWT_SECRET or JWT_SECRET_FILE) must be at least 32 characters"))
+	}
+	if !slices.Contains(jwtAlgorithms, cfg.JWTAlgorithm) {
+		errs = append(errs, fmt.Errorf("jwt_algorithm must be one of %s", strings.Join(jwtAlgorithms, ", ")))
+	}
+	if cfg.ListenAddr == "" {
+		errs = append(errs, errors.New("listen_addr is required"))
+	}
+	if _, err := ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+	if i := slices.IndexFunc(forwardedHeaders, func(header string) bool {
+		return strings.EqualFold(header, cfg.ForwardedHeader)
+	}); i >= 0 {
+		cfg.ForwardedHeader = forwardedHeaders[i]
+	} else {
+		errs = append(errs, fmt.Errorf("forwarded_header must be one of %s", strings.Join(forwardedHeaders, ", ")))
+	}
+
+	cfg.AppBaseURL = strings.TrimRight(cfg.AppBaseURL, "/")
+	if u, err := url.Parse(cfg.AppBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("app_base_url %q must be an http(s) URL", cfg.AppBaseURL))
+	}
+
+	if cfg.Database.URL == "" {
+		if cfg.Database.Host == "" || cfg.Database.User == "" || cfg.Database.Name == "" {
+			errs = append(errs, errors.New("database host, user and name are required when database url is not set"))
+		}
+		if cfg.Database.Port <= 0 || cfg.Database.Port > 65535 {
+			errs = append(errs, fmt.Errorf("database port %d is invalid", cfg.Database.Port))
+		}
+		if !slices.Contains(validSSLModes, cfg.Database.SSLMode) {
+			errs = append(errs, fmt.Errorf("database sslmode must be one of %s", strings.Join(validSSLModes, ", ")))
+		}
+	}
+
+	cfg.Cookie.SameSite = strings.ToLower(cfg.Cookie.SameSite)
+	if cfg.Cookie.SameSite != "lax" && cfg.Cookie.SameSite != "strict" {
+		errs = append(errs, errors.New("cookie same_site must be lax or strict"))
+	}
+
+	if cfg.Session.TTL < time.Hour {
+		errs = append(errs, errors.New("session ttl must be at least 1h"))
+	}
+	if cfg.Session.RenewThreshold < 0 || cfg.Session.RenewThreshold > cfg.Session.TTL {
+		errs = append(errs, errors.New("session renew_threshold must be between 0 and the session ttl"))
+	}
+	if cfg.Session.ImpersonationTTL < time.Minute || cfg.Session.ImpersonationTTL > time.Hour {
+		errs = append(errs, errors.New("session impersonation_ttl must be between 1m and 1h"))
+	}
+
+	for _, origin := range slices.Concat(cfg.CORS.AllowedOrigins, cfg.CSRF.TrustedOrigins) {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("origin %q must look like https://example.com", origin))
+		}
+	}
+
+	if cfg.RateLimit.Enabled {
+		if !slices.Contains(validRateLimitStores, cfg.RateLimit.Store) {
+			errs = append(errs, fmt.Errorf("rate_limit store must be one of %s", strings.Join(validRateLimitStores, ", ")))
+		}
+		if cfg.RateLimit.Store == "redis" && cfg.RateLimit.RedisURL == "" {
+			errs = append(errs, errors.New("rate_limit redis_url (REDIS_URL) is required for the redis store"))
+		}
+	}
+
+	if cfg.Features.AdminQuery {
+		if cfg.AdminQuery.StatementTimeout < time.Second || cfg.AdminQuery.StatementTimeout > 5*time.Minute {
+			errs = append(errs, errors.New("admin_query statement_timeout must be between 1s and 5m"))
+		}
+		if cfg.AdminQuery.MaxRows < 1 || cfg.AdminQuery.MaxRows > 100000 {
+			errs = append(errs, errors.New("admin_query max_rows must be between 1 and 100000"))
+		}
+		if cfg.AdminQuery.ExportMaxRows < 1 || cfg.AdminQuery.ExportMaxRows > 10000000 {
+			errs = append(errs, errors.New("admin_query export_max_rows must be between 1 and 10000000"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DSN returns the PostgreSQL connection string
+func (db DatabaseConfig) DSN() string {
+	if db.URL != "" {
+		return db.URL
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(db.User, db.Password),
+		Host:     fmt.Sprintf("%s:%d", db.Host, db.Port),
+		Path:     "/" + db.Name,
+		RawQuery: url.Values{"sslmode": {db.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// SameSiteMode maps the configured SameSite to http.SameSite
+func (c CookieConfig) SameSiteMode() http.SameSite {
+	if c.SameSite == "strict" {
+		return http.SameSiteStrictMode
+	}
+	return http.SameSiteLaxMode
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Double-submit CSRF protection: the frontend copies the csrf_token cookie into the
+// X-CSRF-Token header. Other sites can neither read our cookie nor set custom headers.
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfSafeMethods don't change anything and are never checked
+var csrfSafeMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace}
+
+// csrfTrustedOrigins are the origins allowed to send mutating requests
+func csrfTrustedOrigins(cfg Config) []string {
+	var origins []string
+	for _, origin := range slices.Concat([]string{cfg.AppBaseURL}, cfg.CORS.AllowedOrigins, cfg.CSRF.TrustedOrigins) {
+		origins = append(origins, originOf(origin))
+	}
+	return origins
+}
+
+// csrfMiddleware checks Origin (or Referer) and the token on every mutating request.
+// Requests with a bearer token are API clients that don't use the cookie, they are exempt.
+func csrfMiddleware(trustedOrigins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(csrfSafeMethods, r.Method) || strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if origin := requestOrigin(r); origin != "" && !slices.Contains(trustedOrigins, strings.ToLower(origin)) {
+			http.Error(w, "Forbidden - cross-site request", http.StatusForbidden)
+			return
+		}
+
+		cookie, err := r.Cookie(csrfCookieName)
+		header := r.Header.Get(csrfHeaderName)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			http.Error(w, "Forbidden - invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestOrigin returns the Origin header, or the origin of the Referer when the browser sent no Origin
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin // "null" from sandboxed frames is never trusted
+	}
+	return originOf(r.Header.Get("Referer"))
+}
+
+// originOf reduces a URL to scheme://host, empty when it has neither
+func originOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// Get the CSRF token, setting the cookie when the browser has none yet
+func getCSRFToken(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+		token = cookie.Value
+	} else {
+		newToken, err := GenerateSessionToken()
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		token = newToken
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookieName,
+			Value:    token,
+			HttpOnly: false, // the frontend reads it to send the header
+			Path:     "/",
+			Domain:   appConfig.Cookie.Domain,
+			Secure:   appConfig.Cookie.Secure,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"csrf_token": token})
+}
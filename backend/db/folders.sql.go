@@ -19,7 +19,7 @@ RETURNING id, created_at
 
 type CreateFolderParams struct {
 	RoomID   int32
-	UserID   int32
+	UserID   pgtype.Int4
 	Name     string
 	RoomName string
 }
@@ -61,22 +61,18 @@ func (q *Queries) FindFolderById(ctx context.Context, id int32) (Folder, error)
 
 const findFoldersByRoom = `-- name: FindFoldersByRoom :many
 SELECT id, name, created_at FROM folders 
-where room_id=$1 AND user_id=$2
+where room_id=$1
 `
 
-type FindFoldersByRoomParams struct {
-	RoomID int32
-	UserID int32
-}
-
 type FindFoldersByRoomRow struct {
 	ID        int32
 	Name      string
 	CreatedAt pgtype.Timestamp
 }
 
-func (q *Queries) FindFoldersByRoom(ctx context.Context, arg FindFoldersByRoomParams) ([]FindFoldersByRoomRow, error) {
-	rows, err := q.db.Query(ctx, findFoldersByRoom, arg.RoomID, arg.UserID)
+// FindFoldersByRoom doesn't check access, see Authorizer
+func (q *Queries) FindFoldersByRoom(ctx context.Context, roomID int32) ([]FindFoldersByRoomRow, error) {
+	rows, err := q.db.Query(ctx, findFoldersByRoom, roomID)
 	if err != nil {
 		return nil, err
 	}
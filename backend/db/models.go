@@ -10,24 +10,79 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-type AdminEmail struct {
-	Email string
+type AdminQueryLog struct {
+	ID           int64
+	UserID       pgtype.Int4
+	Email        string
+	SavedQueryID pgtype.Int4
+	Query        string
+	Params       []byte
+	Format       string
+	DurationMs   int32
+	RowCount     int32
+	Truncated    bool
+	Error        pgtype.Text
+	CreatedAt    pgtype.Timestamp
+}
+
+type AdminSavedQuery struct {
+	ID          int32
+	Name        string
+	Description string
+	Query       string
+	Params      []string
+	CreatedBy   pgtype.Int4
+	CreatedAt   pgtype.Timestamp
+	UpdatedAt   pgtype.Timestamp
+}
+
+type EmailVerification struct {
+	ID        int32
+	UserID    int32
+	Email     string
+	TokenHash string
+	CreatedAt pgtype.Timestamp
+	ExpiresAt pgtype.Timestamp
+	UsedAt    pgtype.Timestamp
 }
 
 type Folder struct {
 	ID        int32
 	RoomID    int32
-	UserID    int32
+	UserID    pgtype.Int4
 	Name      string
 	CreatedAt pgtype.Timestamp
 	RoomName  string
 }
 
+type ImpersonationLog struct {
+	ID                int64
+	SessionID         int32
+	ImpersonatorID    pgtype.Int4
+	ImpersonatorEmail string
+	UserID            pgtype.Int4
+	UserEmail         string
+	Method            string
+	Path              string
+	Status            int32
+	CreatedAt         pgtype.Timestamp
+}
+
+type JwtSigningKey struct {
+	ID         int32
+	Kid        string
+	Algorithm  string
+	PrivateKey []byte
+	PublicKey  []byte
+	CreatedAt  pgtype.Timestamp
+	RetiredAt  pgtype.Timestamp
+}
+
 type Note struct {
 	ID         int32
 	RoomID     int32
 	FolderID   int32
-	UserID     int32
+	UserID     pgtype.Int4
 	Title      string
 	Content    string
 	CreatedAt  pgtype.Timestamp
@@ -35,6 +90,56 @@ type Note struct {
 	FolderName string
 }
 
+type NoteCrdtState struct {
+	NoteID    int32
+	State     []byte
+	UpdatedAt pgtype.Timestamp
+}
+
+type PasswordReset struct {
+	ID        int32
+	UserID    int32
+	TokenHash string
+	CreatedAt pgtype.Timestamp
+	ExpiresAt pgtype.Timestamp
+	UsedAt    pgtype.Timestamp
+}
+
+type Permission struct {
+	Name        string
+	Description string
+}
+
+type PersonalAccessToken struct {
+	ID          int32
+	UserID      int32
+	Name        string
+	TokenHash   string
+	TokenPrefix string
+	Scopes      []string
+	CreatedAt   pgtype.Timestamp
+	ExpiresAt   pgtype.Timestamp
+	LastUsedAt  pgtype.Timestamp
+}
+
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	UpdatedAt pgtype.Timestamp
+}
+
+type Role struct {
+	ID          int32
+	Name        string
+	Description string
+	CreatedAt   pgtype.Timestamp
+}
+
+type RolePermission struct {
+	RoleID     int32
+	Permission string
+}
+
 type Room struct {
 	ID        int32
 	Name      string
@@ -42,12 +147,95 @@ type Room struct {
 	CreatedAt pgtype.Timestamp
 }
 
-type User struct {
+type RoomInviteLink struct {
+	ID        int32
+	RoomID    int32
+	CreatedBy pgtype.Int4
+	TokenHash string
+	Role      string
+	MaxUses   pgtype.Int4
+	UseCount  int32
+	CreatedAt pgtype.Timestamp
+	ExpiresAt pgtype.Timestamp
+	RevokedAt pgtype.Timestamp
+}
+
+type RoomMember struct {
+	RoomID     int32
+	UserID     int32
+	Role       string
+	InvitedBy  pgtype.Int4
+	InvitedAt  pgtype.Timestamp
+	AcceptedAt pgtype.Timestamp
+}
+
+type ShareLink struct {
 	ID           int32
-	Username     string
-	Email        string
-	PasswordHash string
+	UserID       int32
+	NoteID       pgtype.Int4
+	FolderID     pgtype.Int4
+	TokenHash    string
+	PasswordHash pgtype.Text
+	ViewCount    int32
+	LastViewedAt pgtype.Timestamp
 	CreatedAt    pgtype.Timestamp
+	ExpiresAt    pgtype.Timestamp
+	RevokedAt    pgtype.Timestamp
+}
+
+type SignInAttempt struct {
+	ID            int64
+	Email         string
+	UserID        pgtype.Int4
+	IpAddress     *netip.Addr
+	UserAgent     pgtype.Text
+	Succeeded     bool
+	FailureReason pgtype.Text
+	CreatedAt     pgtype.Timestamp
+}
+
+type SignInLockout struct {
+	Scope        string
+	Subject      string
+	FailedCount  int32
+	LastFailedAt pgtype.Timestamp
+	LockedUntil  pgtype.Timestamp
+}
+
+type User struct {
+	ID              int32
+	Username        string
+	Email           string
+	PasswordHash    string
+	CreatedAt       pgtype.Timestamp
+	EmailVerifiedAt pgtype.Timestamp
+	DisabledAt      pgtype.Timestamp
+}
+
+type UserIdentity struct {
+	ID          int32
+	UserID      int32
+	Provider    string
+	Issuer      string
+	Subject     string
+	Email       pgtype.Text
+	CreatedAt   pgtype.Timestamp
+	LastLoginAt pgtype.Timestamp
+}
+
+type UserRecoveryCode struct {
+	ID        int32
+	UserID    int32
+	CodeHash  string
+	CreatedAt pgtype.Timestamp
+	UsedAt    pgtype.Timestamp
+}
+
+type UserRole struct {
+	UserID    int32
+	RoleID    int32
+	GrantedBy pgtype.Int4
+	GrantedAt pgtype.Timestamp
 }
 
 type UserSession struct {
@@ -66,4 +254,41 @@ type UserSession struct {
 	LastUsedAt     pgtype.Timestamp
 	ExpiresAt      pgtype.Timestamp
 	IsActive       pgtype.Bool
+	MfaUsed        bool
+	AuthMethod     string
+	AuthProvider   pgtype.Text
+	ImpersonatorID pgtype.Int4
+}
+
+type UserTotp struct {
+	UserID       int32
+	Secret       string
+	EnabledAt    pgtype.Timestamp
+	LastUsedStep int64
+	CreatedAt    pgtype.Timestamp
+}
+
+type WebauthnCeremony struct {
+	ID          string
+	UserID      pgtype.Int4
+	Kind        string
+	SessionData []byte
+	ExpiresAt   pgtype.Timestamp
+}
+
+type WebauthnCredential struct {
+	ID              int32
+	UserID          int32
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Aaguid          []byte
+	SignCount       int64
+	CloneWarning    bool
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+	Name            string
+	CreatedAt       pgtype.Timestamp
+	LastUsedAt      pgtype.Timestamp
 }
@@ -22,7 +22,7 @@ type CreateNoteParams struct {
 	RoomName   string
 	FolderID   int32
 	FolderName string
-	UserID     int32
+	UserID     pgtype.Int4
 	Title      string
 	Content    string
 }
@@ -47,39 +47,66 @@ func (q *Queries) CreateNote(ctx context.Context, arg CreateNoteParams) (CreateN
 	return i, err
 }
 
-const deleteNote = `-- name: DeleteNote :exec
+const deleteNote = `-- name: DeleteNote :execrows
 DELETE FROM notes 
-WHERE id=$1 AND user_id=$2
+WHERE id=$1
 `
 
-type DeleteNoteParams struct {
-	ID     int32
-	UserID int32
+func (q *Queries) DeleteNote(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteNote, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-func (q *Queries) DeleteNote(ctx context.Context, arg DeleteNoteParams) error {
-	_, err := q.db.Exec(ctx, deleteNote, arg.ID, arg.UserID)
-	return err
+const findNoteContentsByFolder = `-- name: FindNoteContentsByFolder :many
+SELECT title, content, created_at FROM notes
+where folder_id=$1
+ORDER BY created_at, id
+`
+
+type FindNoteContentsByFolderRow struct {
+	Title     string
+	Content   string
+	CreatedAt pgtype.Timestamp
+}
+
+// FindNoteContentsByFolder doesn't check access, see Authorizer
+func (q *Queries) FindNoteContentsByFolder(ctx context.Context, folderID int32) ([]FindNoteContentsByFolderRow, error) {
+	rows, err := q.db.Query(ctx, findNoteContentsByFolder, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FindNoteContentsByFolderRow
+	for rows.Next() {
+		var i FindNoteContentsByFolderRow
+		if err := rows.Scan(&i.Title, &i.Content, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const findNotesByFolder = `-- name: FindNotesByFolder :many
 SELECT id, title, created_at FROM notes
-where folder_id=$1 AND user_id=$2
+where folder_id=$1
 `
 
-type FindNotesByFolderParams struct {
-	FolderID int32
-	UserID   int32
-}
-
 type FindNotesByFolderRow struct {
 	ID        int32
 	Title     string
 	CreatedAt pgtype.Timestamp
 }
 
-func (q *Queries) FindNotesByFolder(ctx context.Context, arg FindNotesByFolderParams) ([]FindNotesByFolderRow, error) {
-	rows, err := q.db.Query(ctx, findNotesByFolder, arg.FolderID, arg.UserID)
+// FindNotesByFolder doesn't check access, see Authorizer
+func (q *Queries) FindNotesByFolder(ctx context.Context, folderID int32) ([]FindNotesByFolderRow, error) {
+	rows, err := q.db.Query(ctx, findNotesByFolder, folderID)
 	if err != nil {
 		return nil, err
 	}
@@ -119,25 +146,22 @@ func (q *Queries) FindNotesById(ctx context.Context, id int32) (Note, error) {
 	return i, err
 }
 
-const updateNoteNameAndContent = `-- name: UpdateNoteNameAndContent :exec
+const updateNoteNameAndContent = `-- name: UpdateNoteNameAndContent :execrows
 UPDATE notes
 SET title = $1, content = $2
-WHERE id = $3 AND user_id = $4
+WHERE id = $3
 `
 
 type UpdateNoteNameAndContentParams struct {
 	Title   string
 	Content string
 	ID      int32
-	UserID  int32
 }
 
-func (q *Queries) UpdateNoteNameAndContent(ctx context.Context, arg UpdateNoteNameAndContentParams) error {
-	_, err := q.db.Exec(ctx, updateNoteNameAndContent,
-		arg.Title,
-		arg.Content,
-		arg.ID,
-		arg.UserID,
-	)
-	return err
+func (q *Queries) UpdateNoteNameAndContent(ctx context.Context, arg UpdateNoteNameAndContentParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateNoteNameAndContent, arg.Title, arg.Content, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
@@ -12,8 +12,13 @@ import (
 )
 
 const createRoom = `-- name: CreateRoom :exec
-INSERT INTO rooms (name, user_id)
-VALUES ($1, $2)
+WITH room AS (
+    INSERT INTO rooms (name, user_id)
+    VALUES ($1, $2)
+    RETURNING id, user_id
+)
+INSERT INTO room_members (room_id, user_id, role, accepted_at)
+SELECT id, user_id, 'owner', CURRENT_TIMESTAMP FROM room
 `
 
 type CreateRoomParams struct {
@@ -21,6 +26,7 @@ type CreateRoomParams struct {
 	UserID int32
 }
 
+// CreateRoom creates the room with its creator as owner
 func (q *Queries) CreateRoom(ctx context.Context, arg CreateRoomParams) error {
 	_, err := q.db.Exec(ctx, createRoom, arg.Name, arg.UserID)
 	return err
@@ -43,16 +49,20 @@ func (q *Queries) FindRoomById(ctx context.Context, id int32) (Room, error) {
 }
 
 const findRoomsByUser = `-- name: FindRoomsByUser :many
-SELECT id, name, created_at FROM rooms 
-where user_id=$1
+SELECT rooms.id, rooms.name, rooms.created_at, room_members.role FROM rooms 
+JOIN room_members ON room_members.room_id = rooms.id
+where room_members.user_id=$1 AND room_members.accepted_at IS NOT NULL
+ORDER BY rooms.created_at, rooms.id
 `
 
 type FindRoomsByUserRow struct {
 	ID        int32
 	Name      string
 	CreatedAt pgtype.Timestamp
+	Role      string
 }
 
+// FindRoomsByUser lists the rooms the user owns or was invited to and accepted, with their role
 func (q *Queries) FindRoomsByUser(ctx context.Context, userID int32) ([]FindRoomsByUserRow, error) {
 	rows, err := q.db.Query(ctx, findRoomsByUser, userID)
 	if err != nil {
@@ -62,7 +72,12 @@ func (q *Queries) FindRoomsByUser(ctx context.Context, userID int32) ([]FindRoom
 	var items []FindRoomsByUserRow
 	for rows.Next() {
 		var i FindRoomsByUserRow
-		if err := rows.Scan(&i.ID, &i.Name, &i.CreatedAt); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.Role,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -35,10 +35,14 @@ INSERT INTO user_sessions (
     os_version, 
     ip_address, 
     user_agent, 
-    expires_at
+    expires_at,
+    mfa_used,
+    auth_method,
+    auth_provider,
+    impersonator_id
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
-) RETURNING id, user_id, session_token, device_name, device_type, browser_name, browser_version, os_name, os_version, ip_address, user_agent, created_at, last_used_at, expires_at, is_active
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
+) RETURNING id, user_id, session_token, device_name, device_type, browser_name, browser_version, os_name, os_version, ip_address, user_agent, created_at, last_used_at, expires_at, is_active, mfa_used, auth_method, auth_provider, impersonator_id
 `
 
 type CreateSessionParams struct {
@@ -53,6 +57,10 @@ type CreateSessionParams struct {
 	IpAddress      *netip.Addr
 	UserAgent      pgtype.Text
 	ExpiresAt      pgtype.Timestamp
+	MfaUsed        bool
+	AuthMethod     string
+	AuthProvider   pgtype.Text
+	ImpersonatorID pgtype.Int4
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (UserSession, error) {
@@ -68,6 +76,10 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (U
 		arg.IpAddress,
 		arg.UserAgent,
 		arg.ExpiresAt,
+		arg.MfaUsed,
+		arg.AuthMethod,
+		arg.AuthProvider,
+		arg.ImpersonatorID,
 	)
 	var i UserSession
 	err := row.Scan(
@@ -86,6 +98,10 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (U
 		&i.LastUsedAt,
 		&i.ExpiresAt,
 		&i.IsActive,
+		&i.MfaUsed,
+		&i.AuthMethod,
+		&i.AuthProvider,
+		&i.ImpersonatorID,
 	)
 	return i, err
 }
@@ -122,6 +138,18 @@ func (q *Queries) DeleteAllSessionsExceptCurrent(ctx context.Context, arg Delete
 	return err
 }
 
+const deleteAllSessionsForUser = `-- name: DeleteAllSessionsForUser :exec
+UPDATE user_sessions 
+SET is_active = false 
+WHERE user_id = $1 OR impersonator_id = $1
+`
+
+// DeleteAllSessionsForUser also ends the impersonations the user started
+func (q *Queries) DeleteAllSessionsForUser(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteAllSessionsForUser, userID)
+	return err
+}
+
 const deleteSession = `-- name: DeleteSession :exec
 UPDATE user_sessions 
 SET is_active = false 
@@ -139,7 +167,7 @@ func (q *Queries) DeleteSession(ctx context.Context, arg DeleteSessionParams) er
 }
 
 const getSessionByID = `-- name: GetSessionByID :one
-SELECT id, user_id, session_token, device_name, device_type, browser_name, browser_version, os_name, os_version, ip_address, user_agent, created_at, last_used_at, expires_at, is_active FROM user_sessions 
+SELECT id, user_id, session_token, device_name, device_type, browser_name, browser_version, os_name, os_version, ip_address, user_agent, created_at, last_used_at, expires_at, is_active, mfa_used, auth_method, auth_provider, impersonator_id FROM user_sessions 
 WHERE id = $1 AND is_active = true
 `
 
@@ -162,12 +190,16 @@ func (q *Queries) GetSessionByID(ctx context.Context, id int32) (UserSession, er
 		&i.LastUsedAt,
 		&i.ExpiresAt,
 		&i.IsActive,
+		&i.MfaUsed,
+		&i.AuthMethod,
+		&i.AuthProvider,
+		&i.ImpersonatorID,
 	)
 	return i, err
 }
 
 const getSessionByToken = `-- name: GetSessionByToken :one
-SELECT id, user_id, session_token, device_name, device_type, browser_name, browser_version, os_name, os_version, ip_address, user_agent, created_at, last_used_at, expires_at, is_active FROM user_sessions 
+SELECT id, user_id, session_token, device_name, device_type, browser_name, browser_version, os_name, os_version, ip_address, user_agent, created_at, last_used_at, expires_at, is_active, mfa_used, auth_method, auth_provider, impersonator_id FROM user_sessions 
 WHERE session_token = $1 AND is_active = true
 `
 
@@ -190,12 +222,16 @@ func (q *Queries) GetSessionByToken(ctx context.Context, sessionToken string) (U
 		&i.LastUsedAt,
 		&i.ExpiresAt,
 		&i.IsActive,
+		&i.MfaUsed,
+		&i.AuthMethod,
+		&i.AuthProvider,
+		&i.ImpersonatorID,
 	)
 	return i, err
 }
 
 const getSessionsByUser = `-- name: GetSessionsByUser :many
-SELECT id, user_id, session_token, device_name, device_type, browser_name, browser_version, os_name, os_version, ip_address, user_agent, created_at, last_used_at, expires_at, is_active FROM user_sessions 
+SELECT id, user_id, session_token, device_name, device_type, browser_name, browser_version, os_name, os_version, ip_address, user_agent, created_at, last_used_at, expires_at, is_active, mfa_used, auth_method, auth_provider, impersonator_id FROM user_sessions 
 WHERE user_id = $1 AND is_active = true
 ORDER BY last_used_at DESC
 `
@@ -225,6 +261,10 @@ func (q *Queries) GetSessionsByUser(ctx context.Context, userID int32) ([]UserSe
 			&i.LastUsedAt,
 			&i.ExpiresAt,
 			&i.IsActive,
+			&i.MfaUsed,
+			&i.AuthMethod,
+			&i.AuthProvider,
+			&i.ImpersonatorID,
 		); err != nil {
 			return nil, err
 		}
@@ -252,13 +292,19 @@ func (q *Queries) UpdateSessionLastUsed(ctx context.Context, id int32) (int32, e
 const updateSessionLastUsedAndExpiry = `-- name: UpdateSessionLastUsedAndExpiry :one
 UPDATE user_sessions 
 SET last_used_at = CURRENT_TIMESTAMP,
-expiry_at = CURRENT_TIMESTAMP + INTERVAL '7 days'
+expires_at = $2
 WHERE id = $1
 RETURNING id
 `
 
-func (q *Queries) UpdateSessionLastUsedAndExpiry(ctx context.Context, id int32) (int32, error) {
-	row := q.db.QueryRow(ctx, updateSessionLastUsedAndExpiry, id)
+type UpdateSessionLastUsedAndExpiryParams struct {
+	ID        int32
+	ExpiresAt pgtype.Timestamp
+}
+
+func (q *Queries) UpdateSessionLastUsedAndExpiry(ctx context.Context, arg UpdateSessionLastUsedAndExpiryParams) (int32, error) {
+	row := q.db.QueryRow(ctx, updateSessionLastUsedAndExpiry, arg.ID, arg.ExpiresAt)
+	var id int32
 	err := row.Scan(&id)
 	return id, err
 }
@@ -11,6 +11,18 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*) FROM users
+WHERE $1::text = '' OR username ILIKE $1::text OR email ILIKE $1::text
+`
+
+func (q *Queries) CountUsers(ctx context.Context, search string) (int64, error) {
+	row := q.db.QueryRow(ctx, countUsers, search)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (username, email, password_hash)
 VALUES ($1, $2, $3)
@@ -42,6 +54,48 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 	return i, err
 }
 
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1
+`
+
+// DeleteUser removes the user, the foreign keys cascade to their rooms, folders, notes and sessions
+func (q *Queries) DeleteUser(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const disableUser = `-- name: DisableUser :execrows
+UPDATE users
+SET disabled_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND disabled_at IS NULL
+`
+
+func (q *Queries) DisableUser(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, disableUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const enableUser = `-- name: EnableUser :execrows
+UPDATE users
+SET disabled_at = NULL
+WHERE id = $1 AND disabled_at IS NOT NULL
+`
+
+func (q *Queries) EnableUser(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, enableUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const findUserByEmail = `-- name: FindUserByEmail :one
 SELECT id, password_hash, username FROM users
 where email=$1
@@ -86,31 +140,164 @@ func (q *Queries) FindUserById(ctx context.Context, id int32) (FindUserByIdRow,
 	return i, err
 }
 
-const listUsers = `-- name: ListUsers :many
-SELECT id, username, email, created_at FROM users ORDER BY created_at DESC
+const getUserAccount = `-- name: GetUserAccount :one
+SELECT id, username, email, created_at, email_verified_at, disabled_at FROM users
+WHERE id = $1
 `
 
-type ListUsersRow struct {
-	ID        int32
-	Username  string
-	Email     string
-	CreatedAt pgtype.Timestamp
+type GetUserAccountRow struct {
+	ID              int32
+	Username        string
+	Email           string
+	CreatedAt       pgtype.Timestamp
+	EmailVerifiedAt pgtype.Timestamp
+	DisabledAt      pgtype.Timestamp
+}
+
+func (q *Queries) GetUserAccount(ctx context.Context, id int32) (GetUserAccountRow, error) {
+	row := q.db.QueryRow(ctx, getUserAccount, id)
+	var i GetUserAccountRow
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.DisabledAt,
+	)
+	return i, err
+}
+
+const getUserContentCounts = `-- name: GetUserContentCounts :one
+SELECT
+    (SELECT COUNT(*) FROM rooms WHERE rooms.user_id = $1::int) AS rooms,
+    (SELECT COUNT(*) FROM folders JOIN rooms ON rooms.id = folders.room_id WHERE rooms.user_id = $1::int) AS folders,
+    (SELECT COUNT(*) FROM notes JOIN rooms ON rooms.id = notes.room_id WHERE rooms.user_id = $1::int) AS notes
+`
+
+type GetUserContentCountsRow struct {
+	Rooms   int64
+	Folders int64
+	Notes   int64
+}
+
+// Folders and notes count when they are in the user's rooms, whoever created them
+func (q *Queries) GetUserContentCounts(ctx context.Context, userID int32) (GetUserContentCountsRow, error) {
+	row := q.db.QueryRow(ctx, getUserContentCounts, userID)
+	var i GetUserContentCountsRow
+	err := row.Scan(&i.Rooms, &i.Folders, &i.Notes)
+	return i, err
+}
+
+const getUserDisabledAt = `-- name: GetUserDisabledAt :one
+SELECT disabled_at FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserDisabledAt(ctx context.Context, id int32) (pgtype.Timestamp, error) {
+	row := q.db.QueryRow(ctx, getUserDisabledAt, id)
+	var disabled_at pgtype.Timestamp
+	err := row.Scan(&disabled_at)
+	return disabled_at, err
+}
+
+const getUserEmailVerifiedAt = `-- name: GetUserEmailVerifiedAt :one
+SELECT email_verified_at FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserEmailVerifiedAt(ctx context.Context, id int32) (pgtype.Timestamp, error) {
+	row := q.db.QueryRow(ctx, getUserEmailVerifiedAt, id)
+	var email_verified_at pgtype.Timestamp
+	err := row.Scan(&email_verified_at)
+	return email_verified_at, err
+}
+
+const listUsersWithLegacyPasswords = `-- name: ListUsersWithLegacyPasswords :many
+SELECT id, password_hash FROM users
+WHERE password_hash NOT LIKE '$argon2id$%'
+`
+
+type ListUsersWithLegacyPasswordsRow struct {
+	ID           int32
+	PasswordHash string
+}
+
+func (q *Queries) ListUsersWithLegacyPasswords(ctx context.Context) ([]ListUsersWithLegacyPasswordsRow, error) {
+	rows, err := q.db.Query(ctx, listUsersWithLegacyPasswords)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersWithLegacyPasswordsRow
+	for rows.Next() {
+		var i ListUsersWithLegacyPasswordsRow
+		if err := rows.Scan(&i.ID, &i.PasswordHash); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markUserEmailVerified = `-- name: MarkUserEmailVerified :exec
+UPDATE users
+SET email_verified_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND email = $2
+`
+
+type MarkUserEmailVerifiedParams struct {
+	ID    int32
+	Email string
+}
+
+func (q *Queries) MarkUserEmailVerified(ctx context.Context, arg MarkUserEmailVerifiedParams) error {
+	_, err := q.db.Exec(ctx, markUserEmailVerified, arg.ID, arg.Email)
+	return err
+}
+
+const searchUsers = `-- name: SearchUsers :many
+SELECT id, username, email, created_at, email_verified_at, disabled_at FROM users
+WHERE $1::text = '' OR username ILIKE $1::text OR email ILIKE $1::text
+ORDER BY created_at DESC, id DESC
+LIMIT $2 OFFSET $3
+`
+
+type SearchUsersParams struct {
+	Search    string
+	RowLimit  int32
+	RowOffset int32
+}
+
+type SearchUsersRow struct {
+	ID              int32
+	Username        string
+	Email           string
+	CreatedAt       pgtype.Timestamp
+	EmailVerifiedAt pgtype.Timestamp
+	DisabledAt      pgtype.Timestamp
 }
 
-func (q *Queries) ListUsers(ctx context.Context) ([]ListUsersRow, error) {
-	rows, err := q.db.Query(ctx, listUsers)
+// SearchUsers lists users newest first, search is an ILIKE pattern on username or email
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error) {
+	rows, err := q.db.Query(ctx, searchUsers, arg.Search, arg.RowLimit, arg.RowOffset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListUsersRow
+	var items []SearchUsersRow
 	for rows.Next() {
-		var i ListUsersRow
+		var i SearchUsersRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.Username,
 			&i.Email,
 			&i.CreatedAt,
+			&i.EmailVerifiedAt,
+			&i.DisabledAt,
 		); err != nil {
 			return nil, err
 		}
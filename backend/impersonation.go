@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"steamednotes/db"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// While impersonating, the admin's own token waits in this cookie.
+// It is only sent to the endpoint that ends the impersonation.
+const (
+	impersonatorCookieName = "impersonator_token"
+	impersonatorCookiePath = "/api/users/impersonate"
+)
+
+// impersonatorClaim is the impersonator_id claim, empty for normal sessions
+func impersonatorClaim(impersonatorID int32) string {
+	if impersonatorID == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(impersonatorID))
+}
+
+// isImpersonating reports whether authMiddleware found an impersonation session
+func isImpersonating(r *http.Request) bool {
+	return r.Header.Get("impersonator_id") != ""
+}
+
+// blockImpersonation refuses account and security changes in impersonation sessions,
+// it expects to run inside authMiddleware
+func blockImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isImpersonating(r) {
+			http.Error(w, "Not allowed while impersonating a user", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// statusRecorder remembers the status code of the response for the impersonation log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Hijack lets /api/ws upgrade impersonated connections, gorilla needs an http.Hijacker
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rec.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(rec.ResponseWriter).Hijack()
+}
+
+// serveImpersonated serves a request of an impersonation session and records it in impersonation_log
+func (connData ConnectionData) serveImpersonated(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next(rec, r)
+
+	sessionID, _ := strconv.Atoi(r.Header.Get("session_id"))
+	impersonatorID, _ := strconv.Atoi(r.Header.Get("impersonator_id"))
+	userID, _ := strconv.Atoi(r.Header.Get("id"))
+	logImpersonation(connData.queries, db.CreateImpersonationLogParams{
+		SessionID:         int32(sessionID),
+		ImpersonatorID:    pgtype.Int4{Int32: int32(impersonatorID), Valid: true},
+		ImpersonatorEmail: r.Header.Get("impersonator_email"),
+		UserID:            pgtype.Int4{Int32: int32(userID), Valid: true},
+		UserEmail:         r.Header.Get("email"),
+		Method:            r.Method,
+		Path:              r.URL.Path,
+		Status:            int32(rec.status),
+	})
+}
+
+// logImpersonation writes the entry even when the client went away
+func logImpersonation(queries *db.Queries, entry db.CreateImpersonationLogParams) {
+	if err := queries.CreateImpersonationLog(context.Background(), entry); err != nil {
+		fmt.Printf("Failed to log impersonated request %s %s of %s: %v\n", entry.Method, entry.Path, entry.ImpersonatorEmail, err)
+	}
+}
+
+// setImpersonatorCookie keeps the admin's token, or deletes the cookie when token is empty
+func setImpersonatorCookie(w http.ResponseWriter, token string) {
+	cookie := &http.Cookie{
+		Name:     impersonatorCookieName,
+		Value:    token,
+		HttpOnly: true,
+		Path:     impersonatorCookiePath,
+		Domain:   appConfig.Cookie.Domain,
+		Secure:   appConfig.Cookie.Secure,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if token == "" {
+		cookie.Expires = time.Unix(0, 0)
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// Start impersonating a user: the session cookie is swapped for a short session as them
+func (conn ConnectionData) startImpersonation(w http.ResponseWriter, r *http.Request) {
+	adminID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	adminEmail := r.Header.Get("email")
+
+	// authMiddleware only lets session cookies through
+	adminToken, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized - no token", http.StatusUnauthorized)
+		return
+	}
+
+	user, ok := conn.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == int32(adminID) {
+		http.Error(w, "You can't impersonate yourself", http.StatusBadRequest)
+		return
+	}
+
+	session, err := IssueSessionCookie(w, r, conn.queries, user.ID, user.Email, SessionAuth{
+		Method:            "impersonation",
+		ImpersonatorID:    int32(adminID),
+		ImpersonatorEmail: adminEmail,
+	})
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	setImpersonatorCookie(w, adminToken.Value)
+
+	fmt.Printf("%s started impersonating %s with session %d\n", adminEmail, user.Email, session.ID)
+	logImpersonation(conn.queries, db.CreateImpersonationLogParams{
+		SessionID:         session.ID,
+		ImpersonatorID:    pgtype.Int4{Int32: int32(adminID), Valid: true},
+		ImpersonatorEmail: adminEmail,
+		UserID:            pgtype.Int4{Int32: user.ID, Valid: true},
+		UserEmail:         user.Email,
+		Method:            r.Method,
+		Path:              r.URL.Path,
+		Status:            http.StatusCreated,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":    "Impersonating " + user.Email,
+		"expires_at": session.ExpiresAt.Time.Format(time.RFC3339),
+	})
+}
+
+// Stop impersonating: the impersonation session ends and the admin's own session comes back
+func (conn ConnectionData) stopImpersonation(w http.ResponseWriter, r *http.Request) {
+	if !isImpersonating(r) {
+		http.Error(w, "Not impersonating a user", http.StatusBadRequest)
+		return
+	}
+	sessionID, err1 := strconv.Atoi(r.Header.Get("session_id"))
+	userID, err2 := strconv.Atoi(r.Header.Get("id"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid session", http.StatusBadRequest)
+		return
+	}
+
+	if err := DeleteSession(r.Context(), conn.queries, int32(sessionID), int32(userID)); err != nil {
+		http.Error(w, "Failed to end impersonation", http.StatusInternalServerError)
+		return
+	}
+	conn.hub.RevokeSessions(int32(userID), func(id int32) bool { return id == int32(sessionID) })
+	fmt.Printf("%s stopped impersonating %s\n", r.Header.Get("impersonator_email"), r.Header.Get("email"))
+
+	// Only the admin who started it gets their session back, and only while it's still valid
+	restored := false
+	if cookie, err := r.Cookie(impersonatorCookieName); err == nil {
+		claims := &Claims{}
+		token, err := jwt.ParseWithClaims(cookie.Value, claims, keyring.Keyfunc)
+		if err == nil && token.Valid && claims.ID == r.Header.Get("impersonator_id") && claims.ImpersonatorID == "" {
+			if session, err := ValidateAndUpdateSession(r.Context(), conn.queries, cookie.Value); err == nil && strconv.Itoa(int(session.UserID)) == claims.ID {
+				tokenCookie := &http.Cookie{
+					Name:     "token",
+					Value:    cookie.Value,
+					HttpOnly: true,
+					Path:     "/",
+					Domain:   appConfig.Cookie.Domain,
+					Secure:   appConfig.Cookie.Secure,
+					SameSite: appConfig.Cookie.SameSiteMode(),
+				}
+				if claims.ExpiresAt != nil {
+					tokenCookie.Expires = claims.ExpiresAt.Time
+				}
+				http.SetCookie(w, tokenCookie)
+				restored = true
+			}
+		}
+	}
+	setImpersonatorCookie(w, "")
+	if !restored {
+		http.SetCookie(w, &http.Cookie{
+			Name:     "token",
+			Value:    "",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: true,
+			Path:     "/",
+			Domain:   appConfig.Cookie.Domain,
+			Secure:   appConfig.Cookie.Secure,
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Impersonation ended",
+		"restored": restored,
+	})
+}
+
+// ImpersonationLogDTO represents a request made while impersonating for JSON response
+type ImpersonationLogDTO struct {
+	ID                int64  `json:"id"`
+	SessionID         int32  `json:"session_id"`
+	ImpersonatorID    int32  `json:"impersonator_id,omitempty"`
+	ImpersonatorEmail string `json:"impersonator_email"`
+	UserID            int32  `json:"user_id,omitempty"`
+	UserEmail         string `json:"user_email"`
+	Method            string `json:"method"`
+	Path              string `json:"path"`
+	Status            int32  `json:"status"`
+	CreatedAt         string `json:"created_at"`
+}
+
+// Get the latest impersonated requests, optionally of one admin or impersonated user
+func (conn ConnectionData) getImpersonationLog(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", 100, 1, maxAdminQueryLogLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := conn.queries.ListImpersonationLog(r.Context(), db.ListImpersonationLogParams{
+		Email:    r.URL.Query().Get("email"),
+		RowLimit: int32(limit),
+	})
+	if err != nil {
+		http.Error(w, "Failed to get impersonation log", http.StatusInternalServerError)
+		return
+	}
+
+	entryDTOs := make([]ImpersonationLogDTO, len(entries))
+	for i, entry := range entries {
+		entryDTOs[i] = ImpersonationLogDTO{
+			ID:                entry.ID,
+			SessionID:         entry.SessionID,
+			ImpersonatorID:    entry.ImpersonatorID.Int32,
+			ImpersonatorEmail: entry.ImpersonatorEmail,
+			UserID:            entry.UserID.Int32,
+			UserEmail:         entry.UserEmail,
+			Method:            entry.Method,
+			Path:              entry.Path,
+			Status:            entry.Status,
+			CreatedAt:         entry.CreatedAt.Time.Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entryDTOs)
+}
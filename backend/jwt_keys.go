@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"steamednotes/db"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Algorithms a signing key can use
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+	JWTAlgorithmRS256 = "RS256"
+)
+
+var jwtAlgorithms = []string{JWTAlgorithmHS256, JWTAlgorithmEdDSA, JWTAlgorithmRS256}
+
+const (
+	keyringRefreshInterval = time.Minute     // other instances pick up rotations this fast
+	keyringMissRefresh     = 5 * time.Second // minimum time between reloads for unknown kids
+)
+
+// keyring signs and verifies every JWT we issue, set once in main
+var keyring *Keyring
+
+var errKeyNotRetirable = errors.New("key is unknown, active or already retired")
+
+// SigningKey is a parsed row of jwt_signing_keys
+type SigningKey struct {
+	Kid       string
+	Algorithm string
+	CreatedAt time.Time
+	RetiredAt time.Time // zero while the key is accepted
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Keyring holds the signing keys, the newest key that is not retired signs
+type Keyring struct {
+	queries *db.Queries
+	legacy  []byte // verifies tokens issued before keys had a kid
+
+	mu       sync.RWMutex
+	keys     map[string]*SigningKey
+	active   *SigningKey
+	loadedAt time.Time
+}
+
+// LoadKeyring loads the keys, creating a first key with the given algorithm when there is none
+func LoadKeyring(ctx context.Context, queries *db.Queries, legacySecret []byte, algorithm string) (*Keyring, error) {
+	k := &Keyring{queries: queries, legacy: legacySecret}
+	if err := k.Reload(ctx); err != nil {
+		return nil, err
+	}
+	if k.Active() == nil {
+		if _, err := k.Rotate(ctx, algorithm); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Reload reads the keys from the database
+func (k *Keyring) Reload(ctx context.Context) error {
+	rows, err := k.queries.ListJWTSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*SigningKey, len(rows))
+	var active *SigningKey
+	for _, row := range rows {
+		key, err := parseSigningKey(row)
+		if err != nil {
+			return fmt.Errorf("jwt signing key %s: %w", row.Kid, err)
+		}
+		keys[key.Kid] = key
+		if key.RetiredAt.IsZero() {
+			active = key // rows are ordered by id, the last one wins
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.active = active
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+// refresh reloads the keys when they are older than maxAge, errors keep the loaded keys
+func (k *Keyring) refresh(maxAge time.Duration) {
+	k.mu.RLock()
+	stale := time.Since(k.loadedAt) > maxAge
+	k.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := k.Reload(ctx); err != nil {
+		fmt.Printf("Failed to reload jwt signing keys: %v\n", err)
+	}
+}
+
+// Active returns the key new tokens are signed with
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Keys returns all keys, retired ones included, oldest first
+func (k *Keyring) Keys() []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]*SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b *SigningKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return keys
+}
+
+// Sign signs the claims with the active key and puts its kid in the header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k.refresh(keyringRefreshInterval)
+
+	key := k.Active()
+	if key == nil {
+		return "", errors.New("no active jwt signing key")
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc resolves the verification key from the token's kid, for jwt.Parse
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if len(k.legacy) == 0 || token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("token has no kid")
+		}
+		return k.legacy, nil
+	}
+
+	k.refresh(keyringRefreshInterval)
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if !ok {
+		// Possibly rotated on another instance
+		k.refresh(keyringMissRefresh)
+		k.mu.RLock()
+		key, ok = k.keys[kid]
+		k.mu.RUnlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %s", kid)
+	}
+	if !key.RetiredAt.IsZero() {
+		return nil, fmt.Errorf("kid %s is retired", kid)
+	}
+	// The algorithm comes from the key, never from the token
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("kid %s does not use %s", kid, token.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+// Rotate creates a new key that becomes the active signer, older keys keep verifying
+func (k *Keyring) Rotate(ctx context.Context, algorithm string) (*SigningKey, error) {
+	params, err := generateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	row, err := k.queries.CreateJWTSigningKey(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return parseSigningKey(row)
+}
+
+// Retire stops accepting tokens signed with the key, the active key can't be retired
+func (k *Keyring) Retire(ctx context.Context, kid string) error {
+	retired, err := k.queries.RetireJWTSigningKey(ctx, kid)
+	if err != nil {
+		return err
+	}
+	if retired == 0 {
+		return errKeyNotRetirable
+	}
+	return k.Reload(ctx)
+}
+
+// RetireReplacedJWTSigningKeys retires keys replaced longer than a session ago,
+// every token they signed has expired
+func RetireReplacedJWTSigningKeys(ctx context.Context, queries *db.Queries) error {
+	retired, err := queries.RetireReplacedJWTSigningKeys(ctx, pgtype.Timestamp{
+		Time:  time.Now().Add(-appConfig.Session.TTL),
+		Valid: true,
+	})
+	if err != nil {
+		return err
+	}
+	if retired > 0 && keyring != nil {
+		return keyring.Reload(ctx)
+	}
+	return nil
+}
+
+// generateSigningKey creates the key material for a new row
+func generateSigningKey(algorithm string) (db.CreateJWTSigningKeyParams, error) {
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return db.CreateJWTSigningKeyParams{}, err
+	}
+	params := db.CreateJWTSigningKeyParams{
+		Kid:       hex.EncodeToString(kidBytes),
+		Algorithm: algorithm,
+	}
+
+	var private, public interface{}
+	switch algorithm {
+	case JWTAlgorithmHS256:
+		params.PrivateKey = make([]byte, 32)
+		if _, err := rand.Read(params.PrivateKey); err != nil {
+			return db.CreateJWTSigningKeyParams{}, err
+		}
+		return params, nil
+	case JWTAlgorithmEdDSA:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return db.CreateJWTSigningKeyParams{}, err
+		}
+		private, public = privateKey, publicKey
+	case JWTAlgorithmRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return db.CreateJWTSigningKeyParams{}, err
+		}
+		private, public = privateKey, &privateKey.PublicKey
+	default:
+		return db.CreateJWTSigningKeyParams{}, fmt.Errorf("unknown jwt algorithm %q", algorithm)
+	}
+
+	var err error
+	if params.PrivateKey, err = x509.MarshalPKCS8PrivateKey(private); err != nil {
+		return db.CreateJWTSigningKeyParams{}, err
+	}
+	if params.PublicKey, err = x509.MarshalPKIXPublicKey(public); err != nil {
+		return db.CreateJWTSigningKeyParams{}, err
+	}
+	return params, nil
+}
+
+// parseSigningKey turns a stored row into usable keys
+func parseSigningKey(row db.JwtSigningKey) (*SigningKey, error) {
+	key := &SigningKey{
+		Kid:       row.Kid,
+		Algorithm: row.Algorithm,
+		CreatedAt: row.CreatedAt.Time,
+	}
+	if row.RetiredAt.Valid {
+		key.RetiredAt = row.RetiredAt.Time
+	}
+
+	if row.Algorithm == JWTAlgorithmHS256 {
+		key.method = jwt.SigningMethodHS256
+		key.signKey, key.verifyKey = row.PrivateKey, row.PrivateKey
+		return key, nil
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(row.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.ParsePKIXPublicKey(row.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch row.Algorithm {
+	case JWTAlgorithmEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+		_, okPrivate := private.(ed25519.PrivateKey)
+		_, okPublic := public.(ed25519.PublicKey)
+		if !okPrivate || !okPublic {
+			return nil, errors.New("not an Ed25519 key")
+		}
+	case JWTAlgorithmRS256:
+		key.method = jwt.SigningMethodRS256
+		_, okPrivate := private.(*rsa.PrivateKey)
+		_, okPublic := public.(*rsa.PublicKey)
+		if !okPrivate || !okPublic {
+			return nil, errors.New("not an RSA key")
+		}
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", row.Algorithm)
+	}
+	key.signKey, key.verifyKey = private, public
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`   // OKP
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+}
+
+// jwk returns the public key as a JWK, false for HMAC keys which have none
+func (key *SigningKey) jwk() (JWK, bool) {
+	jwk := JWK{Kid: key.Kid, Alg: key.Algorithm, Use: "sig"}
+	switch public := key.verifyKey.(type) {
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// Public keys of the accepted asymmetric keys, so other services can verify our tokens
+func (conn ConnectionData) getJWKS(w http.ResponseWriter, r *http.Request) {
+	keys := []JWK{}
+	for _, key := range keyring.Keys() {
+		if !key.RetiredAt.IsZero() {
+			continue
+		}
+		if jwk, ok := key.jwk(); ok {
+			keys = append(keys, jwk)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]JWK{"keys": keys})
+}
+
+// SigningKeyDTO represents a signing key for JSON response, without key material
+type SigningKeyDTO struct {
+	Kid       string `json:"kid"`
+	Algorithm string `json:"algorithm"`
+	Active    bool   `json:"active"`
+	CreatedAt string `json:"created_at"`
+	RetiredAt string `json:"retired_at,omitempty"`
+}
+
+func newSigningKeyDTO(key *SigningKey, active *SigningKey) SigningKeyDTO {
+	dto := SigningKeyDTO{
+		Kid:       key.Kid,
+		Algorithm: key.Algorithm,
+		Active:    active != nil && key.Kid == active.Kid,
+		CreatedAt: key.CreatedAt.Format(time.RFC3339),
+	}
+	if !key.RetiredAt.IsZero() {
+		dto.RetiredAt = key.RetiredAt.Format(time.RFC3339)
+	}
+	return dto
+}
+
+// List the jwt signing keys
+func (conn ConnectionData) getSigningKeys(w http.ResponseWriter, r *http.Request) {
+	if err := keyring.Reload(r.Context()); err != nil {
+		http.Error(w, "Failed to get keys", http.StatusInternalServerError)
+		return
+	}
+
+	active := keyring.Active()
+	keys := keyring.Keys()
+	keyDTOs := make([]SigningKeyDTO, len(keys))
+	for i, key := range keys {
+		keyDTOs[i] = newSigningKeyDTO(key, active)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keyDTOs)
+}
+
+type RotateSigningKeyRequest struct {
+	Algorithm string `json:"algorithm"` // defaults to the configured jwt_algorithm
+}
+
+// Create a new active signing key, tokens of the old key stay valid until it is retired
+func (conn ConnectionData) rotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	var req RotateSigningKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Algorithm == "" {
+		req.Algorithm = appConfig.JWTAlgorithm
+	}
+	if !slices.Contains(jwtAlgorithms, req.Algorithm) {
+		http.Error(w, "Unknown algorithm "+req.Algorithm, http.StatusBadRequest)
+		return
+	}
+
+	key, err := keyring.Rotate(r.Context(), req.Algorithm)
+	if err != nil {
+		fmt.Printf("Failed to rotate jwt signing key: %v\n", err)
+		http.Error(w, "Failed to rotate key", http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Jwt signing key rotated to %s by %s\n", key.Kid, r.Header.Get("email"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newSigningKeyDTO(key, key))
+}
+
+// Retire a signing key, every token it signed stops working
+func (conn ConnectionData) retireSigningKey(w http.ResponseWriter, r *http.Request) {
+	kid := r.URL.Query().Get("kid")
+	if kid == "" {
+		http.Error(w, "Missing kid parameter", http.StatusBadRequest)
+		return
+	}
+
+	if err := keyring.Retire(r.Context(), kid); err != nil {
+		if errors.Is(err, errKeyNotRetirable) {
+			http.Error(w, "Key not found, active or already retired", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retire key", http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Jwt signing key %s retired by %s\n", kid, r.Header.Get("email"))
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Key retired successfully"})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Lockouts are kept per account and per client IP
+const (
+	LockoutScopeAccount = "account"
+	LockoutScopeIP      = "ip"
+)
+
+const (
+	accountLockoutThreshold = 5  // failures before the account is locked
+	ipLockoutThreshold      = 20 // failures before the IP is locked, it may be shared
+	lockoutBaseDuration     = time.Minute
+	lockoutMaxDuration      = time.Hour
+	lockoutFailureWindow    = 24 * time.Hour // failures older than this are forgotten
+	signInAttemptRetention  = 90 * 24 * time.Hour
+	maxSignInAttemptsLimit  = 1000
+)
+
+// lockoutDuration doubles with every failure past the threshold
+func lockoutDuration(failures, threshold int32) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	duration := lockoutBaseDuration
+	for i := threshold; i < failures && duration < lockoutMaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > lockoutMaxDuration {
+		return lockoutMaxDuration
+	}
+	return duration
+}
+
+// lockoutSubject normalizes the email so case variants share a lockout
+func lockoutSubject(scope, subject string) string {
+	if scope == LockoutScopeAccount {
+		return strings.ToLower(strings.TrimSpace(subject))
+	}
+	return subject
+}
+
+// signInLockedFor returns how long sign in stays locked for the email or the client IP, 0 when it isn't.
+// Emails without an account lock the same way, so a lockout doesn't reveal whether the account exists.
+func signInLockedFor(ctx context.Context, queries *db.Queries, email, clientIP string) time.Duration {
+	var wait time.Duration
+	for scope, subject := range map[string]string{LockoutScopeAccount: email, LockoutScopeIP: clientIP} {
+		lockout, err := queries.GetSignInLockout(ctx, db.GetSignInLockoutParams{
+			Scope:   scope,
+			Subject: lockoutSubject(scope, subject),
+		})
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				fmt.Printf("Failed to get %s lockout: %v\n", scope, err)
+			}
+			continue
+		}
+		if lockout.LockedUntil.Valid {
+			wait = max(wait, time.Until(lockout.LockedUntil.Time))
+		}
+	}
+	return wait
+}
+
+// recordSignInAttempt stores the attempt for the admin, userID 0 is an unknown account
+func recordSignInAttempt(ctx context.Context, queries *db.Queries, r *http.Request, email string, userID int32, failureReason string) {
+	var ipAddr *netip.Addr
+	if clientIP, ok := ClientIP(r); ok {
+		ipAddr = &clientIP
+	}
+	userAgent := r.Header.Get("User-Agent")
+
+	err := queries.RecordSignInAttempt(ctx, db.RecordSignInAttemptParams{
+		Email:         email,
+		UserID:        pgtype.Int4{Int32: userID, Valid: userID != 0},
+		IpAddress:     ipAddr,
+		UserAgent:     pgtype.Text{String: userAgent, Valid: userAgent != ""},
+		Succeeded:     failureReason == "",
+		FailureReason: pgtype.Text{String: failureReason, Valid: failureReason != ""},
+	})
+	if err != nil {
+		fmt.Printf("Failed to record sign in attempt for %s: %v\n", email, err)
+	}
+}
+
+// recordSignInFailure records the attempt and locks the account and the IP once they pass their threshold
+func recordSignInFailure(ctx context.Context, queries *db.Queries, r *http.Request, email string, userID int32, reason string) {
+	recordSignInAttempt(ctx, queries, r, email, userID, reason)
+
+	thresholds := map[string]int32{LockoutScopeAccount: accountLockoutThreshold, LockoutScopeIP: ipLockoutThreshold}
+	subjects := map[string]string{LockoutScopeAccount: email, LockoutScopeIP: GetClientIP(r)}
+	for scope, subject := range subjects {
+		subject = lockoutSubject(scope, subject)
+		lockout, err := queries.RegisterSignInFailure(ctx, db.RegisterSignInFailureParams{
+			Scope:        scope,
+			Subject:      subject,
+			LastFailedAt: pgtype.Timestamp{Time: time.Now().Add(-lockoutFailureWindow), Valid: true},
+		})
+		if err != nil {
+			fmt.Printf("Failed to register %s sign in failure: %v\n", scope, err)
+			continue
+		}
+
+		duration := lockoutDuration(lockout.FailedCount, thresholds[scope])
+		if duration == 0 {
+			continue
+		}
+		fmt.Printf("Locking sign in for %s %s for %v after %d failures\n", scope, subject, duration, lockout.FailedCount)
+		err = queries.LockSignIn(ctx, db.LockSignInParams{
+			Scope:       scope,
+			Subject:     subject,
+			LockedUntil: pgtype.Timestamp{Time: time.Now().Add(duration), Valid: true},
+		})
+		if err != nil {
+			fmt.Printf("Failed to lock %s sign in: %v\n", scope, err)
+		}
+	}
+}
+
+// recordSignInSuccess records the attempt and forgets the account's failures, the IP's stay
+func recordSignInSuccess(ctx context.Context, queries *db.Queries, r *http.Request, email string, userID int32) {
+	recordSignInAttempt(ctx, queries, r, email, userID, "")
+
+	_, err := queries.DeleteSignInLockout(ctx, db.DeleteSignInLockoutParams{
+		Scope:   LockoutScopeAccount,
+		Subject: lockoutSubject(LockoutScopeAccount, email),
+	})
+	if err != nil {
+		fmt.Printf("Failed to reset sign in failures for %s: %v\n", email, err)
+	}
+}
+
+// writeSignInLocked answers a locked sign in
+func writeSignInLocked(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed sign in attempts, try again later", http.StatusTooManyRequests)
+}
+
+// CleanupSignInAttempts drops old attempts and forgotten failure counts
+func CleanupSignInAttempts(ctx context.Context, queries *db.Queries) error {
+	err := queries.CleanupOldSignInAttempts(ctx, pgtype.Timestamp{Time: time.Now().Add(-signInAttemptRetention), Valid: true})
+	if err != nil {
+		return err
+	}
+	return queries.CleanupOldSignInLockouts(ctx, pgtype.Timestamp{Time: time.Now().Add(-lockoutFailureWindow), Valid: true})
+}
+
+// SignInLockoutDTO represents a lockout for JSON response
+type SignInLockoutDTO struct {
+	Scope        string `json:"scope"`
+	Subject      string `json:"subject"`
+	FailedCount  int32  `json:"failed_count"`
+	LastFailedAt string `json:"last_failed_at"`
+	LockedUntil  string `json:"locked_until,omitempty"`
+	Locked       bool   `json:"locked"`
+}
+
+// FailedSignInsByIPDTO summarizes the failures from one IP
+type FailedSignInsByIPDTO struct {
+	IPAddress     string `json:"ip_address"`
+	Failures      int64  `json:"failures"`
+	Accounts      int64  `json:"accounts"` // distinct emails tried
+	LastAttemptAt string `json:"last_attempt_at"`
+}
+
+// SignInAttemptDTO represents a sign in attempt for JSON response
+type SignInAttemptDTO struct {
+	ID            int64  `json:"id"`
+	Email         string `json:"email"`
+	UserID        int32  `json:"user_id,omitempty"`
+	IPAddress     string `json:"ip_address,omitempty"`
+	UserAgent     string `json:"user_agent,omitempty"`
+	Succeeded     bool   `json:"succeeded"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// Get the current lockouts and the IPs with the most failures in the last day
+func (conn ConnectionData) getSignInLockouts(w http.ResponseWriter, r *http.Request) {
+	since := pgtype.Timestamp{Time: time.Now().Add(-lockoutFailureWindow), Valid: true}
+	lockouts, err := conn.queries.ListSignInLockouts(r.Context(), since)
+	if err != nil {
+		http.Error(w, "Failed to get lockouts", http.StatusInternalServerError)
+		return
+	}
+	byIP, err := conn.queries.ListFailedSignInsByIP(r.Context(), since)
+	if err != nil {
+		http.Error(w, "Failed to get lockouts", http.StatusInternalServerError)
+		return
+	}
+
+	lockoutDTOs := make([]SignInLockoutDTO, len(lockouts))
+	for i, lockout := range lockouts {
+		lockoutDTOs[i] = SignInLockoutDTO{
+			Scope:        lockout.Scope,
+			Subject:      lockout.Subject,
+			FailedCount:  lockout.FailedCount,
+			LastFailedAt: lockout.LastFailedAt.Time.Format(time.RFC3339),
+			Locked:       lockout.LockedUntil.Valid && lockout.LockedUntil.Time.After(time.Now()),
+		}
+		if lockout.LockedUntil.Valid {
+			lockoutDTOs[i].LockedUntil = lockout.LockedUntil.Time.Format(time.RFC3339)
+		}
+	}
+
+	byIPDTOs := make([]FailedSignInsByIPDTO, len(byIP))
+	for i, row := range byIP {
+		byIPDTOs[i] = FailedSignInsByIPDTO{
+			IPAddress:     row.IpAddress,
+			Failures:      row.Failures,
+			Accounts:      row.Accounts,
+			LastAttemptAt: row.LastAttemptAt.Time.Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"lockouts":       lockoutDTOs,
+		"failures_by_ip": byIPDTOs,
+	})
+}
+
+// Get recent sign in attempts, optionally filtered by email and IP
+func (conn ConnectionData) getSignInAttempts(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxSignInAttemptsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxSignInAttemptsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	attempts, err := conn.queries.ListSignInAttempts(r.Context(), db.ListSignInAttemptsParams{
+		Email:     r.URL.Query().Get("email"),
+		IpAddress: r.URL.Query().Get("ip"),
+		RowLimit:  int32(limit),
+	})
+	if err != nil {
+		http.Error(w, "Failed to get sign in attempts", http.StatusInternalServerError)
+		return
+	}
+
+	attemptDTOs := make([]SignInAttemptDTO, len(attempts))
+	for i, attempt := range attempts {
+		attemptDTOs[i] = SignInAttemptDTO{
+			ID:            attempt.ID,
+			Email:         attempt.Email,
+			UserID:        attempt.UserID.Int32,
+			UserAgent:     attempt.UserAgent.String,
+			Succeeded:     attempt.Succeeded,
+			FailureReason: attempt.FailureReason.String,
+			CreatedAt:     attempt.CreatedAt.Time.Format(time.RFC3339),
+		}
+		if attempt.IpAddress != nil {
+			attemptDTOs[i].IPAddress = attempt.IpAddress.String()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attemptDTOs)
+}
+
+// Clear a lockout and its failure count, e.g. after the user proved who they are
+func (conn ConnectionData) deleteSignInLockout(w http.ResponseWriter, r *http.Request) {
+	scope := r.URL.Query().Get("scope")
+	if scope != LockoutScopeAccount && scope != LockoutScopeIP {
+		http.Error(w, "scope must be account or ip", http.StatusBadRequest)
+		return
+	}
+	subject := r.URL.Query().Get("subject")
+	if subject == "" {
+		http.Error(w, "Missing subject parameter", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := conn.queries.DeleteSignInLockout(r.Context(), db.DeleteSignInLockoutParams{
+		Scope:   scope,
+		Subject: lockoutSubject(scope, subject),
+	})
+	if err != nil {
+		http.Error(w, "Failed to clear lockout", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Lockout not found", http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Sign in lockout for %s %s cleared by %s\n", scope, subject, r.Header.Get("email"))
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Lockout cleared successfully"})
+}
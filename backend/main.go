@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,13 +10,14 @@ import (
 	"steamednotes/db" // Adjust based on your module path
 
 	// "github.com/jackc/pgx/v5"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// Dummy user struct
+// Sign in credentials
 type User struct {
 	Email    string
-	Password string // Plaintext for now—hash later
+	Password string
 }
 
 // Note struct
@@ -29,27 +29,32 @@ type Note struct {
 
 // Connection struct
 type ConnectionData struct {
-	queries *db.Queries
+	queries       *db.Queries
+	mailer        Mailer
+	webAuthn      *webauthn.WebAuthn // nil when passkeys are disabled
+	oidcProviders map[string]*OIDCProvider
+	rateLimiter   RateLimitStore // nil when rate limiting is disabled
+	authz         *Authorizer    // access to rooms, folders and notes
+	collab        *Collab        // notes being edited over /api/ws
+	hub           *Hub           // WebSocket connections and their subscriptions
 }
 
 // Connection For Admin
 type ConnectionDataAdmin struct {
 	queries *db.Queries
-	pool    *pgxpool.Pool
+	pool    *pgxpool.Pool // the read-only admin query role, not the app's pool
 }
 
-// In-memory store
-var (
-	secret = []byte("super-secret-key")
-)
-
 func main() {
+	cfg, args, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v\n", err)
+	}
+	appConfig = cfg
 
-	connStr := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"))
+	passwordParams = LoadPasswordParamsFromEnv()
 
-	conn, err := pgxpool.New(context.Background(), connStr)
+	conn, err := pgxpool.New(context.Background(), cfg.Database.DSN())
 	if err != nil {
 		log.Fatalf("Unable to connect to database: %v\n", err)
 	} else {
@@ -59,79 +64,213 @@ func main() {
 	defer conn.Close()
 
 	queries := db.New(conn)
-	connData := ConnectionData{queries: queries}
-	conAdminData := ConnectionDataAdmin{queries: queries, pool: conn}
 
-	// List users handler
-	http.HandleFunc("GET /api/users", func(w http.ResponseWriter, r *http.Request) {
-		users, err := queries.ListUsers(r.Context())
-		if err != nil {
-			http.Error(w, `{"error":"Failed to list users"}`, http.StatusInternalServerError)
-			return
+	// One-off maintenance commands, e.g. `./steamednotes hash-passwords`
+	if len(args) > 0 {
+		if err := runCommand(context.Background(), queries, args); err != nil {
+			log.Fatalf("Command failed: %v\n", err)
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(users)
+		return
+	}
 
-	})
+	keyring, err = LoadKeyring(context.Background(), queries, []byte(cfg.JWTSecret), cfg.JWTAlgorithm)
+	if err != nil {
+		log.Fatalf("Unable to load jwt signing keys: %v\n", err)
+	}
 
-	// Create user handler
-	http.HandleFunc("POST /api/adduser", func(w http.ResponseWriter, r *http.Request) {
-		var req db.CreateUserParams
+	webAuthn, err := NewWebAuthnFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid WebAuthn configuration: %v\n", err)
+	}
 
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, `{"error":"Invalid input"}`, http.StatusBadRequest)
-			return
-		}
-		// Basic validation
-		if req.Username == "" || req.Email == "" || req.PasswordHash == "" {
-			http.Error(w, `{"error":"Username, email, and password_hash are required"}`, http.StatusBadRequest)
-			return
-		}
-		user, err := queries.CreateUser(r.Context(), req)
-		if err != nil {
-			// Handle specific errors (e.g., duplicate username/email)
-			http.Error(w, `{"error":"Failed to create user: `+err.Error()+`"}`, http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(user)
-	})
-
-	http.HandleFunc("GET /api/notes", connData.authMiddleware(connData.getNotes))
-	http.HandleFunc("GET /api/notes/getnote", connData.authMiddleware(connData.getNote))
-	http.HandleFunc("POST /api/notes/create", connData.authMiddleware(connData.createNote))
-	http.HandleFunc("PATCH /api/note/update", connData.authMiddleware(connData.updateNote))
-	http.HandleFunc("DELETE /api/note/delete", connData.authMiddleware(connData.deleteNote))
-	http.HandleFunc("POST /api/signin", connData.signIn)
-
-	http.HandleFunc("GET /api/users/create", createUser)
-	http.HandleFunc("POST /api/rooms/create", connData.authMiddleware(connData.createRoom))
-	http.HandleFunc("GET /api/rooms/get", connData.authMiddleware(connData.getRooms))
-	http.HandleFunc("GET /api/rooms/getdetails", connData.authMiddleware(connData.getRoomDetails))
-	http.HandleFunc("POST /api/folders/create", connData.authMiddleware(connData.createFolder))
-	http.HandleFunc("GET /api/folders/get", connData.authMiddleware(connData.getFoldersByRoom))
-	http.HandleFunc("GET /api/folders/getdetails", connData.authMiddleware(connData.getFolderDetails))
+	oidcProviders, err := LoadOIDCProvidersFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid OIDC configuration: %v\n", err)
+	}
+
+	mailer, err := NewMailerFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid mail configuration: %v\n", err)
+	}
+
+	rateLimiter, err := NewRateLimitStore(cfg.RateLimit, queries)
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v\n", err)
+	}
+
+	authz := NewAuthorizer(queries)
+	collab := NewCollab(queries, authz)
+	go collab.SaveLoop()
+
+	connData := ConnectionData{
+		queries:       queries,
+		mailer:        mailer,
+		webAuthn:      webAuthn,
+		oidcProviders: oidcProviders,
+		rateLimiter:   rateLimiter,
+		authz:         authz,
+		collab:        collab,
+		hub:           NewHub(),
+	}
+
+	http.HandleFunc("GET /api/notes", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getNotes))))
+	http.HandleFunc("GET /api/notes/getnote", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getNote))))
+	http.HandleFunc("POST /api/notes/create", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.createNote))))
+	http.HandleFunc("PATCH /api/note/update", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.updateNote))))
+	http.HandleFunc("DELETE /api/note/delete", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.deleteNote))))
+	http.HandleFunc("POST /api/signin", connData.rateLimit(RateLimitSignIn, connData.signIn))
+	http.HandleFunc("POST /api/signin/2fa", connData.rateLimit(RateLimitSignIn, connData.signInMFA))
+
+	http.HandleFunc("POST /api/signup", connData.rateLimit(RateLimitSignUp, connData.signUp))
+	http.HandleFunc("POST /api/rooms/create", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.createRoom))))
+	http.HandleFunc("GET /api/rooms/get", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getRooms))))
+	http.HandleFunc("GET /api/rooms/getdetails", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getRoomDetails))))
+	http.HandleFunc("GET /api/rooms/members", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getRoomMembers))))
+	http.HandleFunc("POST /api/rooms/members", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.rateLimit(RateLimitEmail, connData.requireVerifiedEmail(connData.inviteRoomMember)))))
+	http.HandleFunc("PATCH /api/rooms/members", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.updateRoomMember))))
+	http.HandleFunc("DELETE /api/rooms/members", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.deleteRoomMember))))
+	http.HandleFunc("GET /api/rooms/invites", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getRoomInvites))))
+	http.HandleFunc("POST /api/rooms/invites/accept", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.acceptRoomInvite))))
+	http.HandleFunc("POST /api/rooms/invite-links", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.createRoomInviteLink))))
+	http.HandleFunc("GET /api/rooms/invite-links", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getRoomInviteLinks))))
+	http.HandleFunc("DELETE /api/rooms/invite-links", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.revokeRoomInviteLink))))
+	http.HandleFunc("POST /api/rooms/invite-links/redeem", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.redeemRoomInviteLink))))
+	http.HandleFunc("POST /api/folders/create", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.createFolder))))
+	http.HandleFunc("GET /api/folders/get", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getFoldersByRoom))))
+	http.HandleFunc("GET /api/folders/getdetails", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getFolderDetails))))
+
+	// Share links, GET /api/public/{token} needs no account
+	http.HandleFunc("POST /api/share-links", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.createShareLink))))
+	http.HandleFunc("GET /api/share-links", connData.requireScope(ScopeNotesRead, connData.rateLimit(RateLimitNotesRead, connData.requireVerifiedEmail(connData.getShareLinks))))
+	http.HandleFunc("DELETE /api/share-links", connData.requireScope(ScopeNotesWrite, connData.rateLimit(RateLimitNotesWrite, connData.requireVerifiedEmail(connData.revokeShareLink))))
+	http.HandleFunc("GET /api/public/{token}", connData.rateLimit(RateLimitPublic, connData.getPublicShare))
+
+	// Email verification endpoints
+	http.HandleFunc("POST /api/verify-email/request", connData.authMiddleware(connData.rateLimit(RateLimitEmail, connData.requestEmailVerification)))
+	http.HandleFunc("GET /api/verify-email/confirm", connData.confirmEmailVerification)
+
+	// Password reset endpoints
+	http.HandleFunc("POST /api/password-reset/request", connData.rateLimit(RateLimitEmail, connData.requestPasswordReset))
+	http.HandleFunc("POST /api/password-reset/confirm", connData.rateLimit(RateLimitSignIn, connData.confirmPasswordReset))
 
 	http.HandleFunc("GET /api/users/issignedin", connData.authMiddleware(isSignedIn))
 
 	http.HandleFunc("POST /api/logout", connData.authMiddleware(connData.logout))
 
-	// Session management endpoints
+	// Session management endpoints, impersonation sessions can't change the account or its sign in methods
 	http.HandleFunc("GET /api/sessions", connData.authMiddleware(connData.getSessions))
-	http.HandleFunc("DELETE /api/sessions", connData.authMiddleware(connData.deleteSession))
-	http.HandleFunc("DELETE /api/sessions/others", connData.authMiddleware(connData.deleteAllOtherSessions))
-	http.HandleFunc("POST /api/change-password", connData.authMiddleware(connData.changePassword))
+	http.HandleFunc("DELETE /api/sessions", connData.authMiddleware(blockImpersonation(connData.deleteSession)))
+	http.HandleFunc("DELETE /api/sessions/others", connData.authMiddleware(blockImpersonation(connData.deleteAllOtherSessions)))
+	http.HandleFunc("POST /api/change-password", connData.authMiddleware(blockImpersonation(connData.changePassword)))
+
+	// Two-factor authentication endpoints
+	http.HandleFunc("GET /api/2fa", connData.authMiddleware(connData.getTwoFactorStatus))
+	http.HandleFunc("POST /api/2fa/setup", connData.authMiddleware(blockImpersonation(connData.setupTwoFactor)))
+	http.HandleFunc("POST /api/2fa/enable", connData.authMiddleware(blockImpersonation(connData.enableTwoFactor)))
+	http.HandleFunc("POST /api/2fa/recovery-codes", connData.authMiddleware(blockImpersonation(connData.regenerateRecoveryCodes)))
+	http.HandleFunc("DELETE /api/2fa", connData.authMiddleware(blockImpersonation(connData.disableTwoFactor)))
+
+	// Passkey endpoints
+	http.HandleFunc("POST /api/passkeys/register/begin", connData.requireWebAuthn(connData.authMiddleware(blockImpersonation(connData.beginPasskeyRegistration))))
+	http.HandleFunc("POST /api/passkeys/register/finish", connData.requireWebAuthn(connData.authMiddleware(blockImpersonation(connData.finishPasskeyRegistration))))
+	http.HandleFunc("POST /api/passkeys/login/begin", connData.requireWebAuthn(connData.rateLimit(RateLimitSignIn, connData.beginPasskeyLogin)))
+	http.HandleFunc("POST /api/passkeys/login/finish", connData.requireWebAuthn(connData.rateLimit(RateLimitSignIn, connData.finishPasskeyLogin)))
+	http.HandleFunc("GET /api/passkeys", connData.authMiddleware(connData.getPasskeys))
+	http.HandleFunc("PATCH /api/passkeys", connData.authMiddleware(blockImpersonation(connData.renamePasskey)))
+	http.HandleFunc("DELETE /api/passkeys", connData.authMiddleware(blockImpersonation(connData.deletePasskey)))
+
+	// OpenID Connect endpoints
+	http.HandleFunc("GET /api/oidc/providers", connData.getOIDCProviders)
+	http.HandleFunc("GET /api/oidc/{provider}/login", connData.rateLimit(RateLimitSignIn, connData.oidcLogin))
+	http.HandleFunc("GET /api/oidc/{provider}/callback", connData.oidcCallback)
+	http.HandleFunc("GET /api/oidc/identities", connData.authMiddleware(connData.getIdentities))
+	http.HandleFunc("DELETE /api/oidc/identities", connData.authMiddleware(blockImpersonation(connData.deleteIdentity)))
+
+	// Personal access token endpoints, tokens themselves can't manage tokens
+	http.HandleFunc("POST /api/tokens", connData.authMiddleware(blockImpersonation(connData.createPersonalAccessToken)))
+	http.HandleFunc("GET /api/tokens", connData.authMiddleware(connData.getPersonalAccessTokens))
+	http.HandleFunc("DELETE /api/tokens", connData.authMiddleware(blockImpersonation(connData.deletePersonalAccessToken)))
 
 	http.HandleFunc("GET /api/export", exportHandler)
 
-	http.HandleFunc("/api/ws", connData.authMiddleware(handleWebSocket))
+	// Collaborative editing, see "Collaborative editing" in Docs/DevNotes.md
+	http.HandleFunc("/api/ws", connData.authMiddleware(connData.requireVerifiedEmail(connData.handleWebSocket)))
+
+	// CSRF token for the X-CSRF-Token header of mutating requests
+	http.HandleFunc("GET /api/csrf", getCSRFToken)
+
+	// Sign in lockouts and attempts
+	http.HandleFunc("GET /api/admin/lockouts", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionSecurityRead, connData.getSignInLockouts)))
+	http.HandleFunc("DELETE /api/admin/lockouts", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionSecurityManage, connData.deleteSignInLockout)))
+	http.HandleFunc("GET /api/admin/sign-in-attempts", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionSecurityRead, connData.getSignInAttempts)))
+
+	// JWT signing keys, the JWKS lists the public keys of asymmetric ones
+	http.HandleFunc("GET /api/jwks.json", connData.getJWKS)
+	http.HandleFunc("GET /api/admin/jwt-keys", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionSecurityRead, connData.getSigningKeys)))
+	http.HandleFunc("POST /api/admin/jwt-keys/rotate", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionKeysManage, connData.rotateSigningKey)))
+	http.HandleFunc("DELETE /api/admin/jwt-keys", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionKeysManage, connData.retireSigningKey)))
+
+	// Roles and permissions
+	http.HandleFunc("GET /api/users/permissions", connData.authMiddleware(connData.getMyPermissions))
+	http.HandleFunc("GET /api/admin/roles", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionUsersRead, connData.getRoles)))
+	http.HandleFunc("GET /api/admin/user-roles", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionUsersRead, connData.getUserRoles)))
+	http.HandleFunc("POST /api/admin/user-roles", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionRolesManage, connData.grantUserRole)))
+	http.HandleFunc("DELETE /api/admin/user-roles", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionRolesManage, connData.revokeUserRole)))
 
-	http.HandleFunc("POST /api/admin", connData.authMiddleware(conAdminData.adminQuery))
+	// User management
+	http.HandleFunc("GET /api/admin/users", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionUsersRead, connData.getAdminUsers)))
+	http.HandleFunc("GET /api/admin/users/details", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionUsersRead, connData.getAdminUser)))
+	http.HandleFunc("POST /api/admin/users/disable", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionUsersManage, connData.disableUser)))
+	http.HandleFunc("POST /api/admin/users/enable", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionUsersManage, connData.enableUser)))
+	http.HandleFunc("POST /api/admin/users/logout", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionSecurityManage, connData.logoutUser)))
+	http.HandleFunc("POST /api/admin/users/password-reset", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionUsersManage, connData.forcePasswordReset)))
+	http.HandleFunc("DELETE /api/admin/users", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionUsersManage, connData.deleteUser)))
+
+	// Impersonation, only with a session cookie since the cookies are swapped
+	http.HandleFunc("POST /api/admin/impersonate", connData.authMiddleware(connData.RequirePermission(PermissionUsersImpersonate, connData.startImpersonation)))
+	http.HandleFunc("POST /api/users/impersonate/stop", connData.authMiddleware(connData.stopImpersonation))
+	http.HandleFunc("GET /api/admin/impersonation-log", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionAuditRead, connData.getImpersonationLog)))
+
+	if cfg.Features.AdminQuery {
+		adminPool, err := NewAdminQueryPool(context.Background(), cfg.AdminQuery)
+		if err != nil {
+			log.Fatalf("Invalid admin query configuration: %v\n", err)
+		}
+		if adminPool == nil {
+			log.Printf("Admin query console disabled, admin_query database_url (ADMIN_QUERY_DATABASE_URL) is not set")
+		} else {
+			defer adminPool.Close()
+			conAdminData := ConnectionDataAdmin{queries: queries, pool: adminPool}
+			http.HandleFunc("POST /api/admin", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionAdminQuery, conAdminData.adminQuery)))
+			http.HandleFunc("GET /api/admin/saved-queries", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionAdminQuery, conAdminData.getSavedQueries)))
+			http.HandleFunc("POST /api/admin/saved-queries", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionAdminQuery, conAdminData.createSavedQuery)))
+			http.HandleFunc("PATCH /api/admin/saved-queries", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionAdminQuery, conAdminData.updateSavedQuery)))
+			http.HandleFunc("DELETE /api/admin/saved-queries", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionAdminQuery, conAdminData.deleteSavedQuery)))
+			http.HandleFunc("POST /api/admin/saved-queries/run", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionAdminQuery, conAdminData.runSavedQuery)))
+		}
+	}
+	http.HandleFunc("GET /api/admin/query-log", connData.requireScope(ScopeAdmin, connData.RequirePermission(PermissionAuditRead, connData.getAdminQueryLog)))
 
 	// Start session cleanup scheduler
 	go StartSessionCleanupScheduler(context.Background(), queries)
 
-	fmt.Println("Server starting on :8080")
-	http.ListenAndServe(":8080", nil)
+	var handler http.Handler = http.DefaultServeMux
+	if cfg.CSRF.Enabled {
+		handler = csrfMiddleware(csrfTrustedOrigins(cfg), handler)
+	}
+	handler = connData.rateLimitHandler(RateLimitGlobal, handler)
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		handler = corsMiddleware(cfg.CORS.AllowedOrigins, handler)
+	}
+	trustedProxies, err := ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v\n", err)
+	}
+	handler = clientIPMiddleware(trustedProxies, cfg.ForwardedHeader, handler)
+	handler = stripIdentityHeaders(handler)
+
+	fmt.Printf("Server starting on %s\n", cfg.ListenAddr)
+	if err := http.ListenAndServe(cfg.ListenAddr, handler); err != nil {
+		log.Fatalf("Server stopped: %v\n", err)
+	}
 }
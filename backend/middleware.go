@@ -2,8 +2,12 @@ package main
 
 import (
 	// "context"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -13,12 +17,30 @@ type Claims struct {
 	Email     string `json:"email"`
 	ID        string `json:"id"`
 	SessionID string `json:"session_id"`
+	// The admin behind an impersonation session, ID and Email are the impersonated user
+	ImpersonatorID    string `json:"impersonator_id,omitempty"`
+	ImpersonatorEmail string `json:"impersonator_email,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// Auth middleware with database connection
+// Auth middleware with database connection, only accepts the session cookie
 func (connData ConnectionData) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return connData.authenticate("", next)
+}
+
+// requireScope is authMiddleware that also accepts personal access tokens granted the scope
+func (connData ConnectionData) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return connData.authenticate(scope, next)
+}
+
+// authenticate checks the session cookie, or an `Authorization: Bearer` token when scope is set
+func (connData ConnectionData) authenticate(scope string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			connData.authenticateToken(w, r, scope, authorization, next)
+			return
+		}
+
 		cookie, err := r.Cookie("token")
 		if err != nil {
 			http.Error(w, "Unauthorized - no token", http.StatusUnauthorized)
@@ -26,9 +48,7 @@ func (connData ConnectionData) authMiddleware(next http.HandlerFunc) http.Handle
 		}
 
 		// First validate JWT
-		token, err := jwt.ParseWithClaims(cookie.Value, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return secret, nil
-		})
+		token, err := jwt.ParseWithClaims(cookie.Value, &Claims{}, keyring.Keyfunc)
 		if err != nil || !token.Valid {
 			http.Error(w, "Unauthorized - invalid token", http.StatusUnauthorized)
 			return
@@ -40,19 +60,135 @@ func (connData ConnectionData) authMiddleware(next http.HandlerFunc) http.Handle
 			return
 		}
 
-		// Validate session in database
+		userID, err := strconv.Atoi(claims.ID)
+		if err != nil {
+			http.Error(w, "Unauthorized - invalid claims", http.StatusUnauthorized)
+			return
+		}
+
+		// Validate session in database, it has to belong to the user the token names
 		ctx := r.Context()
 		session, err := ValidateAndUpdateSession(ctx, connData.queries, cookie.Value)
-		if err != nil {
+		if err != nil || session.UserID != int32(userID) || session.ImpersonatorID.Valid != (claims.ImpersonatorID != "") {
 			http.Error(w, "Unauthorized - invalid session", http.StatusUnauthorized)
 			return
 		}
+		if !connData.checkAccountEnabled(w, r, int32(userID)) {
+			return
+		}
 
 		// Set headers for downstream handlers
 		r.Header.Set("email", claims.Email)
 		r.Header.Set("id", claims.ID)
 		r.Header.Set("session_id", strconv.Itoa(int(session.ID)))
+		r.Header.Del("token_id")
+
+		// Every request of an impersonation session ends up in impersonation_log
+		if session.ImpersonatorID.Valid {
+			r.Header.Set("impersonator_id", claims.ImpersonatorID)
+			r.Header.Set("impersonator_email", claims.ImpersonatorEmail)
+			connData.serveImpersonated(w, r, next)
+			return
+		}
+		r.Header.Del("impersonator_id")
+		r.Header.Del("impersonator_email")
 
 		next(w, r)
 	}
 }
+
+// authenticateToken validates a personal access token and its scope
+func (connData ConnectionData) authenticateToken(w http.ResponseWriter, r *http.Request, scope, authorization string, next http.HandlerFunc) {
+	bearer, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		http.Error(w, "Unauthorized - invalid authorization header", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, err := ValidatePersonalAccessToken(r.Context(), connData.queries, strings.TrimSpace(bearer))
+	if err != nil {
+		http.Error(w, "Unauthorized - invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	// Account management and other sensitive routes need a real session
+	if scope == "" {
+		http.Error(w, "Personal access tokens can't be used for this endpoint", http.StatusForbidden)
+		return
+	}
+	if !slices.Contains(accessToken.Scopes, scope) {
+		http.Error(w, "Token is missing the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+
+	user, err := connData.queries.FindUserById(r.Context(), accessToken.UserID)
+	if err != nil {
+		http.Error(w, "Unauthorized - invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	if !connData.checkAccountEnabled(w, r, user.ID) {
+		return
+	}
+
+	// Set headers for downstream handlers, there is no session
+	r.Header.Set("email", user.Email)
+	r.Header.Set("id", strconv.Itoa(int(user.ID)))
+	r.Header.Set("token_id", strconv.Itoa(int(accessToken.ID)))
+	r.Header.Del("session_id")
+	r.Header.Del("impersonator_id")
+	r.Header.Del("impersonator_email")
+
+	next(w, r)
+}
+
+// checkAccountEnabled answers the request and returns false when the user was disabled since signing in
+func (connData ConnectionData) checkAccountEnabled(w http.ResponseWriter, r *http.Request, userID int32) bool {
+	err := CheckUserEnabled(r.Context(), connData.queries, userID)
+	if errors.Is(err, errAccountDisabled) {
+		http.Error(w, "Unauthorized - account disabled", http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Failed to check whether user %d is disabled: %v\n", userID, err)
+		http.Error(w, "Unauthorized - invalid session", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// corsMiddleware lets the allowed origins call the API with credentials
+func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !slices.Contains(allowedOrigins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Add("Vary", "Origin")
+
+		// Answer preflight requests here, the mux only knows the real methods
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Share-Password")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stripIdentityHeaders drops identity headers sent by the client, only authMiddleware may set them
+func stripIdentityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range []string{"id", "email", "session_id", "token_id", "impersonator_id", "impersonator_email"} {
+			r.Header.Del(header)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie   = "oidc_state"
+	oidcStateAudience = "oidc_state"
+	oidcStateTTL      = 10 * time.Minute
+)
+
+var oidcProviderNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// OIDCProviderConfig is one entry of OIDC_PROVIDERS
+type OIDCProviderConfig struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	LinkByEmail  bool // link the first login to an existing user with the same email
+}
+
+// OIDCProvider lazily runs discovery so a provider being down doesn't stop the server from starting
+type OIDCProvider struct {
+	config OIDCProviderConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+// LoadOIDCProvidersFromEnv reads OIDC_PROVIDERS=keycloak,google and OIDC_<NAME>_* for each provider
+func LoadOIDCProvidersFromEnv() (map[string]*OIDCProvider, error) {
+	providers := map[string]*OIDCProvider{}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !oidcProviderNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid OIDC provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config := OIDCProviderConfig{
+			Name:        name,
+			DisplayName: os.Getenv(prefix + "DISPLAY_NAME"),
+			Issuer:      os.Getenv(prefix + "ISSUER"),
+			ClientID:    os.Getenv(prefix + "CLIENT_ID"),
+			Scopes:      strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		clientSecret, err := GetenvSecret(prefix + "CLIENT_SECRET")
+		if err != nil {
+			return nil, err
+		}
+		config.ClientSecret = clientSecret
+		if value := os.Getenv(prefix + "LINK_BY_EMAIL"); value != "" {
+			if config.LinkByEmail, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("%sLINK_BY_EMAIL: %w", prefix, err)
+			}
+		}
+
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+		if config.DisplayName == "" {
+			config.DisplayName = name
+		}
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+		}
+
+		providers[name] = &OIDCProvider{config: config}
+	}
+	return providers, nil
+}
+
+// discover fetches the provider metadata once
+func (p *OIDCProvider) discover(ctx context.Context) (*oidc.Provider, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.provider = provider
+		p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	}
+	return p.provider, p.verifier, nil
+}
+
+// oauth2Config builds the client config, the callback lives under APP_BASE_URL
+func (p *OIDCProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  appBaseURL() + "/api/oidc/" + p.config.Name + "/callback",
+		Scopes:       p.config.Scopes,
+	}
+}
+
+// OIDCStateClaims carries the state, nonce and PKCE verifier between login and callback
+type OIDCStateClaims struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+func randomOIDCValue() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// parseOIDCState validates the signed state cookie
+func parseOIDCState(tokenString string) (*OIDCStateClaims, error) {
+	claims := &OIDCStateClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyring.Keyfunc, jwt.WithAudience(oidcStateAudience))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// OIDCIDTokenClaims are the ID token claims we use
+type OIDCIDTokenClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// oidcUsername derives a valid username from the claims, leaving room for a suffix on conflicts
+func oidcUsername(claims OIDCIDTokenClaims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, c := range candidate {
+		if c < 128 && usernamePattern.MatchString(string(c)) {
+			b.WriteRune(c)
+		}
+	}
+	username := b.String()
+	if len(username) > maxUsernameLength-6 {
+		username = username[:maxUsernameLength-6]
+	}
+	for len(username) < minUsernameLength {
+		username += "_"
+	}
+	return username
+}
+
+// provisionOIDCUser creates a local account for a first OIDC login.
+// The password is random so the account can only sign in through the provider until it's reset.
+func (conn ConnectionData) provisionOIDCUser(ctx context.Context, claims OIDCIDTokenClaims) (int32, error) {
+	randomPassword, err := GenerateSessionToken()
+	if err != nil {
+		return 0, err
+	}
+	hash, err := HashPassword(randomPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	base := oidcUsername(claims)
+	username := base
+	for attempt := 0; ; attempt++ {
+		user, err := conn.queries.CreateUser(ctx, db.CreateUserParams{
+			Username:     username,
+			Email:        claims.Email,
+			PasswordHash: hash,
+		})
+		if err == nil {
+			if claims.EmailVerified {
+				if err := conn.queries.MarkUserEmailVerified(ctx, db.MarkUserEmailVerifiedParams{ID: user.ID, Email: user.Email}); err != nil {
+					fmt.Printf("Failed to mark email verified for user %d: %v\n", user.ID, err)
+				}
+			}
+			fmt.Printf("Provisioned user %s with ID %d from OIDC\n", user.Email, user.ID)
+			return user.ID, nil
+		}
+
+		// Only retry on a taken username
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.ConstraintName != "users_username_key" || attempt >= 5 {
+			return 0, err
+		}
+		suffix, err := randomOIDCValue()
+		if err != nil {
+			return 0, err
+		}
+		username = base + "_" + suffix[:5]
+	}
+}
+
+// errOIDCAccountExists is returned for a first login with the email of a user it can't be linked to
+var errOIDCAccountExists = errors.New("an account with this email already exists")
+
+// resolveOIDCUser finds the local user for an identity, linking or provisioning on first login
+func (conn ConnectionData) resolveOIDCUser(ctx context.Context, provider OIDCProviderConfig, issuer, subject string, claims OIDCIDTokenClaims) (int32, error) {
+	email := pgtype.Text{String: claims.Email, Valid: claims.Email != ""}
+
+	identity, err := conn.queries.FindUserIdentity(ctx, db.FindUserIdentityParams{Issuer: issuer, Subject: subject})
+	if err == nil {
+		if err := conn.queries.UpdateUserIdentityLogin(ctx, db.UpdateUserIdentityLoginParams{ID: identity.ID, Email: email}); err != nil {
+			fmt.Printf("Failed to update identity %d: %v\n", identity.ID, err)
+		}
+		return identity.UserID, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	// An unverified email could belong to anyone, never link or provision with it
+	if claims.Email == "" || !claims.EmailVerified {
+		return 0, errors.New("the provider did not return a verified email")
+	}
+
+	var userID int32
+	existing, err := conn.queries.FindUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		// Whoever controls the email at the provider would get the account, only providers
+		// trusted for that may link, and never to users with roles such as admins
+		if !provider.LinkByEmail {
+			return 0, errOIDCAccountExists
+		}
+		userRoles, err := conn.queries.ListUserRoles(ctx, existing.ID)
+		if err != nil {
+			return 0, err
+		}
+		if len(userRoles) > 0 {
+			return 0, errOIDCAccountExists
+		}
+		userID = existing.ID
+	case errors.Is(err, pgx.ErrNoRows):
+		if !appConfig.Features.Signup {
+			return 0, errors.New("sign up is disabled")
+		}
+		userID, err = conn.provisionOIDCUser(ctx, claims)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, err
+	}
+
+	_, err = conn.queries.CreateUserIdentity(ctx, db.CreateUserIdentityParams{
+		UserID:   userID,
+		Provider: provider.Name,
+		Issuer:   issuer,
+		Subject:  subject,
+		Email:    email,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// List the configured providers for the sign in page
+func (conn ConnectionData) getOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	type providerDTO struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		LoginURL    string `json:"login_url"`
+	}
+
+	providers := make([]providerDTO, 0, len(conn.oidcProviders))
+	for name, p := range conn.oidcProviders {
+		providers = append(providers, providerDTO{
+			Name:        name,
+			DisplayName: p.config.DisplayName,
+			LoginURL:    "/api/oidc/" + name + "/login",
+		})
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers)
+}
+
+// Redirect the browser to the provider's authorization endpoint
+func (conn ConnectionData) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	p, ok := conn.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	provider, _, err := p.discover(r.Context())
+	if err != nil {
+		fmt.Printf("OIDC discovery failed for %s: %v\n", p.config.Name, err)
+		http.Error(w, "Provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, err := randomOIDCValue()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomOIDCValue()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	cookieValue, err := keyring.Sign(OIDCStateClaims{
+		Provider: p.config.Name,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{oidcStateAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcStateTTL)),
+		},
+	})
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookieValue,
+		Expires:  time.Now().Add(oidcStateTTL),
+		HttpOnly: true,
+		Path:     "/api/oidc",
+		Secure:   appConfig.Cookie.Secure,
+		SameSite: http.SameSiteLaxMode, // sent on the top level redirect back from the provider
+	})
+
+	authURL := p.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Handle the provider's redirect, signs the user in with the same session cookie as signIn
+func (conn ConnectionData) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	p, ok := conn.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "Sign in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/api/oidc",
+	})
+
+	state, err := parseOIDCState(cookie.Value)
+	if err != nil || state.Provider != p.config.Name || state.State != r.URL.Query().Get("state") {
+		http.Error(w, "Sign in expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		fmt.Printf("OIDC provider %s returned error: %s %s\n", p.config.Name, errParam, r.URL.Query().Get("error_description"))
+		http.Error(w, "Sign in was cancelled or denied", http.StatusUnauthorized)
+		return
+	}
+
+	provider, verifier, err := p.discover(r.Context())
+	if err != nil {
+		fmt.Printf("OIDC discovery failed for %s: %v\n", p.config.Name, err)
+		http.Error(w, "Provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	token, err := p.oauth2Config(provider).Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		fmt.Printf("OIDC code exchange failed for %s: %v\n", p.config.Name, err)
+		http.Error(w, "Sign in failed", http.StatusUnauthorized)
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Sign in failed", http.StatusUnauthorized)
+		return
+	}
+
+	idToken, err := verifier.Verify(r.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != state.Nonce {
+		fmt.Printf("OIDC ID token rejected for %s: %v\n", p.config.Name, err)
+		http.Error(w, "Sign in failed", http.StatusUnauthorized)
+		return
+	}
+
+	var claims OIDCIDTokenClaims
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, "Sign in failed", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := conn.resolveOIDCUser(r.Context(), p.config, idToken.Issuer, idToken.Subject, claims)
+	if errors.Is(err, errOIDCAccountExists) {
+		http.Error(w, "An account with this email already exists, sign in with your password", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Printf("OIDC login for %s/%s failed: %v\n", p.config.Name, idToken.Subject, err)
+		http.Error(w, "Sign in failed, no account could be linked", http.StatusForbidden)
+		return
+	}
+
+	user, err := conn.queries.FindUserById(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	auth := SessionAuth{Method: "oidc", Provider: p.config.Name}
+
+	// Local 2FA still applies, the sign in page finishes it with /api/signin/2fa
+	if totp, err := conn.queries.GetTOTPByUser(r.Context(), user.ID); err == nil && totp.EnabledAt.Valid {
+		mfaToken, err := NewMFAPendingToken(user.ID, user.Email, auth)
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, appBaseURL()+"/signin#mfa_token="+mfaToken, http.StatusFound)
+		return
+	}
+
+	session, err := IssueSessionCookie(w, r, conn.queries, user.ID, user.Email, auth)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	fmt.Printf("Signed in as %s with session %d using %s\n", user.Email, session.ID, p.config.Name)
+
+	http.Redirect(w, r, appBaseURL()+"/", http.StatusFound)
+}
+
+// IdentityDTO represents a linked provider account for JSON response
+type IdentityDTO struct {
+	ID          int32  `json:"id"`
+	Provider    string `json:"provider"`
+	Email       string `json:"email"`
+	CreatedAt   string `json:"created_at"`
+	LastLoginAt string `json:"last_login_at"`
+}
+
+// Get the provider accounts linked to the current user
+func (conn ConnectionData) getIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	identities, err := conn.queries.ListUserIdentitiesByUser(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to get linked accounts", http.StatusInternalServerError)
+		return
+	}
+
+	identityDTOs := make([]IdentityDTO, len(identities))
+	for i, identity := range identities {
+		identityDTOs[i] = IdentityDTO{
+			ID:        identity.ID,
+			Provider:  identity.Provider,
+			Email:     identity.Email.String,
+			CreatedAt: identity.CreatedAt.Time.Format(time.RFC3339),
+		}
+		if identity.LastLoginAt.Valid {
+			identityDTOs[i].LastLoginAt = identity.LastLoginAt.Time.Format(time.RFC3339)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identityDTOs)
+}
+
+// Unlink a provider account from the current user
+func (conn ConnectionData) deleteIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	identityIDStr := r.URL.Query().Get("identity_id")
+	if identityIDStr == "" {
+		http.Error(w, "Missing identity_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	identityID, err := strconv.Atoi(identityIDStr)
+	if err != nil {
+		http.Error(w, "Invalid identity ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := conn.queries.DeleteUserIdentity(r.Context(), db.DeleteUserIdentityParams{
+		ID:     int32(identityID),
+		UserID: int32(userID),
+	})
+	if err != nil {
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Linked account not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Account unlinked successfully"})
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	passkeyCeremonyCookie = "passkey_ceremony"
+	passkeyCeremonyTTL    = 5 * time.Minute
+	maxPasskeyNameLength  = 100 // webauthn_credentials.name is VARCHAR(100)
+)
+
+// NewWebAuthnFromEnv configures the relying party from WEBAUTHN_RP_ID and WEBAUTHN_RP_ORIGINS,
+// passkeys are disabled when WEBAUTHN_RP_ID is not set
+func NewWebAuthnFromEnv() (*webauthn.WebAuthn, error) {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		return nil, nil
+	}
+
+	origins := []string{appBaseURL()}
+	if env := os.Getenv("WEBAUTHN_RP_ORIGINS"); env != "" {
+		origins = nil
+		for _, origin := range strings.Split(env, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Steamed Notes",
+		RPOrigins:     origins,
+	})
+}
+
+// webAuthnUser adapts a user and their stored credentials to webauthn.User
+type webAuthnUser struct {
+	id          int32
+	username    string
+	email       string
+	credentials []db.WebauthnCredential
+}
+
+// webAuthnUserHandle is the opaque user handle stored on the authenticator
+func webAuthnUserHandle(userID int32) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+func (u webAuthnUser) WebAuthnID() []byte {
+	return webAuthnUserHandle(u.id)
+}
+
+func (u webAuthnUser) WebAuthnName() string {
+	return u.email
+}
+
+func (u webAuthnUser) WebAuthnDisplayName() string {
+	return u.username
+}
+
+func (u webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for j, t := range c.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+		credentials[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:       c.Aaguid,
+				SignCount:    uint32(c.SignCount),
+				CloneWarning: c.CloneWarning,
+			},
+		}
+	}
+	return credentials
+}
+
+// loadWebAuthnUser fetches the user together with their passkeys
+func (conn ConnectionData) loadWebAuthnUser(r *http.Request, userID int32) (webAuthnUser, error) {
+	user, err := conn.queries.FindUserById(r.Context(), userID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	credentials, err := conn.queries.ListWebAuthnCredentialsByUser(r.Context(), userID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	return webAuthnUser{id: user.ID, username: user.Username, email: user.Email, credentials: credentials}, nil
+}
+
+// startPasskeyCeremony stores the challenge server side and hands the browser a cookie pointing to it
+func (conn ConnectionData) startPasskeyCeremony(w http.ResponseWriter, r *http.Request, kind string, userID pgtype.Int4, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return err
+	}
+
+	err = conn.queries.CreateWebAuthnCeremony(r.Context(), db.CreateWebAuthnCeremonyParams{
+		ID:          HashToken(token),
+		UserID:      userID,
+		Kind:        kind,
+		SessionData: data,
+		ExpiresAt:   pgtype.Timestamp{Time: time.Now().Add(passkeyCeremonyTTL), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeyCeremonyCookie,
+		Value:    token,
+		Expires:  time.Now().Add(passkeyCeremonyTTL),
+		HttpOnly: true,
+		Path:     "/api/passkeys",
+		Secure:   appConfig.Cookie.Secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// finishPasskeyCeremony consumes the ceremony referenced by the cookie, each challenge can only be answered once
+func (conn ConnectionData) finishPasskeyCeremony(w http.ResponseWriter, r *http.Request, kind string) (pgtype.Int4, webauthn.SessionData, error) {
+	var session webauthn.SessionData
+
+	cookie, err := r.Cookie(passkeyCeremonyCookie)
+	if err != nil {
+		return pgtype.Int4{}, session, err
+	}
+
+	// Clear the cookie whatever the outcome
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeyCeremonyCookie,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/api/passkeys",
+	})
+
+	ceremony, err := conn.queries.ConsumeWebAuthnCeremony(r.Context(), db.ConsumeWebAuthnCeremonyParams{
+		ID:   HashToken(cookie.Value),
+		Kind: kind,
+	})
+	if err != nil {
+		return pgtype.Int4{}, session, err
+	}
+
+	if err := json.Unmarshal(ceremony.SessionData, &session); err != nil {
+		return pgtype.Int4{}, session, err
+	}
+	return ceremony.UserID, session, nil
+}
+
+// requireWebAuthn answers 503 when passkeys are not configured
+func (connData ConnectionData) requireWebAuthn(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if connData.webAuthn == nil {
+			http.Error(w, "Passkeys are not enabled", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Start registering a new passkey for the signed-in user
+func (conn ConnectionData) beginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := conn.loadWebAuthnUser(r, int32(userID))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Don't let the same authenticator register twice
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, c := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	options, session, err := conn.webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		fmt.Printf("Failed to begin passkey registration: %v\n", err)
+		http.Error(w, "Failed to begin registration", http.StatusInternalServerError)
+		return
+	}
+
+	if err := conn.startPasskeyCeremony(w, r, "registration", pgtype.Int4{Int32: user.id, Valid: true}, session); err != nil {
+		fmt.Printf("Failed to store passkey ceremony: %v\n", err)
+		http.Error(w, "Failed to begin registration", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+// Finish registering a passkey, the body is the browser's attestation response and ?name= labels the passkey
+func (conn ConnectionData) finishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = ParseUserAgent(r.Header.Get("User-Agent")).Name
+	}
+	if len(name) > maxPasskeyNameLength {
+		http.Error(w, "Passkey name is too long", http.StatusBadRequest)
+		return
+	}
+
+	ceremonyUserID, session, err := conn.finishPasskeyCeremony(w, r, "registration")
+	if err != nil || ceremonyUserID.Int32 != int32(userID) {
+		http.Error(w, "Registration expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	user, err := conn.loadWebAuthnUser(r, int32(userID))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	credential, err := conn.webAuthn.FinishRegistration(user, session, r)
+	if err != nil {
+		fmt.Printf("Passkey registration failed for user %d: %v\n", user.id, err)
+		http.Error(w, "Passkey registration failed", http.StatusBadRequest)
+		return
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+
+	err = conn.queries.CreateWebAuthnCredential(r.Context(), db.CreateWebAuthnCredentialParams{
+		UserID:          user.id,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Aaguid:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            name,
+	})
+	if err != nil {
+		fmt.Printf("Failed to store passkey for user %d: %v\n", user.id, err)
+		http.Error(w, "Failed to store passkey", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Passkey registered successfully"})
+}
+
+// Start a passwordless sign in, the browser lets the user pick any passkey for this site
+func (conn ConnectionData) beginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	options, session, err := conn.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationPreferred),
+	)
+	if err != nil {
+		fmt.Printf("Failed to begin passkey login: %v\n", err)
+		http.Error(w, "Failed to begin sign in", http.StatusInternalServerError)
+		return
+	}
+
+	if err := conn.startPasskeyCeremony(w, r, "login", pgtype.Int4{}, session); err != nil {
+		fmt.Printf("Failed to store passkey ceremony: %v\n", err)
+		http.Error(w, "Failed to begin sign in", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+// Finish a passwordless sign in, issues the same session cookie as signIn
+func (conn ConnectionData) finishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	_, session, err := conn.finishPasskeyCeremony(w, r, "login")
+	if err != nil {
+		http.Error(w, "Sign in expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	var user webAuthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, errors.New("invalid user handle")
+		}
+		loaded, err := conn.loadWebAuthnUser(r, int32(binary.BigEndian.Uint64(userHandle)))
+		if err != nil {
+			return nil, err
+		}
+		user = loaded
+		return user, nil
+	}
+
+	credential, err := conn.webAuthn.FinishDiscoverableLogin(handler, session, r)
+	if err != nil {
+		fmt.Printf("Passkey login failed: %v\n", err)
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	stored, err := conn.queries.FindWebAuthnCredentialByCredentialID(r.Context(), credential.ID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && stored.UserID != user.id) {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	// Record the new counter first so a cloned authenticator stays flagged
+	err = conn.queries.UpdateWebAuthnCredentialUsage(r.Context(), db.UpdateWebAuthnCredentialUsageParams{
+		ID:           stored.ID,
+		SignCount:    int64(credential.Authenticator.SignCount),
+		CloneWarning: credential.Authenticator.CloneWarning || stored.CloneWarning,
+		BackupState:  credential.Flags.BackupState,
+	})
+	if err != nil {
+		fmt.Printf("Failed to update passkey %d: %v\n", stored.ID, err)
+	}
+
+	if credential.Authenticator.CloneWarning || stored.CloneWarning {
+		fmt.Printf("Rejected passkey %d of user %d, the authenticator may be cloned\n", stored.ID, user.id)
+		http.Error(w, "This passkey can no longer be used, please sign in with your password", http.StatusUnauthorized)
+		return
+	}
+
+	// Without user verification the passkey is a single factor, TOTP still applies
+	if !credential.Flags.UserVerified {
+		if totp, err := conn.queries.GetTOTPByUser(r.Context(), user.id); err == nil && totp.EnabledAt.Valid {
+			mfaToken, err := NewMFAPendingToken(user.id, user.email, SessionAuth{Method: "passkey"})
+			if err != nil {
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"mfa_required": true,
+				"mfa_token":    mfaToken,
+			})
+			return
+		}
+	}
+
+	sessionRow, err := IssueSessionCookie(w, r, conn.queries, user.id, user.email, SessionAuth{
+		Method:  "passkey",
+		MFAUsed: credential.Flags.UserVerified,
+	})
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	fmt.Printf("Signed in as %s with session %d using a passkey\n", user.email, sessionRow.ID)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"username":   user.username,
+		"session_id": strconv.Itoa(int(sessionRow.ID)),
+	})
+}
+
+// PasskeyDTO represents a passkey for JSON response
+type PasskeyDTO struct {
+	ID             int32    `json:"id"`
+	Name           string   `json:"name"`
+	Transports     []string `json:"transports"`
+	BackupEligible bool     `json:"backup_eligible"`
+	BackupState    bool     `json:"backup_state"`
+	CloneWarning   bool     `json:"clone_warning"`
+	CreatedAt      string   `json:"created_at"`
+	LastUsedAt     string   `json:"last_used_at"`
+}
+
+// Get all passkeys of the current user
+func (conn ConnectionData) getPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := conn.queries.ListWebAuthnCredentialsByUser(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to get passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	passkeys := make([]PasskeyDTO, len(credentials))
+	for i, c := range credentials {
+		passkeys[i] = PasskeyDTO{
+			ID:             c.ID,
+			Name:           c.Name,
+			Transports:     c.Transports,
+			BackupEligible: c.BackupEligible,
+			BackupState:    c.BackupState,
+			CloneWarning:   c.CloneWarning,
+			CreatedAt:      c.CreatedAt.Time.Format(time.RFC3339),
+		}
+		if c.LastUsedAt.Valid {
+			passkeys[i].LastUsedAt = c.LastUsedAt.Time.Format(time.RFC3339)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(passkeys)
+}
+
+type RenamePasskeyRequest struct {
+	PasskeyID int32  `json:"passkey_id"`
+	Name      string `json:"name"`
+}
+
+// Rename one of the current user's passkeys
+func (conn ConnectionData) renamePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req RenamePasskeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxPasskeyNameLength {
+		http.Error(w, fmt.Sprintf("Name must be between 1 and %d characters", maxPasskeyNameLength), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := conn.queries.RenameWebAuthnCredential(r.Context(), db.RenameWebAuthnCredentialParams{
+		ID:     req.PasskeyID,
+		UserID: int32(userID),
+		Name:   req.Name,
+	})
+	if err != nil {
+		http.Error(w, "Failed to rename passkey", http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Passkey renamed successfully"})
+}
+
+// Revoke one of the current user's passkeys
+func (conn ConnectionData) deletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	passkeyIDStr := r.URL.Query().Get("passkey_id")
+	if passkeyIDStr == "" {
+		http.Error(w, "Missing passkey_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	passkeyID, err := strconv.Atoi(passkeyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := conn.queries.DeleteWebAuthnCredential(r.Context(), db.DeleteWebAuthnCredentialParams{
+		ID:     int32(passkeyID),
+		UserID: int32(userID),
+	})
+	if err != nil {
+		http.Error(w, "Failed to delete passkey", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Passkey deleted successfully"})
+}
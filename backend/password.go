@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// PasswordParams holds the argon2id cost settings used when hashing passwords
+type PasswordParams struct {
+	Memory  uint32 // in KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultPasswordParams follows the OWASP recommendation for argon2id
+var DefaultPasswordParams = PasswordParams{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 2,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// passwordParams are the params used for new hashes, can be tuned from env
+var passwordParams = DefaultPasswordParams
+
+const argon2idPrefix = "$argon2id$"
+
+var errInvalidHash = errors.New("invalid argon2id hash format")
+
+// LoadPasswordParamsFromEnv overrides the default cost from ARGON2_* env variables
+func LoadPasswordParamsFromEnv() PasswordParams {
+	params := DefaultPasswordParams
+
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY_KIB"), 10, 32); err == nil && v > 0 {
+		params.Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_TIME"), 10, 32); err == nil && v > 0 {
+		params.Time = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_THREADS"), 10, 8); err == nil && v > 0 {
+		params.Threads = uint8(v)
+	}
+
+	return params
+}
+
+// HashPassword hashes a password with argon2id and returns it in PHC string format
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordParams.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, passwordParams.Time, passwordParams.Memory, passwordParams.Threads, passwordParams.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		passwordParams.Memory, passwordParams.Time, passwordParams.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword checks a password against the stored value in constant time.
+// needsRehash is true when the stored value is legacy plaintext or was hashed
+// with weaker params than the current ones, so callers can upgrade it in place.
+func VerifyPassword(stored, password string) (match bool, needsRehash bool) {
+	if !IsPasswordHashed(stored) {
+		// Legacy rows hold the plaintext password
+		match = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return match, match
+	}
+
+	params, salt, key, err := decodePasswordHash(stored)
+	if err != nil {
+		fmt.Printf("Failed to decode password hash: %v\n", err)
+		return false, false
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false
+	}
+
+	needsRehash = params.Memory < passwordParams.Memory ||
+		params.Time < passwordParams.Time ||
+		params.Threads < passwordParams.Threads ||
+		params.KeyLen < passwordParams.KeyLen
+
+	return true, needsRehash
+}
+
+// IsPasswordHashed reports whether the stored value is an argon2id hash
+func IsPasswordHashed(stored string) bool {
+	return strings.HasPrefix(stored, argon2idPrefix)
+}
+
+func decodePasswordHash(encoded string) (PasswordParams, []byte, []byte, error) {
+	// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return PasswordParams{}, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return PasswordParams{}, nil, nil, err
+	}
+	if version != argon2.Version {
+		return PasswordParams{}, nil, nil, fmt.Errorf("incompatible argon2 version %d", version)
+	}
+
+	var params PasswordParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return PasswordParams{}, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return PasswordParams{}, nil, nil, err
+	}
+	params.SaltLen = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return PasswordParams{}, nil, nil, err
+	}
+	params.KeyLen = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// VerifyDummyPassword costs as much as checking a real password,
+// so unknown emails can't be told apart by response time
+func VerifyDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		hash, err := HashPassword("dummy password for unknown accounts")
+		if err != nil {
+			fmt.Printf("Failed to create dummy password hash: %v\n", err)
+			return
+		}
+		dummyPasswordHash = hash
+	})
+	if dummyPasswordHash != "" {
+		VerifyPassword(dummyPasswordHash, password)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"steamednotes/db"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const passwordResetTTL = time.Hour
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// sendPasswordResetEmail replaces any pending reset token for the email's user and emails a new link
+func (conn ConnectionData) sendPasswordResetEmail(ctx context.Context, email string) error {
+	user, err := conn.queries.FindUserByEmail(ctx, email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Nothing to do, the caller never learns whether the account exists
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := conn.queries.DeleteUnusedPasswordResets(ctx, user.ID); err != nil {
+		return err
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return err
+	}
+
+	err = conn.queries.CreatePasswordReset(ctx, db.CreatePasswordResetParams{
+		UserID:    user.ID,
+		TokenHash: HashToken(token),
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(passwordResetTTL), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL() + "/reset-password?token=" + url.QueryEscape(token)
+
+	return conn.mailer.Send(ctx, Message{
+		To:      email,
+		Subject: "Reset your Steamed Notes password",
+		Body: "Someone asked to reset the password of your Steamed Notes account.\n\n" +
+			"Open the link below to choose a new password:\n\n" +
+			link + "\n\n" +
+			"The link expires in 1 hour. If it wasn't you, you can ignore this email.\n",
+	})
+}
+
+// Request a password reset email, always answers 200 so accounts can't be enumerated
+func (conn ConnectionData) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email != "" {
+		// Done in the background so the response time doesn't reveal if the account exists
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := conn.sendPasswordResetEmail(ctx, email); err != nil {
+				fmt.Printf("Failed to send password reset email: %v\n", err)
+			}
+		}()
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for this email, a reset link has been sent",
+	})
+}
+
+// Set a new password with the emailed token, signs the user out everywhere
+func (conn ConnectionData) confirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" || req.NewPassword == "" {
+		http.Error(w, "Token and new password are required", http.StatusBadRequest)
+		return
+	}
+
+	reset, err := conn.queries.FindPasswordResetByTokenHash(r.Context(), HashToken(req.Token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := conn.queries.FindUserById(r.Context(), reset.UserID)
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Validate before consuming the token so the user can retry with a better password
+	if err := ValidatePassword(req.NewPassword, user.Username, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	// Single use: only one concurrent request can consume the token
+	if _, err := conn.queries.ConsumePasswordReset(r.Context(), reset.ID); err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	err = conn.queries.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
+		ID:           user.ID,
+		PasswordHash: hash,
+	})
+	if err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	// Unlike changePassword, a reset signs out every session since the account may be compromised
+	if err := DeleteAllSessions(r.Context(), conn.queries, user.ID); err != nil {
+		fmt.Printf("Failed to deactivate sessions after password reset for user %d: %v\n", user.ID, err)
+	}
+	conn.hub.RevokeSessions(user.ID, allSessions)
+	if err := conn.queries.DeleteUnusedPasswordResets(r.Context(), user.ID); err != nil {
+		fmt.Printf("Failed to delete pending password resets for user %d: %v\n", user.ID, err)
+	}
+	if err := conn.queries.DeleteAllPersonalAccessTokensForUser(r.Context(), user.ID); err != nil {
+		fmt.Printf("Failed to revoke personal access tokens after password reset for user %d: %v\n", user.ID, err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset successfully"})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// phcHash builds a stored argon2id hash with the given params
+func phcHash(password string, params PasswordParams) string {
+	salt := []byte("0123456789abcdef")[:params.SaltLen]
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestVerifyPassword(t *testing.T) {
+	// Cheap params keep the test fast, what matters is how they compare
+	current := PasswordParams{Memory: 64, Time: 2, Threads: 1, SaltLen: 16, KeyLen: 32}
+	defer func(params PasswordParams) { passwordParams = params }(passwordParams)
+	passwordParams = current
+
+	hashed, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weaker := current
+	weaker.Time = 1
+
+	tests := []struct {
+		name        string
+		stored      string
+		password    string
+		match       bool
+		needsRehash bool
+	}{
+		{name: "current hash", stored: hashed, password: "correct horse", match: true},
+		{name: "wrong password", stored: hashed, password: "correct horsE"},
+		{name: "weaker params", stored: phcHash("correct horse", weaker), password: "correct horse", match: true, needsRehash: true},
+		{name: "stronger params", stored: phcHash("correct horse", PasswordParams{Memory: 128, Time: 3, Threads: 2, SaltLen: 8, KeyLen: 32}), password: "correct horse", match: true},
+		{name: "shorter key", stored: phcHash("correct horse", PasswordParams{Memory: 64, Time: 2, Threads: 1, SaltLen: 16, KeyLen: 16}), password: "correct horse", match: true, needsRehash: true},
+		{name: "legacy plaintext", stored: "correct horse", password: "correct horse", match: true, needsRehash: true},
+		{name: "legacy plaintext wrong password", stored: "correct horse", password: "correct"},
+		{name: "hash is not a password", stored: hashed, password: hashed},
+		{name: "missing part", stored: "$argon2id$v=19$m=64,t=2,p=1$c2FsdA", password: "correct horse"},
+		{name: "other version", stored: "$argon2id$v=16$m=64,t=2,p=1$c2FsdHNhbHQ$a2V5", password: "correct horse"},
+		{name: "bad params", stored: "$argon2id$v=19$m=x,t=2,p=1$c2FsdHNhbHQ$a2V5", password: "correct horse"},
+		{name: "bad salt", stored: "$argon2id$v=19$m=64,t=2,p=1$!!!$a2V5", password: "correct horse"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match, needsRehash := VerifyPassword(test.stored, test.password)
+			if match != test.match || needsRehash != test.needsRehash {
+				t.Errorf("VerifyPassword() = %v, %v, want %v, %v", match, needsRehash, test.match, test.needsRehash)
+			}
+		})
+	}
+}
+
+func TestDecodePasswordHash(t *testing.T) {
+	params, salt, key, err := decodePasswordHash("$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHQ$a2V5a2V5a2V5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PasswordParams{Memory: 65536, Time: 3, Threads: 2, SaltLen: 8, KeyLen: 9}
+	if params != want {
+		t.Errorf("params = %+v, want %+v", params, want)
+	}
+	if string(salt) != "saltsalt" || string(key) != "keykeykey" {
+		t.Errorf("salt, key = %q, %q", salt, key)
+	}
+}
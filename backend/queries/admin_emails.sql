@@ -1,3 +0,0 @@
--- name: CheckIfAdmin :one
-SELECT email FROM admin_emails
-where email=$1;
\ No newline at end of file
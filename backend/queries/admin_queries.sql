@@ -0,0 +1,32 @@
+-- name: CreateAdminQueryLog :exec
+INSERT INTO admin_query_log (user_id, email, saved_query_id, query, params, format, duration_ms, row_count, truncated, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: ListAdminQueryLog :many
+SELECT * FROM admin_query_log
+WHERE (@email::text = '' OR email = @email::citext)
+ORDER BY created_at DESC
+LIMIT @row_limit;
+
+-- name: CreateAdminSavedQuery :one
+INSERT INTO admin_saved_queries (name, description, query, params, created_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: ListAdminSavedQueries :many
+SELECT * FROM admin_saved_queries
+ORDER BY name;
+
+-- name: GetAdminSavedQuery :one
+SELECT * FROM admin_saved_queries
+WHERE id = $1;
+
+-- name: UpdateAdminSavedQuery :one
+UPDATE admin_saved_queries
+SET name = $2, description = $3, query = $4, params = $5, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteAdminSavedQuery :execrows
+DELETE FROM admin_saved_queries
+WHERE id = $1;
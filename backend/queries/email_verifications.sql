@@ -0,0 +1,13 @@
+-- name: CreateEmailVerification :exec
+INSERT INTO email_verifications (user_id, email, token_hash, expires_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: ConsumeEmailVerification :one
+UPDATE email_verifications
+SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+RETURNING user_id, email;
+
+-- name: DeleteUnusedEmailVerifications :exec
+DELETE FROM email_verifications
+WHERE user_id = $1 AND used_at IS NULL;
@@ -4,8 +4,9 @@ VALUES ($1, $2, $3, $4)
 RETURNING id, created_at;
 
 -- name: FindFoldersByRoom :many
+-- FindFoldersByRoom doesn't check access, see Authorizer
 SELECT id, name, created_at FROM folders 
-where room_id=$1 AND user_id=$2;
+where room_id=$1;
 
 -- name: FindFolderById :one
 SELECT * FROM folders where id=$1;
\ No newline at end of file
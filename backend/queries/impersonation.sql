@@ -0,0 +1,10 @@
+-- name: CreateImpersonationLog :exec
+INSERT INTO impersonation_log (session_id, impersonator_id, impersonator_email, user_id, user_email, method, path, status)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListImpersonationLog :many
+-- ListImpersonationLog returns the latest entries, of one admin or impersonated user when email is set
+SELECT * FROM impersonation_log
+WHERE (@email::text = '' OR impersonator_email = @email::citext OR user_email = @email::citext)
+ORDER BY created_at DESC, id DESC
+LIMIT @row_limit;
@@ -0,0 +1,25 @@
+-- name: CreateJWTSigningKey :one
+INSERT INTO jwt_signing_keys (kid, algorithm, private_key, public_key)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: ListJWTSigningKeys :many
+SELECT * FROM jwt_signing_keys
+ORDER BY id;
+
+-- name: RetireJWTSigningKey :execrows
+-- The active key (newest not retired) can only be retired by rotating first
+UPDATE jwt_signing_keys
+SET retired_at = CURRENT_TIMESTAMP
+WHERE kid = $1 AND retired_at IS NULL
+AND id < (SELECT MAX(id) FROM jwt_signing_keys WHERE retired_at IS NULL);
+
+-- name: RetireReplacedJWTSigningKeys :execrows
+-- Retires keys replaced by a newer key before $1, their tokens have expired by then
+UPDATE jwt_signing_keys
+SET retired_at = CURRENT_TIMESTAMP
+WHERE retired_at IS NULL
+AND EXISTS (
+    SELECT 1 FROM jwt_signing_keys newer
+    WHERE newer.id > jwt_signing_keys.id AND newer.created_at < $1
+);
@@ -0,0 +1,15 @@
+-- name: GetNoteCrdtState :one
+SELECT state FROM note_crdt_states
+WHERE note_id = $1;
+
+-- name: SaveNoteCrdtState :execrows
+-- SaveNoteCrdtState stores the Yjs state and the text of a note together, nothing once the note is deleted
+WITH updated AS (
+    UPDATE notes SET content = @content
+    WHERE id = @note_id
+    RETURNING id
+)
+INSERT INTO note_crdt_states (note_id, state)
+SELECT id, @state::bytea FROM updated
+ON CONFLICT (note_id) DO UPDATE
+SET state = EXCLUDED.state, updated_at = CURRENT_TIMESTAMP;
@@ -4,17 +4,24 @@ VALUES ($1, $2, $3, $4, $5, $6, $7)
 RETURNING id, created_at;
 
 -- name: FindNotesByFolder :many
+-- FindNotesByFolder doesn't check access, see Authorizer
 SELECT id, title, created_at FROM notes
-where folder_id=$1 AND user_id=$2;
+where folder_id=$1;
+
+-- name: FindNoteContentsByFolder :many
+-- FindNoteContentsByFolder doesn't check access, see Authorizer
+SELECT title, content, created_at FROM notes
+where folder_id=$1
+ORDER BY created_at, id;
 
 -- name: FindNotesById :one
 SELECT * FROM notes where id=$1;
 
--- name: UpdateNoteNameAndContent :exec
+-- name: UpdateNoteNameAndContent :execrows
 UPDATE notes
 SET title = $1, content = $2
-WHERE id = $3 AND user_id = $4;
+WHERE id = $3;
 
--- name: DeleteNote :exec
+-- name: DeleteNote :execrows
 DELETE FROM notes 
-WHERE id=$1 AND user_id=$2;
\ No newline at end of file
+WHERE id=$1;
\ No newline at end of file
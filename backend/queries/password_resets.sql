@@ -0,0 +1,17 @@
+-- name: CreatePasswordReset :exec
+INSERT INTO password_resets (user_id, token_hash, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: FindPasswordResetByTokenHash :one
+SELECT * FROM password_resets
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP;
+
+-- name: ConsumePasswordReset :one
+UPDATE password_resets
+SET used_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+RETURNING user_id;
+
+-- name: DeleteUnusedPasswordResets :exec
+DELETE FROM password_resets
+WHERE user_id = $1 AND used_at IS NULL;
@@ -0,0 +1,18 @@
+-- name: TakeRateLimitToken :one
+-- Refills the bucket for the time passed and takes a token, returns no row when it is empty
+INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at)
+VALUES (@key, @capacity::float8 - 1, CURRENT_TIMESTAMP)
+ON CONFLICT (key) DO UPDATE
+SET tokens = LEAST(@capacity::float8, b.tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - b.updated_at))::float8 * @refill_rate::float8) - 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE LEAST(@capacity::float8, b.tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - b.updated_at))::float8 * @refill_rate::float8) >= 1
+RETURNING tokens;
+
+-- name: GetRateLimitTokens :one
+SELECT LEAST(@capacity::float8, tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - updated_at))::float8 * @refill_rate::float8)::float8 AS tokens
+FROM rate_limit_buckets
+WHERE key = @key;
+
+-- name: CleanupRateLimitBuckets :exec
+DELETE FROM rate_limit_buckets
+WHERE updated_at < $1;
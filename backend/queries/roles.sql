@@ -0,0 +1,47 @@
+-- name: ListRoles :many
+SELECT * FROM roles
+ORDER BY id;
+
+-- name: GetRoleByName :one
+SELECT * FROM roles
+WHERE name = $1;
+
+-- name: ListRolePermissions :many
+SELECT * FROM role_permissions
+ORDER BY role_id, permission;
+
+-- name: ListUserRoles :many
+-- All grants when user_id is 0
+SELECT user_roles.user_id, users.email, roles.name AS role, user_roles.granted_by, user_roles.granted_at
+FROM user_roles
+JOIN users ON users.id = user_roles.user_id
+JOIN roles ON roles.id = user_roles.role_id
+WHERE (@user_id::int = 0 OR user_roles.user_id = @user_id::int)
+ORDER BY users.email, roles.name;
+
+-- name: ListUserPermissions :many
+SELECT DISTINCT role_permissions.permission
+FROM user_roles
+JOIN role_permissions ON role_permissions.role_id = user_roles.role_id
+WHERE user_roles.user_id = $1
+ORDER BY role_permissions.permission;
+
+-- name: UserHasPermission :one
+SELECT EXISTS (
+    SELECT 1 FROM user_roles
+    JOIN role_permissions ON role_permissions.role_id = user_roles.role_id
+    WHERE user_roles.user_id = $1 AND role_permissions.permission = $2
+);
+
+-- name: GrantUserRole :execrows
+INSERT INTO user_roles (user_id, role_id, granted_by)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, role_id) DO NOTHING;
+
+-- name: RevokeUserRole :execrows
+DELETE FROM user_roles
+WHERE user_id = $1 AND role_id = $2;
+
+-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM user_roles
+WHERE role_id = $1;
@@ -0,0 +1,43 @@
+-- name: CreateRoomInviteLink :one
+INSERT INTO room_invite_links (room_id, created_by, token_hash, role, max_uses, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListRoomInviteLinks :many
+SELECT * FROM room_invite_links
+WHERE room_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- name: FindUsableRoomInviteLinkByHash :one
+-- FindUsableRoomInviteLinkByHash only finds links that are neither revoked, expired nor used up
+SELECT * FROM room_invite_links
+WHERE token_hash = $1 AND revoked_at IS NULL
+  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+  AND (max_uses IS NULL OR use_count < max_uses);
+
+-- name: RedeemRoomInviteLink :one
+-- RedeemRoomInviteLink makes the user a member with the link's role and counts the use.
+-- A pending invitation is accepted with the link's role, members keep theirs and use nothing.
+WITH link AS (
+    UPDATE room_invite_links
+    SET use_count = use_count + 1
+    WHERE token_hash = @token_hash AND revoked_at IS NULL
+      AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+      AND (max_uses IS NULL OR use_count < max_uses)
+      AND NOT EXISTS (
+          SELECT 1 FROM room_members
+          WHERE room_members.room_id = room_invite_links.room_id
+            AND room_members.user_id = @user_id AND room_members.accepted_at IS NOT NULL
+      )
+    RETURNING room_id, role, created_by
+)
+INSERT INTO room_members (room_id, user_id, role, invited_by, accepted_at)
+SELECT room_id, @user_id, role, created_by, CURRENT_TIMESTAMP FROM link
+ON CONFLICT (room_id, user_id) DO UPDATE
+SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, accepted_at = EXCLUDED.accepted_at
+RETURNING room_id, role;
+
+-- name: RevokeRoomInviteLink :execrows
+UPDATE room_invite_links
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND room_id = $2 AND revoked_at IS NULL;
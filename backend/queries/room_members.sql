@@ -0,0 +1,41 @@
+-- name: GetRoomMemberRole :one
+-- GetRoomMemberRole is the role of a member who accepted the invitation
+SELECT role FROM room_members
+WHERE room_id = $1 AND user_id = $2 AND accepted_at IS NOT NULL;
+
+-- name: ListRoomMembers :many
+SELECT room_members.user_id, users.email, users.username, room_members.role, room_members.invited_by, room_members.invited_at, room_members.accepted_at
+FROM room_members
+JOIN users ON users.id = room_members.user_id
+WHERE room_members.room_id = $1
+ORDER BY room_members.invited_at, room_members.user_id;
+
+-- name: ListRoomInvitesForUser :many
+-- ListRoomInvitesForUser lists the invitations the user hasn't accepted yet
+SELECT room_members.room_id, rooms.name AS room_name, room_members.role, inviter.email AS invited_by_email, room_members.invited_at
+FROM room_members
+JOIN rooms ON rooms.id = room_members.room_id
+LEFT JOIN users inviter ON inviter.id = room_members.invited_by
+WHERE room_members.user_id = $1 AND room_members.accepted_at IS NULL
+ORDER BY room_members.invited_at DESC;
+
+-- name: CreateRoomInvite :execrows
+INSERT INTO room_members (room_id, user_id, role, invited_by)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (room_id, user_id) DO NOTHING;
+
+-- name: AcceptRoomInvite :execrows
+UPDATE room_members
+SET accepted_at = CURRENT_TIMESTAMP
+WHERE room_id = $1 AND user_id = $2 AND accepted_at IS NULL;
+
+-- name: UpdateRoomMemberRole :execrows
+-- UpdateRoomMemberRole never changes the owner
+UPDATE room_members
+SET role = $3
+WHERE room_id = $1 AND user_id = $2 AND role <> 'owner';
+
+-- name: DeleteRoomMember :execrows
+-- DeleteRoomMember removes a member or declines an invitation, the owner stays
+DELETE FROM room_members
+WHERE room_id = $1 AND user_id = $2 AND role <> 'owner';
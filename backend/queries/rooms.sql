@@ -1,10 +1,19 @@
 -- name: CreateRoom :exec
-INSERT INTO rooms (name, user_id)
-VALUES ($1, $2);
+-- CreateRoom creates the room with its creator as owner
+WITH room AS (
+    INSERT INTO rooms (name, user_id)
+    VALUES ($1, $2)
+    RETURNING id, user_id
+)
+INSERT INTO room_members (room_id, user_id, role, accepted_at)
+SELECT id, user_id, 'owner', CURRENT_TIMESTAMP FROM room;
 
 -- name: FindRoomsByUser :many
-SELECT id, name, created_at FROM rooms 
-where user_id=$1;
+-- FindRoomsByUser lists the rooms the user owns or was invited to and accepted, with their role
+SELECT rooms.id, rooms.name, rooms.created_at, room_members.role FROM rooms 
+JOIN room_members ON room_members.room_id = rooms.id
+where room_members.user_id=$1 AND room_members.accepted_at IS NOT NULL
+ORDER BY rooms.created_at, rooms.id;
 
 -- name: FindRoomById :one
 SELECT * FROM rooms where id=$1;
\ No newline at end of file
@@ -10,9 +10,13 @@ INSERT INTO user_sessions (
     os_version, 
     ip_address, 
     user_agent, 
-    expires_at
+    expires_at,
+    mfa_used,
+    auth_method,
+    auth_provider,
+    impersonator_id
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
 ) RETURNING *;
 
 -- name: GetSessionByToken :one
@@ -32,7 +36,7 @@ RETURNING id;
 -- name: UpdateSessionLastUsedAndExpiry :one
 UPDATE user_sessions 
 SET last_used_at = CURRENT_TIMESTAMP,
-expiry_at = CURRENT_TIMESTAMP + INTERVAL '7 days'
+expires_at = $2
 WHERE id = $1
 RETURNING id;
 
@@ -51,6 +55,12 @@ UPDATE user_sessions
 SET is_active = false 
 WHERE user_id = $1 AND id != $2;
 
+-- name: DeleteAllSessionsForUser :exec
+-- DeleteAllSessionsForUser also ends the impersonations the user started
+UPDATE user_sessions 
+SET is_active = false 
+WHERE user_id = $1 OR impersonator_id = $1;
+
 -- name: CleanupExpiredSessions :exec
 UPDATE user_sessions 
 SET is_active = false 
@@ -0,0 +1,30 @@
+-- name: CreateShareLink :one
+INSERT INTO share_links (user_id, note_id, folder_id, token_hash, password_hash, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: FindShareLinkByHash :one
+-- FindShareLinkByHash only finds links that are neither revoked nor expired
+SELECT * FROM share_links
+WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP);
+
+-- name: RecordShareLinkView :exec
+UPDATE share_links
+SET view_count = view_count + 1, last_viewed_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
+-- name: ListActiveShareLinksByUser :many
+SELECT share_links.id, share_links.note_id, notes.title AS note_title, share_links.folder_id, folders.name AS folder_name,
+       share_links.password_hash IS NOT NULL AS has_password, share_links.view_count, share_links.last_viewed_at,
+       share_links.created_at, share_links.expires_at
+FROM share_links
+LEFT JOIN notes ON notes.id = share_links.note_id
+LEFT JOIN folders ON folders.id = share_links.folder_id
+WHERE share_links.user_id = $1 AND share_links.revoked_at IS NULL
+  AND (share_links.expires_at IS NULL OR share_links.expires_at > CURRENT_TIMESTAMP)
+ORDER BY share_links.created_at DESC;
+
+-- name: RevokeShareLink :execrows
+UPDATE share_links
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
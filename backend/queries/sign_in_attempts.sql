@@ -0,0 +1,56 @@
+-- name: RecordSignInAttempt :exec
+INSERT INTO sign_in_attempts (email, user_id, ip_address, user_agent, succeeded, failure_reason)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: ListSignInAttempts :many
+SELECT * FROM sign_in_attempts
+WHERE (@email::text = '' OR email = @email::citext)
+AND (@ip_address::text = '' OR host(ip_address) = @ip_address::text)
+ORDER BY created_at DESC
+LIMIT @row_limit;
+
+-- name: ListFailedSignInsByIP :many
+SELECT host(ip_address)::text AS ip_address,
+    COUNT(*) AS failures,
+    COUNT(DISTINCT email) AS accounts,
+    MAX(created_at)::timestamp AS last_attempt_at
+FROM sign_in_attempts
+WHERE NOT succeeded AND ip_address IS NOT NULL AND created_at > $1
+GROUP BY ip_address
+ORDER BY failures DESC
+LIMIT 50;
+
+-- name: CleanupOldSignInAttempts :exec
+DELETE FROM sign_in_attempts
+WHERE created_at < $1;
+
+-- name: GetSignInLockout :one
+SELECT * FROM sign_in_lockouts
+WHERE scope = $1 AND subject = $2;
+
+-- name: RegisterSignInFailure :one
+-- Failures before $3 are forgotten, counting starts again
+INSERT INTO sign_in_lockouts (scope, subject, failed_count)
+VALUES ($1, $2, 1)
+ON CONFLICT (scope, subject) DO UPDATE
+SET failed_count = CASE WHEN sign_in_lockouts.last_failed_at < $3 THEN 1 ELSE sign_in_lockouts.failed_count + 1 END,
+    last_failed_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: LockSignIn :exec
+UPDATE sign_in_lockouts
+SET locked_until = $3
+WHERE scope = $1 AND subject = $2;
+
+-- name: ListSignInLockouts :many
+SELECT * FROM sign_in_lockouts
+WHERE locked_until > CURRENT_TIMESTAMP OR last_failed_at > $1
+ORDER BY last_failed_at DESC;
+
+-- name: DeleteSignInLockout :execrows
+DELETE FROM sign_in_lockouts
+WHERE scope = $1 AND subject = $2;
+
+-- name: CleanupOldSignInLockouts :exec
+DELETE FROM sign_in_lockouts
+WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP);
@@ -0,0 +1,45 @@
+-- name: UpsertTOTPSecret :exec
+INSERT INTO user_totp (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, enabled_at = NULL, last_used_step = 0, created_at = CURRENT_TIMESTAMP;
+
+-- name: GetTOTPByUser :one
+SELECT * FROM user_totp
+WHERE user_id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE user_totp
+SET enabled_at = CURRENT_TIMESTAMP, last_used_step = $2
+WHERE user_id = $1;
+
+-- name: UpdateTOTPLastUsedStep :execrows
+UPDATE user_totp
+SET last_used_step = $2
+WHERE user_id = $1 AND last_used_step < $2;
+
+-- name: DeleteTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO user_recovery_codes (user_id, code_hash)
+VALUES ($1, $2);
+
+-- name: GetUnusedRecoveryCodes :many
+SELECT * FROM user_recovery_codes
+WHERE user_id = $1 AND used_at IS NULL
+ORDER BY id;
+
+-- name: UseRecoveryCode :execrows
+UPDATE user_recovery_codes
+SET used_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id = $2 AND used_at IS NULL;
+
+-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*) FROM user_recovery_codes
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM user_recovery_codes
+WHERE user_id = $1;
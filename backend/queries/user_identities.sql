@@ -0,0 +1,22 @@
+-- name: FindUserIdentity :one
+SELECT * FROM user_identities
+WHERE issuer = $1 AND subject = $2;
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (user_id, provider, issuer, subject, email, last_login_at)
+VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+RETURNING *;
+
+-- name: UpdateUserIdentityLogin :exec
+UPDATE user_identities
+SET email = $2, last_login_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
+-- name: ListUserIdentitiesByUser :many
+SELECT * FROM user_identities
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: DeleteUserIdentity :execrows
+DELETE FROM user_identities
+WHERE id = $1 AND user_id = $2;
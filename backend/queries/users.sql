@@ -1,5 +1,43 @@
--- name: ListUsers :many
-SELECT id, username, email, created_at FROM users ORDER BY created_at DESC;
+-- name: SearchUsers :many
+-- SearchUsers lists users newest first, search is an ILIKE pattern on username or email
+SELECT id, username, email, created_at, email_verified_at, disabled_at FROM users
+WHERE @search::text = '' OR username ILIKE @search::text OR email ILIKE @search::text
+ORDER BY created_at DESC, id DESC
+LIMIT @row_limit OFFSET @row_offset;
+
+-- name: CountUsers :one
+SELECT COUNT(*) FROM users
+WHERE @search::text = '' OR username ILIKE @search::text OR email ILIKE @search::text;
+
+-- name: GetUserAccount :one
+SELECT id, username, email, created_at, email_verified_at, disabled_at FROM users
+WHERE id = $1;
+
+-- name: GetUserContentCounts :one
+-- Folders and notes count when they are in the user's rooms, whoever created them
+SELECT
+    (SELECT COUNT(*) FROM rooms WHERE rooms.user_id = @user_id::int) AS rooms,
+    (SELECT COUNT(*) FROM folders JOIN rooms ON rooms.id = folders.room_id WHERE rooms.user_id = @user_id::int) AS folders,
+    (SELECT COUNT(*) FROM notes JOIN rooms ON rooms.id = notes.room_id WHERE rooms.user_id = @user_id::int) AS notes;
+
+-- name: GetUserDisabledAt :one
+SELECT disabled_at FROM users
+WHERE id = $1;
+
+-- name: DisableUser :execrows
+UPDATE users
+SET disabled_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND disabled_at IS NULL;
+
+-- name: EnableUser :execrows
+UPDATE users
+SET disabled_at = NULL
+WHERE id = $1 AND disabled_at IS NOT NULL;
+
+-- name: DeleteUser :execrows
+-- DeleteUser removes the user, the foreign keys cascade to their rooms, folders, notes and sessions
+DELETE FROM users
+WHERE id = $1;
 
 -- name: CreateUser :one
 INSERT INTO users (username, email, password_hash)
@@ -19,6 +57,19 @@ UPDATE users
 SET password_hash = $2
 WHERE id = $1;
 
+-- name: MarkUserEmailVerified :exec
+UPDATE users
+SET email_verified_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND email = $2;
+
+-- name: GetUserEmailVerifiedAt :one
+SELECT email_verified_at FROM users
+WHERE id = $1;
+
+-- name: ListUsersWithLegacyPasswords :many
+SELECT id, password_hash FROM users
+WHERE password_hash NOT LIKE '$argon2id$%';
+
 -- -- name: CreateRoom :one
 -- INSERT INTO rooms (name, user_id)
 -- VALUES ($1, $2)
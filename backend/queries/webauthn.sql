@@ -0,0 +1,51 @@
+-- name: CreateWebAuthnCredential :exec
+INSERT INTO webauthn_credentials (
+    user_id,
+    credential_id,
+    public_key,
+    attestation_type,
+    aaguid,
+    sign_count,
+    transports,
+    backup_eligible,
+    backup_state,
+    name
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+);
+
+-- name: ListWebAuthnCredentialsByUser :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: FindWebAuthnCredentialByCredentialID :one
+SELECT * FROM webauthn_credentials
+WHERE credential_id = $1;
+
+-- name: UpdateWebAuthnCredentialUsage :exec
+UPDATE webauthn_credentials
+SET sign_count = $2, clone_warning = $3, backup_state = $4, last_used_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
+-- name: RenameWebAuthnCredential :execrows
+UPDATE webauthn_credentials
+SET name = $3
+WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1 AND user_id = $2;
+
+-- name: CreateWebAuthnCeremony :exec
+INSERT INTO webauthn_ceremonies (id, user_id, kind, session_data, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ConsumeWebAuthnCeremony :one
+DELETE FROM webauthn_ceremonies
+WHERE id = $1 AND kind = $2 AND expires_at > CURRENT_TIMESTAMP
+RETURNING user_id, session_data;
+
+-- name: CleanupExpiredWebAuthnCeremonies :exec
+DELETE FROM webauthn_ceremonies
+WHERE expires_at < CURRENT_TIMESTAMP;
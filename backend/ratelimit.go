@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"steamednotes/db"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitPolicy is a token bucket holding Limit requests, refilled evenly over Period
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+func (p RateLimitPolicy) refillRate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Per route policies, anonymous routes count per client IP, authenticated ones per user
+var (
+	RateLimitGlobal     = RateLimitPolicy{Name: "global", Limit: 600, Period: time.Minute} // every request, per IP
+	RateLimitSignIn     = RateLimitPolicy{Name: "signin", Limit: 10, Period: time.Minute}
+	RateLimitSignUp     = RateLimitPolicy{Name: "signup", Limit: 5, Period: time.Hour}
+	RateLimitEmail      = RateLimitPolicy{Name: "email", Limit: 5, Period: time.Hour} // routes sending emails
+	RateLimitNotesRead  = RateLimitPolicy{Name: "notes-read", Limit: 300, Period: time.Minute}
+	RateLimitNotesWrite = RateLimitPolicy{Name: "notes-write", Limit: 120, Period: time.Minute}
+	RateLimitPublic     = RateLimitPolicy{Name: "public", Limit: 30, Period: time.Minute} // share links, also slows down password guessing
+)
+
+// RateLimitResult is the state of a bucket after taking a token
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, when not allowed
+}
+
+func newRateLimitResult(policy RateLimitPolicy, tokens float64, allowed bool) RateLimitResult {
+	rate := policy.refillRate()
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(policy.Limit) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return result
+}
+
+// RateLimitStore keeps the buckets, shared stores let replicas share limits
+type RateLimitStore interface {
+	// Take refills the bucket for the time passed and takes a token if there is one
+	Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+// NewRateLimitStore creates the configured store, nil when rate limiting is disabled
+func NewRateLimitStore(cfg RateLimitConfig, queries *db.Queries) (RateLimitStore, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	switch cfg.Store {
+	case "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "postgres":
+		return PostgresRateLimitStore{queries: queries}, nil
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+		client := redis.NewClient(opts)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			return nil, fmt.Errorf("connecting to redis: %w", err)
+		}
+		return RedisRateLimitStore{client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", cfg.Store)
+	}
+}
+
+// MemoryRateLimitStore keeps the buckets in this process, for a single replica
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time // the bucket can be dropped after this
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}}
+	go s.sweep(time.Minute)
+	return s
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(policy.Limit), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(policy.Limit), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*policy.refillRate())
+	bucket.updatedAt = now
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+
+	result := newRateLimitResult(policy, bucket.tokens, allowed)
+	bucket.fullAt = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops full buckets, they are the same as no bucket
+func (s *MemoryRateLimitStore) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		s.mu.Lock()
+		for key, bucket := range s.buckets {
+			if now.After(bucket.fullAt) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// PostgresRateLimitStore keeps the buckets in rate_limit_buckets
+type PostgresRateLimitStore struct {
+	queries *db.Queries
+}
+
+func (s PostgresRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	tokens, err := s.queries.TakeRateLimitToken(ctx, db.TakeRateLimitTokenParams{
+		Key:        key,
+		Capacity:   float64(policy.Limit),
+		RefillRate: policy.refillRate(),
+	})
+	if err == nil {
+		return newRateLimitResult(policy, tokens, true), nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return RateLimitResult{}, err
+	}
+
+	// Empty bucket, read how far it has refilled for the headers
+	tokens, err = s.queries.GetRateLimitTokens(ctx, db.GetRateLimitTokensParams{
+		Capacity:   float64(policy.Limit),
+		RefillRate: policy.refillRate(),
+		Key:        key,
+	})
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return newRateLimitResult(policy, tokens, false), nil
+}
+
+// CleanupRateLimitBuckets drops Postgres buckets unused for a day, they have refilled long ago
+func CleanupRateLimitBuckets(ctx context.Context, queries *db.Queries) error {
+	return queries.CleanupRateLimitBuckets(ctx, pgtype.Timestamp{Time: time.Now().Add(-24 * time.Hour), Valid: true})
+}
+
+// RedisRateLimitStore keeps the buckets in Redis hashes that expire once full
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+// Runs atomically in Redis, using the Redis clock so replicas agree
+var redisTakeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated_at')
+local tokens = tonumber(bucket[1]) or capacity
+local updated_at = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updated_at) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated_at', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+func (s RedisRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	values, err := redisTakeTokenScript.Run(ctx, s.client, []string{"ratelimit:" + key}, policy.Limit, policy.refillRate()).Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(values) != 2 {
+		return RateLimitResult{}, fmt.Errorf("unexpected redis reply %v", values)
+	}
+
+	allowed, _ := values[0].(int64)
+	tokensStr, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return newRateLimitResult(policy, tokens, allowed == 1), nil
+}
+
+// rateLimitKey is the user id set by authMiddleware, or the client IP on anonymous routes
+func rateLimitKey(policy RateLimitPolicy, r *http.Request) string {
+	if id := r.Header.Get("id"); id != "" {
+		return policy.Name + ":user:" + id
+	}
+	return policy.Name + ":ip:" + GetClientIP(r)
+}
+
+// allowRequest takes a token and sets the RateLimit headers, it answers 429 itself when out of tokens.
+// Store errors let the request through, the limiter must not take the API down.
+func (connData ConnectionData) allowRequest(policy RateLimitPolicy, w http.ResponseWriter, r *http.Request) bool {
+	if connData.rateLimiter == nil {
+		return true
+	}
+
+	result, err := connData.rateLimiter.Take(r.Context(), rateLimitKey(policy, r), policy)
+	if err != nil {
+		fmt.Printf("Rate limiter failed for %s: %v\n", policy.Name, err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Period.Seconds())))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// rateLimit limits a route, wrap it inside authMiddleware to count per user
+func (connData ConnectionData) rateLimit(policy RateLimitPolicy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if connData.allowRequest(policy, w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// rateLimitHandler limits every request of the handler, e.g. the whole mux
+func (connData ConnectionData) rateLimitHandler(policy RateLimitPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if connData.allowRequest(policy, w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimitResult(t *testing.T) {
+	perSecond := RateLimitPolicy{Name: "test", Limit: 60, Period: time.Minute}
+
+	tests := []struct {
+		name    string
+		tokens  float64
+		allowed bool
+		want    RateLimitResult
+	}{
+		{
+			name:    "full",
+			tokens:  59,
+			allowed: true,
+			want:    RateLimitResult{Allowed: true, Remaining: 59, Reset: time.Second},
+		},
+		{
+			name:    "last token taken",
+			tokens:  0,
+			allowed: true,
+			want:    RateLimitResult{Allowed: true, Remaining: 0, Reset: time.Minute},
+		},
+		{
+			name:    "refilling",
+			tokens:  0.25,
+			allowed: false,
+			want:    RateLimitResult{Allowed: false, Remaining: 0, Reset: 59750 * time.Millisecond, RetryAfter: 750 * time.Millisecond},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := newRateLimitResult(perSecond, test.tokens, test.allowed); got != test.want {
+				t.Errorf("newRateLimitResult() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	policy := RateLimitPolicy{Name: "test", Limit: 3, Period: 3 * time.Second} // a token a second
+	store := &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}}
+	ctx := context.Background()
+
+	for want := 2; want >= 0; want-- {
+		result, err := store.Take(ctx, "a", policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != want {
+			t.Fatalf("Take() = %+v, want allowed with %d remaining", result, want)
+		}
+	}
+
+	result, _ := store.Take(ctx, "a", policy)
+	if result.Allowed || result.RetryAfter <= 900*time.Millisecond || result.RetryAfter > time.Second {
+		t.Fatalf("Take() on an empty bucket = %+v, want refused for about a second", result)
+	}
+	if other, _ := store.Take(ctx, "b", policy); !other.Allowed {
+		t.Fatalf("Take() of another key = %+v, want allowed", other)
+	}
+
+	// Half the period later the bucket has 1.5 tokens again
+	store.buckets["a"].updatedAt = store.buckets["a"].updatedAt.Add(-1500 * time.Millisecond)
+	result, _ = store.Take(ctx, "a", policy)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Take() after refilling = %+v, want allowed with 0 remaining", result)
+	}
+	if result.Reset <= 2*time.Second || result.Reset > 2500*time.Millisecond {
+		t.Errorf("Reset = %v, want about 2.5s", result.Reset)
+	}
+
+	// A bucket never holds more than Limit
+	store.buckets["a"].updatedAt = store.buckets["a"].updatedAt.Add(-time.Hour)
+	result, _ = store.Take(ctx, "a", policy)
+	if result.Remaining != policy.Limit-1 {
+		t.Errorf("Take() after an hour = %+v, want %d remaining", result, policy.Limit-1)
+	}
+}
+
+func TestAllowRequestHeaders(t *testing.T) {
+	policy := RateLimitPolicy{Name: "test", Limit: 1, Period: time.Minute}
+	connData := ConnectionData{rateLimiter: &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}}}
+	r := httptest.NewRequest("POST", "/api/signin", nil)
+	r.Header.Set("id", "7")
+
+	w := httptest.NewRecorder()
+	if !connData.allowRequest(policy, w, r) {
+		t.Fatal("first request refused")
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != "1;w=60" {
+		t.Errorf("RateLimit-Policy = %q, want %q", got, "1;w=60")
+	}
+
+	w = httptest.NewRecorder()
+	if connData.allowRequest(policy, w, r) {
+		t.Fatal("second request allowed")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	// Retry-After rounds up to whole seconds
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want %q", got, "60")
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want %q", got, "0")
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"steamednotes/db"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Permissions checked by RequirePermission, roles grant them through role_permissions (see V014)
+const (
+	PermissionUsersRead        = "users:read"        // see user accounts and their roles
+	PermissionUsersManage      = "users:manage"      // disable, enable and delete users, force password resets
+	PermissionUsersImpersonate = "users:impersonate" // sign in as another user
+	PermissionRolesManage      = "roles:manage"      // grant and revoke roles
+	PermissionSecurityRead     = "security:read"     // see sign in attempts, lockouts and signing keys
+	PermissionSecurityManage   = "security:manage"   // clear sign in lockouts
+	PermissionKeysManage       = "keys:manage"       // rotate and retire jwt signing keys
+	PermissionAdminQuery       = "admin:query"       // run SQL queries on the database
+	PermissionAuditRead        = "audit:read"        // see the admin query log
+)
+
+// Built-in roles
+const (
+	RoleAdmin   = "admin"   // every permission
+	RoleSupport = "support" // users:read, security:read, security:manage
+	RoleAuditor = "auditor" // users:read, security:read, audit:read
+)
+
+var (
+	errUnknownRole = errors.New("unknown role")
+	errUnknownUser = errors.New("unknown user")
+	errLastAdmin   = errors.New("the last admin can't lose the admin role")
+)
+
+// RequirePermission lets only users whose roles grant the permission through,
+// it expects to run inside authMiddleware or requireScope. Impersonation sessions never pass.
+func (connData ConnectionData) RequirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isImpersonating(r) {
+			http.Error(w, "Not allowed while impersonating a user", http.StatusForbidden)
+			return
+		}
+
+		userID, err := strconv.Atoi(r.Header.Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := connData.queries.UserHasPermission(r.Context(), db.UserHasPermissionParams{
+			UserID:     int32(userID),
+			Permission: permission,
+		})
+		if err != nil {
+			fmt.Printf("Failed to check permission %s for user %d: %v\n", permission, userID, err)
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Missing permission "+permission, http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// findUserAndRole looks up the user by email and the role by name
+func findUserAndRole(ctx context.Context, queries *db.Queries, email, roleName string) (int32, db.Role, error) {
+	user, err := queries.FindUserByEmail(ctx, email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, db.Role{}, errUnknownUser
+	}
+	if err != nil {
+		return 0, db.Role{}, err
+	}
+
+	role, err := queries.GetRoleByName(ctx, roleName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, db.Role{}, errUnknownRole
+	}
+	if err != nil {
+		return 0, db.Role{}, err
+	}
+	return user.ID, role, nil
+}
+
+// GrantRole gives the user a role, false when they already had it. grantedBy is 0 for commands.
+func GrantRole(ctx context.Context, queries *db.Queries, email, roleName string, grantedBy int32) (bool, error) {
+	userID, role, err := findUserAndRole(ctx, queries, email, roleName)
+	if err != nil {
+		return false, err
+	}
+
+	granted, err := queries.GrantUserRole(ctx, db.GrantUserRoleParams{
+		UserID:    userID,
+		RoleID:    role.ID,
+		GrantedBy: pgtype.Int4{Int32: grantedBy, Valid: grantedBy != 0},
+	})
+	return granted > 0, err
+}
+
+// RevokeRole takes a role from the user, false when they didn't have it.
+// The last admin keeps the admin role, nobody could grant it again.
+func RevokeRole(ctx context.Context, queries *db.Queries, email, roleName string) (bool, error) {
+	userID, role, err := findUserAndRole(ctx, queries, email, roleName)
+	if err != nil {
+		return false, err
+	}
+
+	if role.Name == RoleAdmin {
+		admins, err := queries.CountUsersWithRole(ctx, role.ID)
+		if err != nil {
+			return false, err
+		}
+		if admins <= 1 {
+			return false, errLastAdmin
+		}
+	}
+
+	revoked, err := queries.RevokeUserRole(ctx, db.RevokeUserRoleParams{UserID: userID, RoleID: role.ID})
+	return revoked > 0, err
+}
+
+// RoleDTO represents a role with its permissions for JSON response
+type RoleDTO struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserRoleDTO represents a role granted to a user for JSON response
+type UserRoleDTO struct {
+	UserID    int32  `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	GrantedBy int32  `json:"granted_by,omitempty"`
+	GrantedAt string `json:"granted_at"`
+}
+
+func newUserRoleDTOs(userRoles []db.ListUserRolesRow) []UserRoleDTO {
+	dtos := make([]UserRoleDTO, len(userRoles))
+	for i, userRole := range userRoles {
+		dtos[i] = UserRoleDTO{
+			UserID:    userRole.UserID,
+			Email:     userRole.Email,
+			Role:      userRole.Role,
+			GrantedBy: userRole.GrantedBy.Int32,
+			GrantedAt: userRole.GrantedAt.Time.Format(time.RFC3339),
+		}
+	}
+	return dtos
+}
+
+// Get the roles of the signed in user and the permissions they grant
+func (conn ConnectionData) getMyPermissions(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	userRoles, err := conn.queries.ListUserRoles(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to get roles", http.StatusInternalServerError)
+		return
+	}
+	permissions, err := conn.queries.ListUserPermissions(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to get permissions", http.StatusInternalServerError)
+		return
+	}
+
+	roles := make([]string, len(userRoles))
+	for i, userRole := range userRoles {
+		roles[i] = userRole.Role
+	}
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{
+		"roles":       roles,
+		"permissions": permissions,
+	})
+}
+
+// Get all roles with their permissions
+func (conn ConnectionData) getRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := conn.queries.ListRoles(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get roles", http.StatusInternalServerError)
+		return
+	}
+	rolePermissions, err := conn.queries.ListRolePermissions(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get roles", http.StatusInternalServerError)
+		return
+	}
+
+	permissionsByRole := map[int32][]string{}
+	for _, rolePermission := range rolePermissions {
+		permissionsByRole[rolePermission.RoleID] = append(permissionsByRole[rolePermission.RoleID], rolePermission.Permission)
+	}
+
+	roleDTOs := make([]RoleDTO, len(roles))
+	for i, role := range roles {
+		roleDTOs[i] = RoleDTO{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: permissionsByRole[role.ID],
+		}
+		if roleDTOs[i].Permissions == nil {
+			roleDTOs[i].Permissions = []string{}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roleDTOs)
+}
+
+// Get the granted roles, of one user when email is set
+func (conn ConnectionData) getUserRoles(w http.ResponseWriter, r *http.Request) {
+	var userID int32
+	if email := r.URL.Query().Get("email"); email != "" {
+		user, err := conn.queries.FindUserByEmail(r.Context(), email)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		userID = user.ID
+	}
+
+	userRoles, err := conn.queries.ListUserRoles(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to get roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserRoleDTOs(userRoles))
+}
+
+type UserRoleRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// writeRoleError answers the errors of GrantRole and RevokeRole
+func writeRoleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnknownUser):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, errUnknownRole):
+		http.Error(w, "Role not found", http.StatusNotFound)
+	case errors.Is(err, errLastAdmin):
+		http.Error(w, "The last admin can't lose the admin role", http.StatusConflict)
+	default:
+		fmt.Printf("Failed to change roles: %v\n", err)
+		http.Error(w, "Failed to change roles", http.StatusInternalServerError)
+	}
+}
+
+// Grant a role to a user
+func (conn ConnectionData) grantUserRole(w http.ResponseWriter, r *http.Request) {
+	adminID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Email == "" || req.Role == "" {
+		http.Error(w, "Email and role are required", http.StatusBadRequest)
+		return
+	}
+
+	granted, err := GrantRole(r.Context(), conn.queries, req.Email, req.Role, int32(adminID))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	if !granted {
+		json.NewEncoder(w).Encode(map[string]string{"message": "User already has the role"})
+		return
+	}
+	fmt.Printf("Role %s granted to %s by %s\n", req.Role, req.Email, r.Header.Get("email"))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role granted successfully"})
+}
+
+// Revoke a role from a user
+func (conn ConnectionData) revokeUserRole(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+	role := r.URL.Query().Get("role")
+	if email == "" || role == "" {
+		http.Error(w, "Missing email or role parameter", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := RevokeRole(r.Context(), conn.queries, email, role)
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	if !revoked {
+		http.Error(w, "User doesn't have the role", http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Role %s revoked from %s by %s\n", role, email, r.Header.Get("email"))
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role revoked successfully"})
+}
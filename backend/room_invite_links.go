@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"steamednotes/db"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	maxRoomInviteLinkDays = 90
+	maxRoomInviteLinkUses = 1000
+)
+
+var (
+	errInviteLinkNotFound = errors.New("invite link not found")
+	errAlreadyMember      = errors.New("already a member of the room")
+)
+
+type CreateRoomInviteLinkRequest struct {
+	RoomID        int32  `json:"room_id"`
+	Role          string `json:"role"`            // editor, commenter or viewer
+	MaxUses       int    `json:"max_uses"`        // 0 for unlimited uses
+	ExpiresInDays int    `json:"expires_in_days"` // 0 never expires
+}
+
+type RedeemRoomInviteLinkRequest struct {
+	Token string `json:"token"`
+}
+
+// RoomInviteLinkDTO represents an invite link for JSON response, never includes the token itself
+type RoomInviteLinkDTO struct {
+	ID        int32  `json:"id"`
+	RoomID    int32  `json:"room_id"`
+	Role      string `json:"role"`
+	CreatedBy int32  `json:"created_by,omitempty"`
+	MaxUses   int32  `json:"max_uses,omitempty"` // omitted for unlimited uses
+	UseCount  int32  `json:"use_count"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+func newRoomInviteLinkDTO(link db.RoomInviteLink) RoomInviteLinkDTO {
+	dto := RoomInviteLinkDTO{
+		ID:        link.ID,
+		RoomID:    link.RoomID,
+		Role:      link.Role,
+		CreatedBy: link.CreatedBy.Int32,
+		MaxUses:   link.MaxUses.Int32,
+		UseCount:  link.UseCount,
+		CreatedAt: link.CreatedAt.Time.Format(time.RFC3339),
+	}
+	if link.ExpiresAt.Valid {
+		dto.ExpiresAt = link.ExpiresAt.Time.Format(time.RFC3339)
+	}
+	return dto
+}
+
+// RedeemRoomInviteLink makes the user a member of the link's room. It returns errAlreadyMember
+// with the room when the user already is a member, the link isn't used then.
+func RedeemRoomInviteLink(ctx context.Context, queries *db.Queries, token string, userID int32) (db.RedeemRoomInviteLinkRow, error) {
+	tokenHash := HashToken(token)
+	joined, err := queries.RedeemRoomInviteLink(ctx, db.RedeemRoomInviteLinkParams{
+		TokenHash: tokenHash,
+		UserID:    userID,
+	})
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return joined, err
+	}
+
+	// Nothing was redeemed, either the link can't be used or the user is already in the room
+	link, err := queries.FindUsableRoomInviteLinkByHash(ctx, tokenHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.RedeemRoomInviteLinkRow{}, errInviteLinkNotFound
+	}
+	if err != nil {
+		return db.RedeemRoomInviteLinkRow{}, err
+	}
+	return db.RedeemRoomInviteLinkRow{RoomID: link.RoomID}, errAlreadyMember
+}
+
+// Create an invite link to a room, the token is only returned once
+func (conn ConnectionData) createRoomInviteLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateRoomInviteLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !validMemberRole(req.Role) {
+		http.Error(w, "Role must be editor, commenter or viewer", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 0 || req.MaxUses > maxRoomInviteLinkUses {
+		http.Error(w, fmt.Sprintf("max_uses must be between 0 and %d", maxRoomInviteLinkUses), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxRoomInviteLinkDays {
+		http.Error(w, fmt.Sprintf("expires_in_days must be between 0 and %d", maxRoomInviteLinkDays), http.StatusBadRequest)
+		return
+	}
+
+	room, err := conn.authz.CanManageRoom(r.Context(), int32(userID), req.RoomID)
+	if err != nil {
+		writeManageRoomError(w, err)
+		return
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	params := db.CreateRoomInviteLinkParams{
+		RoomID:    room.ID,
+		CreatedBy: pgtype.Int4{Int32: int32(userID), Valid: true},
+		TokenHash: HashToken(token),
+		Role:      req.Role,
+		MaxUses:   pgtype.Int4{Int32: int32(req.MaxUses), Valid: req.MaxUses > 0},
+	}
+	if req.ExpiresInDays > 0 {
+		params.ExpiresAt = pgtype.Timestamp{Time: time.Now().AddDate(0, 0, req.ExpiresInDays), Valid: true}
+	}
+
+	link, err := conn.queries.CreateRoomInviteLink(r.Context(), params)
+	if err != nil {
+		fmt.Printf("Failed to create invite link for room %d: %v\n", room.ID, err)
+		http.Error(w, "Failed to create invite link", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		RoomInviteLinkDTO
+		Token string `json:"token"`
+	}{newRoomInviteLinkDTO(link), token})
+}
+
+// Get the invite links of a room that weren't revoked, including expired and used up ones
+func (conn ConnectionData) getRoomInviteLinks(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	roomID, err := queryID(r, "room_id")
+	if err != nil {
+		http.Error(w, "Invalid room_id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := conn.authz.CanManageRoom(r.Context(), int32(userID), roomID); err != nil {
+		writeManageRoomError(w, err)
+		return
+	}
+
+	links, err := conn.queries.ListRoomInviteLinks(r.Context(), roomID)
+	if err != nil {
+		http.Error(w, "Failed to get invite links", http.StatusInternalServerError)
+		return
+	}
+
+	linkDTOs := make([]RoomInviteLinkDTO, len(links))
+	for i, link := range links {
+		linkDTOs[i] = newRoomInviteLinkDTO(link)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(linkDTOs)
+}
+
+// Revoke an invite link, members who joined with it stay
+func (conn ConnectionData) revokeRoomInviteLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	roomID, err := queryID(r, "room_id")
+	if err != nil {
+		http.Error(w, "Invalid room_id", http.StatusBadRequest)
+		return
+	}
+	linkID, err := queryID(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := conn.authz.CanManageRoom(r.Context(), int32(userID), roomID); err != nil {
+		writeManageRoomError(w, err)
+		return
+	}
+
+	revoked, err := conn.queries.RevokeRoomInviteLink(r.Context(), db.RevokeRoomInviteLinkParams{
+		ID:     linkID,
+		RoomID: roomID,
+	})
+	if err != nil {
+		http.Error(w, "Failed to revoke invite link", http.StatusInternalServerError)
+		return
+	}
+	if revoked == 0 {
+		http.Error(w, "Invite link not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invite link revoked successfully"})
+}
+
+// Join a room with an invite link, new users can also pass invite_token to POST /api/signup
+func (conn ConnectionData) redeemRoomInviteLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req RedeemRoomInviteLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	joined, err := RedeemRoomInviteLink(r.Context(), conn.queries, req.Token, int32(userID))
+	if errors.Is(err, errInviteLinkNotFound) {
+		http.Error(w, "Invite link is invalid, expired or used up", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errAlreadyMember) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "You are already a member of this room",
+			"room_id": joined.RoomID,
+		})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to redeem invite link for user %d: %v\n", userID, err)
+		http.Error(w, "Failed to join room", http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("%s joined room %d as %s with an invite link\n", r.Header.Get("email"), joined.RoomID, joined.Role)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Joined room successfully",
+		"room_id": joined.RoomID,
+		"role":    joined.Role,
+	})
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// RoomMemberDTO represents a member or pending invitation of a room for JSON response
+type RoomMemberDTO struct {
+	UserID     int32  `json:"user_id"`
+	Email      string `json:"email"`
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	InvitedBy  int32  `json:"invited_by,omitempty"`
+	InvitedAt  string `json:"invited_at"`
+	AcceptedAt string `json:"accepted_at,omitempty"` // empty while the invitation is pending
+}
+
+// RoomInviteDTO represents an invitation of the signed in user for JSON response
+type RoomInviteDTO struct {
+	RoomID         int32  `json:"room_id"`
+	RoomName       string `json:"room_name"`
+	Role           string `json:"role"`
+	InvitedByEmail string `json:"invited_by_email,omitempty"`
+	InvitedAt      string `json:"invited_at"`
+}
+
+type InviteRoomMemberRequest struct {
+	RoomID int32  `json:"room_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+type UpdateRoomMemberRequest struct {
+	RoomID int32  `json:"room_id"`
+	UserID int32  `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// validMemberRole reports whether members can be given the role, a room has one owner
+func validMemberRole(role string) bool {
+	return role == RoomRoleEditor || role == RoomRoleCommenter || role == RoomRoleViewer
+}
+
+// queryID reads a positive integer query parameter, e.g. room_id
+func queryID(r *http.Request, name string) (int32, error) {
+	id, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return int32(id), nil
+}
+
+// writeManageRoomError answers a failed CanManageRoom check
+func writeManageRoomError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errForbidden) {
+		http.Error(w, "Only the owner can manage the members of this room", http.StatusForbidden)
+		return
+	}
+	writeAuthzError(w, err, "Room")
+}
+
+// sendRoomInviteEmail tells the invited user where to accept the invitation
+func (conn ConnectionData) sendRoomInviteEmail(ctx context.Context, email, inviterEmail, roomName, role string) error {
+	return conn.mailer.Send(ctx, Message{
+		To:      email,
+		Subject: "You were invited to a Steamed Notes room",
+		Body: inviterEmail + " invited you to the room \"" + roomName + "\" as " + role + ".\n\n" +
+			"Sign in to accept or decline the invitation:\n\n" +
+			appBaseURL() + "\n",
+	})
+}
+
+// sendSignUpInviteEmail asks someone without an account to sign up, the owner can invite them once they did
+func (conn ConnectionData) sendSignUpInviteEmail(ctx context.Context, email, inviterEmail, roomName string) error {
+	return conn.mailer.Send(ctx, Message{
+		To:      email,
+		Subject: "You were invited to Steamed Notes",
+		Body: inviterEmail + " wants to share the room \"" + roomName + "\" with you on Steamed Notes.\n\n" +
+			"Sign up with this email address, then ask them to invite you again:\n\n" +
+			appBaseURL() + "/signup\n",
+	})
+}
+
+// Get the members and pending invitations of a room, any member can see them
+func (conn ConnectionData) getRoomMembers(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	roomID, err := queryID(r, "room_id")
+	if err != nil {
+		http.Error(w, "Invalid room_id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := conn.authz.CanReadRoom(r.Context(), int32(userID), roomID); err != nil {
+		writeAuthzError(w, err, "Room")
+		return
+	}
+
+	members, err := conn.queries.ListRoomMembers(r.Context(), roomID)
+	if err != nil {
+		http.Error(w, "Failed to get members", http.StatusInternalServerError)
+		return
+	}
+
+	memberDTOs := make([]RoomMemberDTO, len(members))
+	for i, member := range members {
+		memberDTOs[i] = RoomMemberDTO{
+			UserID:    member.UserID,
+			Email:     member.Email,
+			Username:  member.Username,
+			Role:      member.Role,
+			InvitedBy: member.InvitedBy.Int32,
+			InvitedAt: member.InvitedAt.Time.Format(time.RFC3339),
+		}
+		if member.AcceptedAt.Valid {
+			memberDTOs[i].AcceptedAt = member.AcceptedAt.Time.Format(time.RFC3339)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(memberDTOs)
+}
+
+// Invite a user to a room by email, they get access once they accept
+func (conn ConnectionData) inviteRoomMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req InviteRoomMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+	if !validMemberRole(req.Role) {
+		http.Error(w, "Role must be editor, commenter or viewer", http.StatusBadRequest)
+		return
+	}
+
+	room, err := conn.authz.CanManageRoom(r.Context(), int32(userID), req.RoomID)
+	if err != nil {
+		writeManageRoomError(w, err)
+		return
+	}
+
+	inviterEmail := r.Header.Get("email")
+	invitee, err := conn.queries.FindUserByEmail(r.Context(), req.Email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Answered like an invitation so nobody learns which emails have an account,
+		// the email asks them to sign up instead
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := conn.sendSignUpInviteEmail(ctx, req.Email, inviterEmail, room.Name); err != nil {
+				fmt.Printf("Failed to send sign up invite email: %v\n", err)
+			}
+		}()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Invitation sent"})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to invite member", http.StatusInternalServerError)
+		return
+	}
+
+	invited, err := conn.queries.CreateRoomInvite(r.Context(), db.CreateRoomInviteParams{
+		RoomID:    room.ID,
+		UserID:    invitee.ID,
+		Role:      req.Role,
+		InvitedBy: pgtype.Int4{Int32: int32(userID), Valid: true},
+	})
+	if err != nil {
+		http.Error(w, "Failed to invite member", http.StatusInternalServerError)
+		return
+	}
+	if invited == 0 {
+		http.Error(w, "User is already a member or invited", http.StatusConflict)
+		return
+	}
+	fmt.Printf("%s invited %s to room %d as %s\n", inviterEmail, req.Email, room.ID, req.Role)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := conn.sendRoomInviteEmail(ctx, req.Email, inviterEmail, room.Name, req.Role); err != nil {
+			fmt.Printf("Failed to send room invite email: %v\n", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation sent"})
+}
+
+// Change the role of a member, the owner keeps theirs
+func (conn ConnectionData) updateRoomMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateRoomMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !validMemberRole(req.Role) {
+		http.Error(w, "Role must be editor, commenter or viewer", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := conn.authz.CanManageRoom(r.Context(), int32(userID), req.RoomID); err != nil {
+		writeManageRoomError(w, err)
+		return
+	}
+
+	updated, err := conn.queries.UpdateRoomMemberRole(r.Context(), db.UpdateRoomMemberRoleParams{
+		RoomID: req.RoomID,
+		UserID: req.UserID,
+		Role:   req.Role,
+	})
+	if err != nil {
+		http.Error(w, "Failed to update member", http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	// A viewer can't keep editing with the write access checked before
+	conn.collab.RecheckRoom(req.UserID, req.RoomID)
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member updated successfully"})
+}
+
+// Remove a member or cancel an invitation. Members can also remove themselves to leave
+// the room or decline an invitation, the owner can't be removed.
+func (conn ConnectionData) deleteRoomMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	roomID, err := queryID(r, "room_id")
+	if err != nil {
+		http.Error(w, "Invalid room_id", http.StatusBadRequest)
+		return
+	}
+	memberID, err := queryID(r, "user_id")
+	if err != nil {
+		http.Error(w, "Invalid user_id", http.StatusBadRequest)
+		return
+	}
+
+	if memberID != int32(userID) {
+		if _, err := conn.authz.CanManageRoom(r.Context(), int32(userID), roomID); err != nil {
+			writeManageRoomError(w, err)
+			return
+		}
+	}
+
+	deleted, err := conn.queries.DeleteRoomMember(r.Context(), db.DeleteRoomMemberParams{
+		RoomID: roomID,
+		UserID: memberID,
+	})
+	if err != nil {
+		http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	// Their open connections stop getting events of the room and editing its notes
+	conn.hub.LeaveRoom(memberID, roomID)
+	conn.collab.LeaveRoom(memberID, roomID)
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member removed successfully"})
+}
+
+// Get the invitations of the signed in user that wait for an answer
+func (conn ConnectionData) getRoomInvites(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	invites, err := conn.queries.ListRoomInvitesForUser(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to get invitations", http.StatusInternalServerError)
+		return
+	}
+
+	inviteDTOs := make([]RoomInviteDTO, len(invites))
+	for i, invite := range invites {
+		inviteDTOs[i] = RoomInviteDTO{
+			RoomID:         invite.RoomID,
+			RoomName:       invite.RoomName,
+			Role:           invite.Role,
+			InvitedByEmail: invite.InvitedByEmail.String,
+			InvitedAt:      invite.InvitedAt.Time.Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inviteDTOs)
+}
+
+// Accept an invitation, the room then shows up in GET /api/rooms/get
+func (conn ConnectionData) acceptRoomInvite(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	roomID, err := queryID(r, "room_id")
+	if err != nil {
+		http.Error(w, "Invalid room_id", http.StatusBadRequest)
+		return
+	}
+
+	accepted, err := conn.queries.AcceptRoomInvite(r.Context(), db.AcceptRoomInviteParams{
+		RoomID: roomID,
+		UserID: int32(userID),
+	})
+	if err != nil {
+		http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		return
+	}
+	if accepted == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation accepted"})
+}
@@ -3,6 +3,19 @@ export DB_PORT=5432
 export DB_USER=steamed_user
 export DB_PASSWORD=steamed_password
 export DB_NAME=steamed_notes
+export DB_SSLMODE=disable
+export JWT_SECRET=local-dev-secret-do-not-use-in-production
+export APP_BASE_URL=http://localhost
+export TRUSTED_PROXIES=127.0.0.1,::1
+export MAIL_DRIVER=smtp
+export SMTP_HOST=localhost
+export SMTP_PORT=1025
+export WEBAUTHN_RP_ID=localhost
+export OIDC_PROVIDERS=mock
+export OIDC_MOCK_DISPLAY_NAME="Mock OIDC"
+export OIDC_MOCK_ISSUER=http://localhost:8090/default
+export OIDC_MOCK_CLIENT_ID=steamednotes
+export OIDC_MOCK_CLIENT_SECRET=steamednotes
 
 sqlc generate
 
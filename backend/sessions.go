@@ -4,8 +4,8 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
 	"net/netip"
 	"regexp"
@@ -149,43 +149,42 @@ func GenerateSessionToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-// GetClientIP extracts the real client IP address
-func GetClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first (for reverse proxies)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
-	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-
-	// Fall back to RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
+// SessionAuth describes how the user authenticated when the session was created
+type SessionAuth struct {
+	Method   string // password, passkey, oidc or impersonation, defaults to password
+	Provider string // OIDC provider name
+	MFAUsed  bool
+	// The admin who opened the session as the user, see impersonation.go
+	ImpersonatorID    int32
+	ImpersonatorEmail string
 }
 
 // CreateSession creates a new user session in the database
-func CreateSession(ctx context.Context, queries *db.Queries, userID int32, r *http.Request) (db.UserSession, error) {
+func CreateSession(ctx context.Context, queries *db.Queries, userID int32, r *http.Request, auth SessionAuth) (db.UserSession, error) {
 	// Generate session token
 	token, err := GenerateSessionToken()
 	if err != nil {
 		return db.UserSession{}, err
 	}
 
+	method := auth.Method
+	if method == "" {
+		method = "password"
+	}
+
+	// Impersonation sessions are short and never renewed
+	ttl := appConfig.Session.TTL
+	if auth.ImpersonatorID != 0 {
+		ttl = appConfig.Session.ImpersonationTTL
+	}
+
 	// Parse user agent
 	deviceInfo := ParseUserAgent(r.Header.Get("User-Agent"))
 
-	// Get client IP
-	clientIP := GetClientIP(r)
+	// Get client IP, resolved through the trusted proxies
 	var ipAddr *netip.Addr
-	if parsedIP, err := netip.ParseAddr(clientIP); err == nil {
-		ipAddr = &parsedIP
+	if clientIP, ok := ClientIP(r); ok {
+		ipAddr = &clientIP
 	}
 
 	// Create session
@@ -200,19 +199,88 @@ func CreateSession(ctx context.Context, queries *db.Queries, userID int32, r *ht
 		OsVersion:      pgtype.Text{String: deviceInfo.OSVersion, Valid: deviceInfo.OSVersion != ""},
 		IpAddress:      ipAddr,
 		UserAgent:      pgtype.Text{String: r.Header.Get("User-Agent"), Valid: true},
-		ExpiresAt:      pgtype.Timestamp{Time: time.Now().Add(7 * 24 * time.Hour), Valid: true},
+		ExpiresAt:      pgtype.Timestamp{Time: time.Now().Add(ttl), Valid: true},
+		MfaUsed:        auth.MFAUsed,
+		AuthMethod:     method,
+		AuthProvider:   pgtype.Text{String: auth.Provider, Valid: auth.Provider != ""},
+		ImpersonatorID: pgtype.Int4{Int32: auth.ImpersonatorID, Valid: auth.ImpersonatorID != 0},
 	})
 }
 
-// ValidateAndUpdateSession checks if a session is valid and updates its last used time
+// errAccountDisabled is returned for users an admin disabled, they can't get a session
+var errAccountDisabled = errors.New("account is disabled")
+
+// CheckUserEnabled returns errAccountDisabled when the user's account is disabled
+func CheckUserEnabled(ctx context.Context, queries *db.Queries, userID int32) error {
+	disabledAt, err := queries.GetUserDisabledAt(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if disabledAt.Valid {
+		return errAccountDisabled
+	}
+	return nil
+}
+
+// IssueSessionCookie creates a session for the user and sets the signed JWT cookie on the response
+func IssueSessionCookie(w http.ResponseWriter, r *http.Request, queries *db.Queries, userID int32, email string, auth SessionAuth) (db.UserSession, error) {
+	if err := CheckUserEnabled(r.Context(), queries, userID); err != nil {
+		return db.UserSession{}, err
+	}
+
+	session, err := CreateSession(r.Context(), queries, userID, r, auth)
+	if err != nil {
+		return db.UserSession{}, err
+	}
+	fmt.Printf("Session created successfully with ID %d\n", session.ID)
+
+	// Create JWT with session ID
+	tokenString, err := keyring.Sign(Claims{
+		Email:             email,
+		ID:                strconv.Itoa(int(userID)),
+		SessionID:         strconv.Itoa(int(session.ID)),
+		ImpersonatorID:    impersonatorClaim(auth.ImpersonatorID),
+		ImpersonatorEmail: auth.ImpersonatorEmail,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt.Time),
+		},
+	})
+	if err != nil {
+		return db.UserSession{}, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    tokenString,
+		Expires:  session.ExpiresAt.Time, // Match session expiry
+		HttpOnly: true,                   // Prevent JS access
+		Path:     "/",
+		Domain:   appConfig.Cookie.Domain,
+		Secure:   appConfig.Cookie.Secure,
+		SameSite: appConfig.Cookie.SameSiteMode(),
+	})
+
+	return session, nil
+}
+
+// writeSessionError answers a failed IssueSessionCookie
+func writeSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAccountDisabled) {
+		http.Error(w, "This account is disabled", http.StatusForbidden)
+		return
+	}
+	fmt.Printf("Failed to create session: %v\n", err)
+	http.Error(w, "Failed to create session", http.StatusInternalServerError)
+}
+
+// ValidateAndUpdateSession checks if a session is valid and updates its last used time,
+// callers compare the returned UserID with the id in the token
 func ValidateAndUpdateSession(ctx context.Context, queries *db.Queries, token string) (db.UserSession, error) {
 	fmt.Printf("Validating session with token: %s\n", token[:min(len(token), 20)]+"...")
 
 	// Parse JWT to get session ID
 	claims := &Claims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return secret, nil
-	})
+	parsedToken, err := jwt.ParseWithClaims(token, claims, keyring.Keyfunc)
 	if err != nil {
 		fmt.Printf("JWT parsing error: %v\n", err)
 		return db.UserSession{}, err
@@ -248,14 +316,17 @@ func ValidateAndUpdateSession(ctx context.Context, queries *db.Queries, token st
 		return db.UserSession{}, http.ErrNoCookie
 	}
 
-	if time.Until(session.ExpiresAt.Time) < (time.Hour * 24 * 5) {
-		updatedSessionid, err := queries.UpdateSessionLastUsedAndExpiry(ctx, session.ID)
+	if time.Until(session.ExpiresAt.Time) < appConfig.Session.RenewThreshold && !session.ImpersonatorID.Valid {
+		updatedSessionid, err := queries.UpdateSessionLastUsedAndExpiry(ctx, db.UpdateSessionLastUsedAndExpiryParams{
+			ID:        session.ID,
+			ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(appConfig.Session.TTL), Valid: true},
+		})
 		if err != nil {
 			fmt.Printf("Session update error: %v\n", err)
 			return db.UserSession{}, err
 		}
 		fmt.Printf("Session validated and updated successfully\n")
-		return db.UserSession{ID: updatedSessionid}, nil
+		return db.UserSession{ID: updatedSessionid, UserID: session.UserID, ImpersonatorID: session.ImpersonatorID}, nil
 	}
 
 	// Update last used time and extend if needed
@@ -266,7 +337,7 @@ func ValidateAndUpdateSession(ctx context.Context, queries *db.Queries, token st
 	}
 
 	fmt.Printf("Session validated and updated successfully\n")
-	return db.UserSession{ID: updatedSessionid}, nil
+	return db.UserSession{ID: updatedSessionid, UserID: session.UserID, ImpersonatorID: session.ImpersonatorID}, nil
 }
 
 func min(a, b int) int {
@@ -297,7 +368,24 @@ func DeleteAllOtherSessions(ctx context.Context, queries *db.Queries, currentSes
 	})
 }
 
-// CleanupExpiredSessions removes all expired sessions
+// DeleteAllSessions removes every session of the user, e.g. after a password reset
+func DeleteAllSessions(ctx context.Context, queries *db.Queries, userID int32) error {
+	return queries.DeleteAllSessionsForUser(ctx, userID)
+}
+
+// CleanupExpiredSessions removes all expired sessions and abandoned passkey ceremonies
 func CleanupExpiredSessions(ctx context.Context, queries *db.Queries) error {
+	if err := queries.CleanupExpiredWebAuthnCeremonies(ctx); err != nil {
+		return err
+	}
+	if err := RetireReplacedJWTSigningKeys(ctx, queries); err != nil {
+		return err
+	}
+	if err := CleanupSignInAttempts(ctx, queries); err != nil {
+		return err
+	}
+	if err := CleanupRateLimitBuckets(ctx, queries); err != nil {
+		return err
+	}
 	return queries.CleanupExpiredSessions(ctx)
 }
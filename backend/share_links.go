@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	maxShareLinkDays            = 366
+	maxShareLinkPassword        = 256
+	shareLinkPasswordHeader     = "X-Share-Password" // sent by viewers of password protected links
+	shareLinkTypeNote           = "note"
+	shareLinkTypeFolder         = "folder"
+	publicContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+)
+
+type CreateShareLinkRequest struct {
+	NoteID        int32  `json:"note_id"`         // either note_id or folder_id
+	FolderID      int32  `json:"folder_id"`       // every note of the folder, also the ones added later
+	Password      string `json:"password"`        // optional
+	ExpiresInDays int    `json:"expires_in_days"` // 0 never expires
+}
+
+// ShareLinkDTO represents a share link for JSON response, never includes the token itself
+type ShareLinkDTO struct {
+	ID           int32  `json:"id"`
+	NoteID       int32  `json:"note_id,omitempty"`
+	NoteTitle    string `json:"note_title,omitempty"`
+	FolderID     int32  `json:"folder_id,omitempty"`
+	FolderName   string `json:"folder_name,omitempty"`
+	HasPassword  bool   `json:"has_password"`
+	ViewCount    int32  `json:"view_count"`
+	LastViewedAt string `json:"last_viewed_at,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+}
+
+// PublicNoteDTO is what viewers of a share link see of a note, nothing that identifies users or rooms
+type PublicNoteDTO struct {
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+type PublicFolderDTO struct {
+	Name  string          `json:"name"`
+	Notes []PublicNoteDTO `json:"notes"`
+}
+
+// PublicShareDTO is the answer of GET /api/public/{token}, Note or Folder is set depending on Type
+type PublicShareDTO struct {
+	Type   string           `json:"type"`
+	Note   *PublicNoteDTO   `json:"note,omitempty"`
+	Folder *PublicFolderDTO `json:"folder,omitempty"`
+}
+
+func newShareLinkDTO(link db.ListActiveShareLinksByUserRow) ShareLinkDTO {
+	dto := ShareLinkDTO{
+		ID:          link.ID,
+		NoteID:      link.NoteID.Int32,
+		NoteTitle:   link.NoteTitle.String,
+		FolderID:    link.FolderID.Int32,
+		FolderName:  link.FolderName.String,
+		HasPassword: link.HasPassword,
+		ViewCount:   link.ViewCount,
+		CreatedAt:   link.CreatedAt.Time.Format(time.RFC3339),
+	}
+	if link.LastViewedAt.Valid {
+		dto.LastViewedAt = link.LastViewedAt.Time.Format(time.RFC3339)
+	}
+	if link.ExpiresAt.Valid {
+		dto.ExpiresAt = link.ExpiresAt.Time.Format(time.RFC3339)
+	}
+	return dto
+}
+
+// sanitizePublicText drops invalid UTF-8, control characters other than tabs and newlines,
+// and the bidi controls that can make text read differently than it is stored
+func sanitizePublicText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(text, "\uFFFD"))
+}
+
+func newPublicNoteDTO(title, content string, createdAt pgtype.Timestamp) PublicNoteDTO {
+	return PublicNoteDTO{
+		Title:     sanitizePublicText(title),
+		Content:   sanitizePublicText(content),
+		CreatedAt: createdAt.Time.Format(time.RFC3339),
+	}
+}
+
+// writePublicShareError answers a share link whose note or folder is gone or no longer
+// readable by its creator the same way as an unknown token
+func writePublicShareError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) || errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Failed to open share link: %v\n", err)
+	http.Error(w, "Failed to open share link", http.StatusInternalServerError)
+}
+
+// Create a share link to a note or a folder, the token is only returned once.
+// Only users who can write the note or folder can share it.
+func (conn ConnectionData) createShareLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateShareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if (req.NoteID == 0) == (req.FolderID == 0) {
+		http.Error(w, "Either note_id or folder_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxShareLinkDays {
+		http.Error(w, fmt.Sprintf("expires_in_days must be between 0 and %d", maxShareLinkDays), http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) > maxShareLinkPassword {
+		http.Error(w, fmt.Sprintf("Password must be at most %d characters", maxShareLinkPassword), http.StatusBadRequest)
+		return
+	}
+
+	dto := ShareLinkDTO{HasPassword: req.Password != ""}
+	params := db.CreateShareLinkParams{UserID: int32(userID)}
+	if req.NoteID != 0 {
+		note, err := conn.authz.CanWriteNote(r.Context(), int32(userID), req.NoteID)
+		if err != nil {
+			writeAuthzError(w, err, "Note")
+			return
+		}
+		params.NoteID = pgtype.Int4{Int32: note.ID, Valid: true}
+		dto.NoteID, dto.NoteTitle = note.ID, note.Title
+	} else {
+		folder, err := conn.authz.CanWriteFolder(r.Context(), int32(userID), req.FolderID)
+		if err != nil {
+			writeAuthzError(w, err, "Folder")
+			return
+		}
+		params.FolderID = pgtype.Int4{Int32: folder.ID, Valid: true}
+		dto.FolderID, dto.FolderName = folder.ID, folder.Name
+	}
+
+	if req.Password != "" {
+		passwordHash, err := HashPassword(req.Password)
+		if err != nil {
+			http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+			return
+		}
+		params.PasswordHash = pgtype.Text{String: passwordHash, Valid: true}
+	}
+	if req.ExpiresInDays > 0 {
+		params.ExpiresAt = pgtype.Timestamp{Time: time.Now().AddDate(0, 0, req.ExpiresInDays), Valid: true}
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	params.TokenHash = HashToken(token)
+
+	link, err := conn.queries.CreateShareLink(r.Context(), params)
+	if err != nil {
+		fmt.Printf("Failed to create share link for user %d: %v\n", userID, err)
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	dto.ID = link.ID
+	dto.CreatedAt = link.CreatedAt.Time.Format(time.RFC3339)
+	if link.ExpiresAt.Valid {
+		dto.ExpiresAt = link.ExpiresAt.Time.Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ShareLinkDTO
+		Token string `json:"token"`
+	}{dto, token})
+}
+
+// Get the current user's share links that are neither revoked nor expired
+func (conn ConnectionData) getShareLinks(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	links, err := conn.queries.ListActiveShareLinksByUser(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to get share links", http.StatusInternalServerError)
+		return
+	}
+
+	linkDTOs := make([]ShareLinkDTO, len(links))
+	for i, link := range links {
+		linkDTOs[i] = newShareLinkDTO(link)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(linkDTOs)
+}
+
+// Revoke one of the current user's share links, it answers 404 from then on
+func (conn ConnectionData) revokeShareLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	linkID, err := queryID(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := conn.queries.RevokeShareLink(r.Context(), db.RevokeShareLinkParams{
+		ID:     linkID,
+		UserID: int32(userID),
+	})
+	if err != nil {
+		http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+		return
+	}
+	if revoked == 0 {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Share link revoked successfully"})
+}
+
+// Get the note or folder of a share link without an account. Password protected links
+// need the password in the X-Share-Password header. The link stops working when its
+// creator can't read the note or folder anymore.
+func (conn ConnectionData) getPublicShare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", publicContentSecurityPolicy)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	link, err := conn.queries.FindShareLinkByHash(r.Context(), HashToken(r.PathValue("token")))
+	if err != nil {
+		writePublicShareError(w, err)
+		return
+	}
+
+	if link.PasswordHash.Valid {
+		password := r.Header.Get(shareLinkPasswordHeader)
+		if password == "" {
+			http.Error(w, "Password required", http.StatusUnauthorized)
+			return
+		}
+		if match, _ := VerifyPassword(link.PasswordHash.String, password); !match {
+			http.Error(w, "Wrong password", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var share PublicShareDTO
+	if link.NoteID.Valid {
+		note, err := conn.authz.CanReadNote(r.Context(), link.UserID, link.NoteID.Int32)
+		if err != nil {
+			writePublicShareError(w, err)
+			return
+		}
+		publicNote := newPublicNoteDTO(note.Title, note.Content, note.CreatedAt)
+		share = PublicShareDTO{Type: shareLinkTypeNote, Note: &publicNote}
+	} else {
+		folder, err := conn.authz.CanReadFolder(r.Context(), link.UserID, link.FolderID.Int32)
+		if err != nil {
+			writePublicShareError(w, err)
+			return
+		}
+		notes, err := conn.queries.FindNoteContentsByFolder(r.Context(), folder.ID)
+		if err != nil {
+			writePublicShareError(w, err)
+			return
+		}
+		publicFolder := PublicFolderDTO{Name: sanitizePublicText(folder.Name), Notes: make([]PublicNoteDTO, len(notes))}
+		for i, note := range notes {
+			publicFolder.Notes[i] = newPublicNoteDTO(note.Title, note.Content, note.CreatedAt)
+		}
+		share = PublicShareDTO{Type: shareLinkTypeFolder, Folder: &publicFolder}
+	}
+
+	if err := conn.queries.RecordShareLinkView(r.Context(), link.ID); err != nil {
+		fmt.Printf("Failed to count view of share link %d: %v\n", link.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(share)
+}
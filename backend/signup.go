@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	maxUsernameLength = 50 // users.username is VARCHAR(50)
+	minUsernameLength = 3
+	maxEmailLength    = 254
+	minPasswordLength = 8
+	maxPasswordLength = 128
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// SignupRequest is the payload for self-service registration
+type SignupRequest struct {
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	SignIn      bool   `json:"sign_in"`      // issue a session cookie right away
+	InviteToken string `json:"invite_token"` // optional room invite link to join once the account exists
+}
+
+// ValidateUsername checks the charset and length of a username
+func ValidateUsername(username string) error {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters", minUsernameLength, maxUsernameLength)
+	}
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username may only contain letters, digits, '_', '.' and '-'")
+	}
+	return nil
+}
+
+// ValidateEmail checks that the email is a bare, syntactically valid address
+func ValidateEmail(email string) error {
+	if email == "" || len(email) > maxEmailLength {
+		return errors.New("email is invalid")
+	}
+	addr, err := mail.ParseAddress(email)
+	// Reject display names like "Bob <bob@example.com>"
+	if err != nil || addr.Address != email {
+		return errors.New("email is invalid")
+	}
+	return nil
+}
+
+// ValidatePassword enforces the password policy
+func ValidatePassword(password string, identifiers ...string) error {
+	length := utf8.RuneCountInString(password)
+	if length < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	if length > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d characters", maxPasswordLength)
+	}
+
+	var hasLetter, hasOther bool
+	for _, c := range password {
+		if unicode.IsLetter(c) {
+			hasLetter = true
+		} else {
+			hasOther = true
+		}
+	}
+	if !hasLetter || !hasOther {
+		return errors.New("password must contain letters and at least one digit or symbol")
+	}
+
+	for _, identifier := range identifiers {
+		if identifier != "" && strings.EqualFold(password, identifier) {
+			return errors.New("password must not match your username or email")
+		}
+	}
+	return nil
+}
+
+// createUserError maps CreateUser errors to a status code and message
+func createUserError(err error) (int, string) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "users_username_key":
+			return http.StatusConflict, "Username is already taken"
+		case "users_email_key":
+			return http.StatusConflict, "Email is already registered"
+		default:
+			return http.StatusConflict, "User already exists"
+		}
+	}
+	return http.StatusInternalServerError, "Failed to create user"
+}
+
+// Sign up a new user
+func (conn ConnectionData) signUp(w http.ResponseWriter, r *http.Request) {
+	if !appConfig.Features.Signup {
+		http.Error(w, "Sign up is disabled", http.StatusForbidden)
+		return
+	}
+
+	var req SignupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+
+	if err := ValidateUsername(req.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ValidateEmail(req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ValidatePassword(req.Password, req.Username, req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := conn.queries.CreateUser(r.Context(), db.CreateUserParams{
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: hash,
+	})
+	if err != nil {
+		fmt.Printf("Error creating user: %v\n", err)
+		status, message := createUserError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	fmt.Printf("Created user %s with ID %d\n", user.Email, user.ID)
+
+	if err := conn.SendVerificationEmail(r.Context(), user.ID, user.Email); err != nil {
+		// Not fatal, the user can request another email later
+		fmt.Printf("Failed to send verification email to user %d: %v\n", user.ID, err)
+	}
+
+	res := map[string]string{
+		"id":       strconv.Itoa(int(user.ID)),
+		"username": user.Username,
+		"email":    user.Email,
+	}
+
+	if req.InviteToken != "" {
+		joined, err := RedeemRoomInviteLink(r.Context(), conn.queries, req.InviteToken, user.ID)
+		if err != nil {
+			// Not fatal either, the user can still get another link
+			fmt.Printf("Failed to redeem invite link for user %d: %v\n", user.ID, err)
+		} else {
+			res["room_id"] = strconv.Itoa(int(joined.RoomID))
+		}
+	}
+
+	if req.SignIn {
+		session, err := IssueSessionCookie(w, r, conn.queries, user.ID, user.Email, SessionAuth{})
+		if err != nil {
+			// The account exists, the user can still sign in normally
+			fmt.Printf("Failed to create session after signup: %v\n", err)
+		} else {
+			res["session_id"] = strconv.Itoa(int(session.ID))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashToken returns the sha256 of a single-use token, only the hash is stored in the db
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
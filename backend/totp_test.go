@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The RFC 6238 SHA1 secret "12345678901234567890", base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test vectors of RFC 6238 appendix B, the last 6 of their 8 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, test := range tests {
+		if code := totpCode(key, totpStep(time.Unix(test.unix, 0))); code != test.want {
+			t.Errorf("code at %d = %s, want %s", test.unix, code, test.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	// 1111111111 is step 37037037, whose code is 050471
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		secret       string
+		code         string
+		lastUsedStep int64
+		wantStep     int64
+		wantOK       bool
+	}{
+		{name: "current step", secret: rfc6238Secret, code: "050471", wantStep: step, wantOK: true},
+		{name: "spaces", secret: rfc6238Secret, code: " 050 471 ", wantStep: step, wantOK: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: "050471", wantStep: step, wantOK: true},
+		{name: "previous step", secret: rfc6238Secret, code: totpCode(key, step-1), wantStep: step - 1, wantOK: true},
+		{name: "next step", secret: rfc6238Secret, code: totpCode(key, step+1), wantStep: step + 1, wantOK: true},
+		{name: "two steps back", secret: rfc6238Secret, code: totpCode(key, step-2)},
+		{name: "two steps ahead", secret: rfc6238Secret, code: totpCode(key, step+2)},
+		{name: "replayed", secret: rfc6238Secret, code: "050471", lastUsedStep: step},
+		{name: "older than a used step", secret: rfc6238Secret, code: totpCode(key, step-1), lastUsedStep: step},
+		{name: "later than a used step", secret: rfc6238Secret, code: totpCode(key, step+1), lastUsedStep: step, wantStep: step + 1, wantOK: true},
+		{name: "wrong code", secret: rfc6238Secret, code: "123456"},
+		{name: "too short", secret: rfc6238Secret, code: "05047"},
+		{name: "too long", secret: rfc6238Secret, code: "0504710"},
+		{name: "bad secret", secret: "not base32!", code: "050471"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(test.secret, test.code, now, test.lastUsedStep)
+			if ok != test.wantOK || gotStep != test.wantStep {
+				t.Errorf("ValidateTOTP() = %d, %v, want %d, %v", gotStep, ok, test.wantStep, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"steamednotes/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	mfaPendingAudience  = "mfa_pending"
+	mfaPendingTTL       = 5 * time.Minute
+	recoveryCodeCount   = 10
+	recoveryCodeCharset = "abcdefghjkmnpqrstuvwxyz23456789" // no look-alike characters
+)
+
+// MFAPendingClaims is the short-lived token returned by signIn when a second factor is needed
+type MFAPendingClaims struct {
+	Email    string `json:"email"`
+	ID       string `json:"id"`
+	Method   string `json:"method,omitempty"`   // how the first step was done, see SessionAuth
+	Provider string `json:"provider,omitempty"` // OIDC provider name
+	jwt.RegisteredClaims
+}
+
+// NewMFAPendingToken signs a token proving the first sign in step succeeded
+func NewMFAPendingToken(userID int32, email string, auth SessionAuth) (string, error) {
+	return keyring.Sign(MFAPendingClaims{
+		Email:    email,
+		ID:       strconv.Itoa(int(userID)),
+		Method:   auth.Method,
+		Provider: auth.Provider,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{mfaPendingAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaPendingTTL)),
+		},
+	})
+}
+
+// parseMFAPendingToken validates the token and returns its claims
+func parseMFAPendingToken(tokenString string) (*MFAPendingClaims, error) {
+	claims := &MFAPendingClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyring.Keyfunc, jwt.WithAudience(mfaPendingAudience))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// generateRecoveryCodes creates fresh recovery codes, replacing the old ones, and returns them in clear.
+// They are stored hashed like passwords, each with its own salt.
+func generateRecoveryCodes(ctx context.Context, queries *db.Queries, userID int32) ([]string, error) {
+	if err := queries.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+
+		hash, err := HashPassword(normalizeRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+		err = queries.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hash,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// randomRecoveryCode draws 10 characters of the charset, bytes past the last whole multiple
+// of its length are drawn again so every character is as likely
+func randomRecoveryCode() (string, error) {
+	limit := 256 - 256%len(recoveryCodeCharset)
+	code := make([]byte, 0, 10)
+	buf := make([]byte, 16)
+	for len(code) < cap(code) {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(code) < cap(code) {
+				code = append(code, recoveryCodeCharset[int(b)%len(recoveryCodeCharset)])
+			}
+		}
+	}
+	return string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// recoveryCodeMatches checks a normalized code against its stored hash
+func recoveryCodeMatches(stored, code string) bool {
+	if IsPasswordHashed(stored) {
+		match, _ := VerifyPassword(stored, code)
+		return match
+	}
+	// Codes generated before V025 are a bare sha256
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(HashToken(code))) == 1
+}
+
+// useRecoveryCode marks the matching unused recovery code used, a code works once
+func useRecoveryCode(ctx context.Context, queries *db.Queries, userID int32, recoveryCode string) bool {
+	code := normalizeRecoveryCode(recoveryCode)
+	if code == "" {
+		return false
+	}
+	codes, err := queries.GetUnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		return false
+	}
+	for _, stored := range codes {
+		if !recoveryCodeMatches(stored.CodeHash, code) {
+			continue
+		}
+		used, err := queries.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{
+			ID:     stored.ID,
+			UserID: userID,
+		})
+		return err == nil && used == 1
+	}
+	return false
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code
+func verifySecondFactor(ctx context.Context, queries *db.Queries, userID int32, code, recoveryCode string) bool {
+	if recoveryCode != "" {
+		return useRecoveryCode(ctx, queries, userID, recoveryCode)
+	}
+
+	totp, err := queries.GetTOTPByUser(ctx, userID)
+	if err != nil || !totp.EnabledAt.Valid {
+		return false
+	}
+
+	step, ok := ValidateTOTP(totp.Secret, code, time.Now(), totp.LastUsedStep)
+	if !ok {
+		return false
+	}
+
+	// Record the step atomically so the same code can't be used twice
+	updated, err := queries.UpdateTOTPLastUsedStep(ctx, db.UpdateTOTPLastUsedStepParams{
+		UserID:       userID,
+		LastUsedStep: step,
+	})
+	return err == nil && updated == 1
+}
+
+type MFASignInRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Second sign in step, exchanges the mfa pending token and a code for a session
+func (conn ConnectionData) signInMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFASignInRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := parseMFAPendingToken(req.MFAToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired sign in, please sign in again", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.ID)
+	if err != nil {
+		http.Error(w, "Invalid or expired sign in, please sign in again", http.StatusUnauthorized)
+		return
+	}
+
+	// Codes count towards the same lockout as passwords
+	if wait := signInLockedFor(r.Context(), conn.queries, claims.Email, GetClientIP(r)); wait > 0 {
+		recordSignInAttempt(r.Context(), conn.queries, r, claims.Email, int32(userID), "locked")
+		writeSignInLocked(w, wait)
+		return
+	}
+
+	if !verifySecondFactor(r.Context(), conn.queries, int32(userID), req.Code, req.RecoveryCode) {
+		recordSignInFailure(r.Context(), conn.queries, r, claims.Email, int32(userID), "bad_code")
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := conn.queries.FindUserById(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := IssueSessionCookie(w, r, conn.queries, user.ID, claims.Email, SessionAuth{
+		Method:   claims.Method,
+		Provider: claims.Provider,
+		MFAUsed:  true,
+	})
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	recordSignInSuccess(r.Context(), conn.queries, r, claims.Email, user.ID)
+
+	fmt.Printf("Signed in as %s with session %d using 2FA\n", claims.Email, session.ID)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"username":   user.Username,
+		"session_id": strconv.Itoa(int(session.ID)),
+	})
+}
+
+// Get whether 2FA is enabled for the current user
+func (conn ConnectionData) getTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	enabled := false
+	if totp, err := conn.queries.GetTOTPByUser(r.Context(), int32(userID)); err == nil {
+		enabled = totp.EnabledAt.Valid
+	}
+
+	remaining, err := conn.queries.CountUnusedRecoveryCodes(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to get 2FA status", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// Start 2FA enrollment, returns a new secret that must be confirmed with /api/2fa/enable
+func (conn ConnectionData) setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if totp, err := conn.queries.GetTOTPByUser(r.Context(), int32(userID)); err == nil && totp.EnabledAt.Valid {
+		http.Error(w, "2FA is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	err = conn.queries.UpsertTOTPSecret(r.Context(), db.UpsertTOTPSecretParams{
+		UserID: int32(userID),
+		Secret: secret,
+	})
+	if err != nil {
+		http.Error(w, "Failed to store secret", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": TOTPURI(secret, r.Header.Get("email")),
+	})
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// Confirm enrollment with a first code, returns the recovery codes once
+func (conn ConnectionData) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	totp, err := conn.queries.GetTOTPByUser(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "Call /api/2fa/setup first", http.StatusBadRequest)
+		return
+	}
+	if totp.EnabledAt.Valid {
+		http.Error(w, "2FA is already enabled", http.StatusConflict)
+		return
+	}
+
+	step, ok := ValidateTOTP(totp.Secret, req.Code, time.Now(), totp.LastUsedStep)
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	err = conn.queries.EnableTOTP(r.Context(), db.EnableTOTPParams{
+		UserID:       int32(userID),
+		LastUsedStep: step,
+	})
+	if err != nil {
+		http.Error(w, "Failed to enable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := generateRecoveryCodes(r.Context(), conn.queries, int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "2FA enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// Replace the recovery codes, requires a current TOTP code
+func (conn ConnectionData) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !verifySecondFactor(r.Context(), conn.queries, int32(userID), req.Code, "") {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := generateRecoveryCodes(r.Context(), conn.queries, int32(userID))
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Turn 2FA off, requires the password and a second factor again
+func (conn ConnectionData) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req DisableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := conn.queries.FindUserById(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if match, _ := VerifyPassword(user.PasswordHash, req.Password); !match {
+		http.Error(w, "Password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	if !verifySecondFactor(r.Context(), conn.queries, user.ID, req.Code, req.RecoveryCode) {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := conn.queries.DeleteTOTP(r.Context(), user.ID); err != nil {
+		http.Error(w, "Failed to disable 2FA", http.StatusInternalServerError)
+		return
+	}
+	if err := conn.queries.DeleteRecoveryCodes(r.Context(), user.ID); err != nil {
+		fmt.Printf("Failed to delete recovery codes for user %d: %v\n", user.ID, err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "2FA disabled"})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"steamednotes/db"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+// appBaseURL is where links in emails point to
+func appBaseURL() string {
+	return appConfig.AppBaseURL
+}
+
+// SendVerificationEmail replaces any pending verification token and emails a new link
+func (conn ConnectionData) SendVerificationEmail(ctx context.Context, userID int32, email string) error {
+	if err := conn.queries.DeleteUnusedEmailVerifications(ctx, userID); err != nil {
+		return err
+	}
+
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return err
+	}
+
+	err = conn.queries.CreateEmailVerification(ctx, db.CreateEmailVerificationParams{
+		UserID:    userID,
+		Email:     email,
+		TokenHash: HashToken(token),
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(emailVerificationTTL), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL() + "/api/verify-email/confirm?token=" + url.QueryEscape(token)
+
+	return conn.mailer.Send(ctx, Message{
+		To:      email,
+		Subject: "Confirm your email for Steamed Notes",
+		Body: "Welcome to Steamed Notes!\n\n" +
+			"Please confirm your email address by opening the link below:\n\n" +
+			link + "\n\n" +
+			"The link expires in 24 hours. If you did not sign up, you can ignore this email.\n",
+	})
+}
+
+// Send a new verification email to the signed-in user
+func (conn ConnectionData) requestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := conn.queries.FindUserById(r.Context(), int32(userID))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	verifiedAt, err := conn.queries.GetUserEmailVerifiedAt(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check email verification", http.StatusInternalServerError)
+		return
+	}
+	if verifiedAt.Valid {
+		http.Error(w, "Email is already verified", http.StatusConflict)
+		return
+	}
+
+	if err := conn.SendVerificationEmail(r.Context(), user.ID, user.Email); err != nil {
+		fmt.Printf("Failed to send verification email to user %d: %v\n", user.ID, err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
+
+// Confirm an email with the token from the emailed link
+func (conn ConnectionData) confirmEmailVerification(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Missing token parameter", http.StatusBadRequest)
+		return
+	}
+
+	verification, err := conn.queries.ConsumeEmailVerification(r.Context(), HashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	// Only verifies the address the link was sent to
+	err = conn.queries.MarkUserEmailVerified(r.Context(), db.MarkUserEmailVerifiedParams{
+		ID:    verification.UserID,
+		Email: verification.Email,
+	})
+	if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified successfully"})
+}
+
+// requireVerifiedEmail blocks users with unverified emails when the require_email_verification feature is on,
+// it expects to run inside authMiddleware
+func (connData ConnectionData) requireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !appConfig.Features.RequireEmailVerification {
+			next(w, r)
+			return
+		}
+
+		userID, err := strconv.Atoi(r.Header.Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		verifiedAt, err := connData.queries.GetUserEmailVerifiedAt(r.Context(), int32(userID))
+		if err != nil || !verifiedAt.Valid {
+			http.Error(w, "Email not verified", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
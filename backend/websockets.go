@@ -1,55 +1,500 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // Upgrade HTTP connection to WebSocket
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all origins
-		// Should it be restricted in prod?
-		return true
-	},
+	CheckOrigin: checkWSOrigin,
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("id")
+// checkWSOrigin only lets browsers connect from the origins trusted for CSRF, any other site could
+// open the socket with the user's cookie. Clients that aren't browsers send no Origin.
+func checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || slices.Contains(csrfTrustedOrigins(appConfig), originOf(origin))
+}
+
+const (
+	wsWriteTimeout      = 10 * time.Second
+	wsPongTimeout       = 60 * time.Second // a connection that doesn't answer pings for this long is closed
+	wsPingInterval      = wsPongTimeout * 9 / 10
+	wsSendBuffer        = 256     // messages queued per connection, a client that falls further behind is closed
+	maxWSMessageSize    = 2 << 20 // an update of maxCollabUpdateSize in base64, and then some
+	maxWSSubscriptions  = 100
+	wsSessionRevokedMsg = "Session revoked"
+)
+
+// Message types, see "Collaborative editing" and "WebSocket events" in Docs/DevNotes.md
+const (
+	wsTypeJoin         = "doc.join"
+	wsTypeSync         = "doc.sync"
+	wsTypeUpdate       = "doc.update"
+	wsTypeAwareness    = "doc.awareness"
+	wsTypeLeave        = "doc.leave"
+	wsTypeSubscribe    = "subscribe"
+	wsTypeSubscribed   = "subscribed"
+	wsTypeUnsubscribe  = "unsubscribe"
+	wsTypeUnsubscribed = "unsubscribed"
+	wsTypeError        = "error"
+)
+
+// Events the hub pushes to subscribers
+const (
+	wsEventNoteCreated    = "note.created"
+	wsEventNoteUpdated    = "note.updated"
+	wsEventNoteDeleted    = "note.deleted"
+	wsEventFolderCreated  = "folder.created"
+	wsEventSessionRevoked = "session.revoked"
+)
+
+var (
+	errTooManyNotes         = errors.New("too many notes joined")
+	errTooManySubscriptions = errors.New("too many subscriptions")
+	errInvalidTopic         = errors.New("invalid topic")
+	errUnknownMessage       = errors.New("unknown message type")
+	errSessionRevoked       = errors.New("session revoked or account disabled")
+)
+
+// wsMessage is a JSON message in either direction, []byte fields are base64 in JSON
+type wsMessage struct {
+	Type        string `json:"type"`
+	RoomID      int32  `json:"room_id,omitempty"`   // to subscribe to a room
+	FolderID    int32  `json:"folder_id,omitempty"` // to subscribe to a folder
+	NoteID      int32  `json:"note_id,omitempty"`
+	Update      []byte `json:"update,omitempty"`       // a Yjs update, or an awareness update for doc.awareness
+	StateVector []byte `json:"state_vector,omitempty"` // Y.encodeStateVector
+	Message     string `json:"message,omitempty"`      // of errors
+}
+
+// wsEvent tells subscribers that something changed, they get the details from the API
+type wsEvent struct {
+	Type      string `json:"type"`
+	RoomID    int32  `json:"room_id,omitempty"`
+	FolderID  int32  `json:"folder_id,omitempty"`
+	NoteID    int32  `json:"note_id,omitempty"`
+	Title     string `json:"title,omitempty"` // of notes
+	Name      string `json:"name,omitempty"`  // of folders
+	SessionID int32  `json:"session_id,omitempty"`
+}
+
+// wsTopic is what a connection subscribes to: a room, a folder or a note
+type wsTopic struct {
+	kind string
+	id   int32
+}
+
+func roomTopic(roomID int32) wsTopic     { return wsTopic{kind: "room", id: roomID} }
+func folderTopic(folderID int32) wsTopic { return wsTopic{kind: "folder", id: folderID} }
+func noteTopic(noteID int32) wsTopic     { return wsTopic{kind: "note", id: noteID} }
+
+// wsClient is one WebSocket connection. Everything it is sent is queued and written by writeLoop,
+// so senders never wait for a slow client.
+type wsClient struct {
+	conn      *websocket.Conn
+	userID    int32
+	sessionID int32
+	queue     chan []byte // nil closes the connection once what's before it is written
+	done      chan struct{}
+	closeOnce sync.Once
+
+	topics map[wsTopic]int32 // the room of each topic, guarded by Hub.mu
+}
+
+func newWSClient(conn *websocket.Conn, userID, sessionID int32) *wsClient {
+	return &wsClient{
+		conn:      conn,
+		userID:    userID,
+		sessionID: sessionID,
+		queue:     make(chan []byte, wsSendBuffer),
+		done:      make(chan struct{}),
+		topics:    map[wsTopic]int32{},
+	}
+}
+
+// enqueue queues a message, a client whose queue is full is closed
+func (client *wsClient) enqueue(data []byte) {
+	select {
+	case <-client.done:
+	case client.queue <- data:
+	default:
+		fmt.Printf("Closing slow WebSocket client of user %d\n", client.userID)
+		client.close()
+	}
+}
+
+// send queues the message as JSON
+func (client *wsClient) send(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println("Marshal error:", err)
+		return
+	}
+	client.enqueue(data)
+}
 
-	fmt.Println(userID)
+// close closes the connection, its read loop then ends and cleans up
+func (client *wsClient) close() {
+	client.closeOnce.Do(func() {
+		close(client.done)
+		client.conn.Close()
+	})
+}
+
+// writeLoop writes the queued messages and pings the client, it is the only writer of the connection
+func (client *wsClient) writeLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer func() {
+		ticker.Stop()
+		client.close()
+	}()
+
+	for {
+		select {
+		case data := <-client.queue:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if data == nil {
+				client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, wsSessionRevokedMsg))
+				return
+			}
+			if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				fmt.Println("Write error:", err)
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// Hub knows the connections of every user and what they subscribed to
+type Hub struct {
+	mu      sync.Mutex
+	clients map[int32]map[*wsClient]bool // by user
+	topics  map[wsTopic]map[*wsClient]bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		clients: map[int32]map[*wsClient]bool{},
+		topics:  map[wsTopic]map[*wsClient]bool{},
+	}
+}
+
+func (hub *Hub) register(client *wsClient) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.clients[client.userID] == nil {
+		hub.clients[client.userID] = map[*wsClient]bool{}
+	}
+	hub.clients[client.userID][client] = true
+}
+
+func (hub *Hub) unregister(client *wsClient) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for topic := range client.topics {
+		hub.drop(client, topic)
+	}
+	delete(hub.clients[client.userID], client)
+	if len(hub.clients[client.userID]) == 0 {
+		delete(hub.clients, client.userID)
+	}
+}
+
+// subscribe adds the subscription, the caller checked the user can read the topic's room
+func (hub *Hub) subscribe(client *wsClient, topic wsTopic, roomID int32) error {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if _, ok := client.topics[topic]; !ok && len(client.topics) >= maxWSSubscriptions {
+		return errTooManySubscriptions
+	}
+	client.topics[topic] = roomID
+	if hub.topics[topic] == nil {
+		hub.topics[topic] = map[*wsClient]bool{}
+	}
+	hub.topics[topic][client] = true
+	return nil
+}
+
+func (hub *Hub) unsubscribe(client *wsClient, topic wsTopic) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.drop(client, topic)
+}
+
+// drop removes the subscription, the caller holds hub.mu
+func (hub *Hub) drop(client *wsClient, topic wsTopic) {
+	delete(client.topics, topic)
+	delete(hub.topics[topic], client)
+	if len(hub.topics[topic]) == 0 {
+		delete(hub.topics, topic)
+	}
+}
+
+// Publish sends the event to the subscribers of any of the topics, once to each connection
+func (hub *Hub) Publish(event wsEvent, topics ...wsTopic) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Println("Marshal error:", err)
+		return
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	sent := map[*wsClient]bool{}
+	for _, topic := range topics {
+		for client := range hub.topics[topic] {
+			if !sent[client] {
+				sent[client] = true
+				client.enqueue(data)
+			}
+		}
+	}
+}
+
+// LeaveRoom drops the user's subscriptions to the room and everything in it, when they lose access to it
+func (hub *Hub) LeaveRoom(userID, roomID int32) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for client := range hub.clients[userID] {
+		for topic, topicRoomID := range client.topics {
+			if topicRoomID == roomID {
+				hub.drop(client, topic)
+				client.send(newTopicMessage(wsTypeUnsubscribed, topic))
+			}
+		}
+	}
+}
+
+// RevokeSessions tells every connection of the user which sessions were revoked and closes
+// the connections opened with them. revoked decides for each session.
+func (hub *Hub) RevokeSessions(userID int32, revoked func(sessionID int32) bool) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	sessions := map[int32]bool{}
+	for client := range hub.clients[userID] {
+		if revoked(client.sessionID) {
+			sessions[client.sessionID] = true
+		}
+	}
+	for client := range hub.clients[userID] {
+		for sessionID := range sessions {
+			client.send(wsEvent{Type: wsEventSessionRevoked, SessionID: sessionID})
+		}
+		if sessions[client.sessionID] {
+			client.enqueue(nil)
+		}
+	}
+}
+
+// allSessions revokes every session, for RevokeSessions
+func allSessions(int32) bool {
+	return true
+}
+
+// newTopicMessage is a subscribed or unsubscribed message, with the id field of the topic set
+func newTopicMessage(messageType string, topic wsTopic) wsMessage {
+	msg := wsMessage{Type: messageType}
+	switch topic.kind {
+	case "room":
+		msg.RoomID = topic.id
+	case "folder":
+		msg.FolderID = topic.id
+	case "note":
+		msg.NoteID = topic.id
+	}
+	return msg
+}
+
+// topicOf reads the topic of a subscribe or unsubscribe message, exactly one id is set
+func topicOf(msg wsMessage) (wsTopic, error) {
+	var topics []wsTopic
+	if msg.RoomID != 0 {
+		topics = append(topics, roomTopic(msg.RoomID))
+	}
+	if msg.FolderID != 0 {
+		topics = append(topics, folderTopic(msg.FolderID))
+	}
+	if msg.NoteID != 0 {
+		topics = append(topics, noteTopic(msg.NoteID))
+	}
+	if len(topics) != 1 {
+		return wsTopic{}, errInvalidTopic
+	}
+	return topics[0], nil
+}
+
+// wsErrorMessage is what the client is told when a message fails, unexpected errors are logged
+func wsErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errTooManyNotes):
+		return fmt.Sprintf("At most %d notes can be joined at once", maxCollabNotesPerWS)
+	case errors.Is(err, errTooManySubscriptions):
+		return fmt.Sprintf("At most %d subscriptions per connection", maxWSSubscriptions)
+	case errors.Is(err, errInvalidTopic):
+		return "Either room_id, folder_id or note_id is required"
+	case errors.Is(err, errUnknownMessage):
+		return "Unknown message type"
+	case errors.Is(err, errNotFound):
+		return "Not found"
+	case errors.Is(err, errForbidden):
+		return "No write access to this note"
+	case errors.Is(err, errNotJoined):
+		return "Join the note first"
+	case errors.Is(err, errUpdateTooLarge):
+		return "Update is too large"
+	case errors.Is(err, errYjsMalformed):
+		return "Malformed update"
+	case errors.Is(err, errYjsTooManyPending):
+		return "Update depends on too many changes the server doesn't have"
+	case errors.Is(err, errSessionRevoked):
+		return wsSessionRevokedMsg
+	default:
+		fmt.Printf("WebSocket message failed: %v\n", err)
+		return "Something went wrong"
+	}
+}
+
+func (conn ConnectionData) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.Header.Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	sessionID, _ := strconv.Atoi(r.Header.Get("session_id"))
 
 	// Upgrade connection
-	conn, err := upgrader.Upgrade(w, r, nil)
+	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		fmt.Println("Upgrade error:", err)
 		return
 	}
-	defer conn.Close()
+	wsConn.SetReadLimit(maxWSMessageSize)
+	wsConn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
 
-	fmt.Println("Client connected")
+	client := newWSClient(wsConn, int32(userID), int32(sessionID))
+	joined := map[int32]bool{} // notes joined for collaborative editing, only used by this goroutine
+	conn.hub.register(client)
+	go client.writeLoop()
+	defer func() {
+		client.close()
+		conn.hub.unregister(client)
+		conn.collab.LeaveAll(client, joined)
+	}()
+
+	fmt.Printf("Client %d connected\n", userID)
 
 	// Send a welcome message
-	if err := conn.WriteMessage(websocket.TextMessage, []byte("Welcome to SteamedNotes WS!")); err != nil {
-		fmt.Println("Write error:", err)
-		return
-	}
+	client.enqueue([]byte("Welcome to SteamedNotes WS!"))
 
-	// Echo loop
 	for {
-		_, msg, err := conn.ReadMessage()
+		_, data, err := wsConn.ReadMessage()
 		if err != nil {
 			fmt.Println("Read error:", err)
 			break
 		}
-		fmt.Printf("Received: %s\n", msg)
+		wsConn.SetReadDeadline(time.Now().Add(wsPongTimeout))
 
-		// Echo back
-		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			fmt.Println("Write error:", err)
-			break
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			// Not part of the protocol, echo it back like before
+			client.enqueue(data)
+			continue
+		}
+
+		if err := conn.handleWSMessage(r.Context(), client, joined, msg); err != nil {
+			client.send(wsMessage{Type: wsTypeError, RoomID: msg.RoomID, FolderID: msg.FolderID, NoteID: msg.NoteID, Message: wsErrorMessage(err)})
+			if errors.Is(err, errSessionRevoked) {
+				client.enqueue(nil)
+			}
 		}
 	}
 }
+
+func (conn ConnectionData) handleWSMessage(ctx context.Context, client *wsClient, joined map[int32]bool, msg wsMessage) error {
+	switch msg.Type {
+	case wsTypeSubscribe:
+		topic, err := topicOf(msg)
+		if err != nil {
+			return err
+		}
+		roomID, err := conn.topicRoom(ctx, client.userID, topic)
+		if err != nil {
+			return err
+		}
+		if err := conn.hub.subscribe(client, topic, roomID); err != nil {
+			return err
+		}
+		client.send(newTopicMessage(wsTypeSubscribed, topic))
+	case wsTypeUnsubscribe:
+		topic, err := topicOf(msg)
+		if err != nil {
+			return err
+		}
+		conn.hub.unsubscribe(client, topic)
+		client.send(newTopicMessage(wsTypeUnsubscribed, topic))
+	case wsTypeJoin:
+		if !joined[msg.NoteID] && len(joined) >= maxCollabNotesPerWS {
+			return errTooManyNotes
+		}
+		update, stateVector, canWrite, err := conn.collab.Join(ctx, client, msg.NoteID, msg.StateVector)
+		if err != nil {
+			return err
+		}
+		joined[msg.NoteID] = true
+		client.send(struct {
+			wsMessage
+			CanWrite bool `json:"can_write"`
+		}{wsMessage{Type: wsTypeSync, NoteID: msg.NoteID, Update: update, StateVector: stateVector}, canWrite})
+	case wsTypeUpdate:
+		err := conn.collab.Update(ctx, client, msg.NoteID, msg.Update)
+		if errors.Is(err, errNotFound) || errors.Is(err, errNotJoined) {
+			delete(joined, msg.NoteID)
+		}
+		return err
+	case wsTypeAwareness:
+		return conn.collab.Awareness(ctx, client, msg.NoteID, msg.Update)
+	case wsTypeLeave:
+		conn.collab.Leave(client, msg.NoteID)
+		delete(joined, msg.NoteID)
+	default:
+		return errUnknownMessage
+	}
+	return nil
+}
+
+// topicRoom checks the user can read the room, folder or note and returns the room it is in
+func (conn ConnectionData) topicRoom(ctx context.Context, userID int32, topic wsTopic) (int32, error) {
+	switch topic.kind {
+	case "room":
+		room, err := conn.authz.CanReadRoom(ctx, userID, topic.id)
+		return room.ID, err
+	case "folder":
+		folder, err := conn.authz.CanReadFolder(ctx, userID, topic.id)
+		return folder.RoomID, err
+	default:
+		note, err := conn.authz.CanReadNote(ctx, userID, topic.id)
+		return note.RoomID, err
+	}
+}
@@ -0,0 +1,907 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// A server side Yjs document: it merges the updates of clients the way Yjs does (YATA),
+// answers sync requests with what a client is missing and reads the text of a Y.Text.
+// It keeps every struct it doesn't understand and writes it back unchanged, so clients
+// can use any shared type, the server only reads text.
+
+// yjsID identifies a struct by the client that created it and the clock of its first unit
+type yjsID struct {
+	client uint64
+	clock  int
+}
+
+type yjsDeleteRange struct {
+	clock  int
+	length int
+}
+
+const (
+	yjsKindItem = iota
+	yjsKindGC   // deleted content whose position is forgotten
+	yjsKindSkip // a gap in an update, never stored
+)
+
+type yjsItem struct {
+	kind   int
+	id     yjsID
+	length int
+
+	origin      *yjsID // the item on the left when it was inserted
+	rightOrigin *yjsID // the item on the right when it was inserted
+	left, right *yjsItem
+	parent      *yjsType
+	parentSub   *string // the key when the parent is used as a map
+	content     yjsContent
+	deleted     bool
+
+	// Where the item belongs while it isn't integrated, set when it has no origins
+	parentID      *yjsID
+	parentName    string
+	hasParentName bool
+}
+
+func (item *yjsItem) lastID() yjsID {
+	return yjsID{client: item.id.client, clock: item.id.clock + item.length - 1}
+}
+
+func (item *yjsItem) isDeleted() bool {
+	return item.kind != yjsKindItem || item.deleted
+}
+
+// yjsType is a shared type: a root type (doc.get(name)) or the content of an item
+type yjsType struct {
+	name   string // of root types
+	item   *yjsItem
+	start  *yjsItem
+	keys   map[string]*yjsItem // the current item of each key, when used as a map
+	length int
+}
+
+func newYjsType(name string) *yjsType {
+	return &yjsType{name: name, keys: map[string]*yjsItem{}}
+}
+
+type yjsContent interface {
+	ref() byte
+	length() int
+	countable() bool
+	// splice keeps the first offset units and returns the rest
+	splice(offset int) yjsContent
+	// merge appends right when both can be one content
+	merge(right yjsContent) bool
+	write(e *yjsEncoder, offset int)
+}
+
+type yjsContentDeleted struct{ len int }
+
+func (c *yjsContentDeleted) ref() byte       { return yjsRefDeleted }
+func (c *yjsContentDeleted) length() int     { return c.len }
+func (c *yjsContentDeleted) countable() bool { return false }
+func (c *yjsContentDeleted) splice(offset int) yjsContent {
+	right := &yjsContentDeleted{len: c.len - offset}
+	c.len = offset
+	return right
+}
+func (c *yjsContentDeleted) merge(right yjsContent) bool {
+	r, ok := right.(*yjsContentDeleted)
+	if ok {
+		c.len += r.len
+	}
+	return ok
+}
+func (c *yjsContentDeleted) write(e *yjsEncoder, offset int) { e.writeLen(c.len - offset) }
+
+// yjsContentJSON holds the JSON of each value, "undefined" included
+type yjsContentJSON struct{ values []string }
+
+func (c *yjsContentJSON) ref() byte       { return yjsRefJSON }
+func (c *yjsContentJSON) length() int     { return len(c.values) }
+func (c *yjsContentJSON) countable() bool { return true }
+func (c *yjsContentJSON) splice(offset int) yjsContent {
+	right := &yjsContentJSON{values: slices.Clone(c.values[offset:])}
+	c.values = c.values[:offset:offset]
+	return right
+}
+func (c *yjsContentJSON) merge(right yjsContent) bool {
+	r, ok := right.(*yjsContentJSON)
+	if ok {
+		c.values = append(c.values, r.values...)
+	}
+	return ok
+}
+func (c *yjsContentJSON) write(e *yjsEncoder, offset int) {
+	e.writeLen(len(c.values) - offset)
+	for _, value := range c.values[offset:] {
+		e.writeVarString(value)
+	}
+}
+
+type yjsContentBinary struct{ data []byte }
+
+func (c *yjsContentBinary) ref() byte                       { return yjsRefBinary }
+func (c *yjsContentBinary) length() int                     { return 1 }
+func (c *yjsContentBinary) countable() bool                 { return true }
+func (c *yjsContentBinary) splice(offset int) yjsContent    { panic("yjs: binary content can't be split") }
+func (c *yjsContentBinary) merge(right yjsContent) bool     { return false }
+func (c *yjsContentBinary) write(e *yjsEncoder, offset int) { e.writeVarBytes(c.data) }
+
+type yjsContentString struct{ text []uint16 }
+
+func (c *yjsContentString) ref() byte       { return yjsRefString }
+func (c *yjsContentString) length() int     { return len(c.text) }
+func (c *yjsContentString) countable() bool { return true }
+
+// splice never leaves half of a surrogate pair on each side, like Yjs both halves become U+FFFD
+func (c *yjsContentString) splice(offset int) yjsContent {
+	right := &yjsContentString{text: slices.Clone(c.text[offset:])}
+	c.text = c.text[:offset:offset]
+	if last := c.text[offset-1]; last >= 0xd800 && last <= 0xdbff {
+		c.text[offset-1] = 0xfffd
+		right.text[0] = 0xfffd
+	}
+	return right
+}
+func (c *yjsContentString) merge(right yjsContent) bool {
+	r, ok := right.(*yjsContentString)
+	if ok {
+		c.text = append(c.text, r.text...)
+	}
+	return ok
+}
+func (c *yjsContentString) write(e *yjsEncoder, offset int) {
+	e.writeVarString(stringFromUTF16(c.text[offset:]))
+}
+
+type yjsContentEmbed struct{ json string }
+
+func (c *yjsContentEmbed) ref() byte                       { return yjsRefEmbed }
+func (c *yjsContentEmbed) length() int                     { return 1 }
+func (c *yjsContentEmbed) countable() bool                 { return true }
+func (c *yjsContentEmbed) splice(offset int) yjsContent    { panic("yjs: embed content can't be split") }
+func (c *yjsContentEmbed) merge(right yjsContent) bool     { return false }
+func (c *yjsContentEmbed) write(e *yjsEncoder, offset int) { e.writeVarString(c.json) }
+
+type yjsContentFormat struct{ key, json string }
+
+func (c *yjsContentFormat) ref() byte                    { return yjsRefFormat }
+func (c *yjsContentFormat) length() int                  { return 1 }
+func (c *yjsContentFormat) countable() bool              { return false }
+func (c *yjsContentFormat) splice(offset int) yjsContent { panic("yjs: format content can't be split") }
+func (c *yjsContentFormat) merge(right yjsContent) bool  { return false }
+func (c *yjsContentFormat) write(e *yjsEncoder, offset int) {
+	e.writeVarString(c.key)
+	e.writeVarString(c.json)
+}
+
+type yjsContentType struct {
+	typeRef uint64
+	name    string // node name of Y.XmlElement, hook name of Y.XmlHook
+	t       *yjsType
+}
+
+func (c *yjsContentType) ref() byte                    { return yjsRefType }
+func (c *yjsContentType) length() int                  { return 1 }
+func (c *yjsContentType) countable() bool              { return true }
+func (c *yjsContentType) splice(offset int) yjsContent { panic("yjs: type content can't be split") }
+func (c *yjsContentType) merge(right yjsContent) bool  { return false }
+func (c *yjsContentType) write(e *yjsEncoder, offset int) {
+	e.writeVarUint(c.typeRef)
+	if c.typeRef == yjsTypeXmlElement || c.typeRef == yjsTypeXmlHook {
+		e.writeVarString(c.name)
+	}
+}
+
+// yjsContentAny holds the lib0 encoding of each value
+type yjsContentAny struct{ values [][]byte }
+
+func (c *yjsContentAny) ref() byte       { return yjsRefAny }
+func (c *yjsContentAny) length() int     { return len(c.values) }
+func (c *yjsContentAny) countable() bool { return true }
+func (c *yjsContentAny) splice(offset int) yjsContent {
+	right := &yjsContentAny{values: slices.Clone(c.values[offset:])}
+	c.values = c.values[:offset:offset]
+	return right
+}
+func (c *yjsContentAny) merge(right yjsContent) bool {
+	r, ok := right.(*yjsContentAny)
+	if ok {
+		c.values = append(c.values, r.values...)
+	}
+	return ok
+}
+func (c *yjsContentAny) write(e *yjsEncoder, offset int) {
+	e.writeLen(len(c.values) - offset)
+	for _, value := range c.values[offset:] {
+		e.buf = append(e.buf, value...)
+	}
+}
+
+type yjsContentDoc struct {
+	guid string
+	opts []byte
+}
+
+func (c *yjsContentDoc) ref() byte                    { return yjsRefDoc }
+func (c *yjsContentDoc) length() int                  { return 1 }
+func (c *yjsContentDoc) countable() bool              { return true }
+func (c *yjsContentDoc) splice(offset int) yjsContent { panic("yjs: doc content can't be split") }
+func (c *yjsContentDoc) merge(right yjsContent) bool  { return false }
+func (c *yjsContentDoc) write(e *yjsEncoder, offset int) {
+	e.writeVarString(c.guid)
+	e.buf = append(e.buf, c.opts...)
+}
+
+// YjsDoc is not safe for concurrent use
+type YjsDoc struct {
+	clients map[uint64][]*yjsItem // the structs of each client, ordered by clock
+	share   map[string]*yjsType   // root types by name
+
+	// Structs and deletes waiting for structs of other updates, as an update like Yjs keeps them
+	pending []byte
+}
+
+// Limits of what waits for other updates, a client can't grow a doc without bound with
+// structs that never fit in
+const (
+	maxYjsPendingStructs = 1000 // structs and delete ranges
+	maxYjsPendingSize    = 1 << 20
+)
+
+var errYjsTooManyPending = errors.New("too many yjs structs wait for other updates")
+
+func NewYjsDoc() *YjsDoc {
+	return &YjsDoc{
+		clients: map[uint64][]*yjsItem{},
+		share:   map[string]*yjsType{},
+	}
+}
+
+func sortedYjsClients[V any](m map[uint64]V) []uint64 {
+	clients := make([]uint64, 0, len(m))
+	for client := range m {
+		clients = append(clients, client)
+	}
+	slices.Sort(clients)
+	return clients
+}
+
+// rootType is doc.get(name), created on first use like in Yjs
+func (doc *YjsDoc) rootType(name string) *yjsType {
+	t, ok := doc.share[name]
+	if !ok {
+		t = newYjsType(name)
+		doc.share[name] = t
+	}
+	return t
+}
+
+// state is the next clock of the client
+func (doc *YjsDoc) state(client uint64) int {
+	structs := doc.clients[client]
+	if len(structs) == 0 {
+		return 0
+	}
+	last := structs[len(structs)-1]
+	return last.id.clock + last.length
+}
+
+// StateVector is the next clock of every client
+func (doc *YjsDoc) StateVector() map[uint64]int {
+	sv := make(map[uint64]int, len(doc.clients))
+	for client := range doc.clients {
+		sv[client] = doc.state(client)
+	}
+	return sv
+}
+
+// findIndex is the index of the struct holding clock, the caller knows it exists
+func findYjsIndex(structs []*yjsItem, clock int) int {
+	index := sort.Search(len(structs), func(i int) bool {
+		return structs[i].id.clock+structs[i].length > clock
+	})
+	if index == len(structs) || structs[index].id.clock > clock {
+		panic(fmt.Sprintf("yjs: no struct at clock %d", clock))
+	}
+	return index
+}
+
+func (doc *YjsDoc) getItem(id yjsID) *yjsItem {
+	structs := doc.clients[id.client]
+	return structs[findYjsIndex(structs, id.clock)]
+}
+
+// splitItem cuts the item after diff units and returns the right part, which is stored after it
+func (doc *YjsDoc) splitItem(left *yjsItem, diff int) *yjsItem {
+	right := &yjsItem{
+		kind:        yjsKindItem,
+		id:          yjsID{client: left.id.client, clock: left.id.clock + diff},
+		length:      left.length - diff,
+		origin:      &yjsID{client: left.id.client, clock: left.id.clock + diff - 1},
+		rightOrigin: left.rightOrigin,
+		left:        left,
+		right:       left.right,
+		parent:      left.parent,
+		parentSub:   left.parentSub,
+		content:     left.content.splice(diff),
+		deleted:     left.deleted,
+	}
+	left.right = right
+	if right.right != nil {
+		right.right.left = right
+	}
+	if right.parentSub != nil && right.right == nil {
+		right.parent.keys[*right.parentSub] = right
+	}
+	left.length = diff
+
+	structs := doc.clients[left.id.client]
+	index := findYjsIndex(structs, left.id.clock)
+	doc.clients[left.id.client] = slices.Insert(structs, index+1, right)
+	return right
+}
+
+// getItemCleanStart returns the item starting at id, splitting the one holding it
+func (doc *YjsDoc) getItemCleanStart(id yjsID) *yjsItem {
+	item := doc.getItem(id)
+	if item.kind == yjsKindItem && item.id.clock < id.clock {
+		return doc.splitItem(item, id.clock-item.id.clock)
+	}
+	return item
+}
+
+// getItemCleanEnd returns the item ending at id, splitting the one holding it
+func (doc *YjsDoc) getItemCleanEnd(id yjsID) *yjsItem {
+	item := doc.getItem(id)
+	if item.kind == yjsKindItem && id.clock != item.id.clock+item.length-1 {
+		doc.splitItem(item, id.clock-item.id.clock+1)
+	}
+	return item
+}
+
+// resolve finds the neighbours and parent of an item whose dependencies are in the doc,
+// the parent stays nil when the item ends up next to or in garbage collected structs
+func (doc *YjsDoc) resolve(item *yjsItem) {
+	if item.origin != nil {
+		item.left = doc.getItemCleanEnd(*item.origin)
+		lastID := item.left.lastID()
+		item.origin = &lastID
+	}
+	if item.rightOrigin != nil {
+		item.right = doc.getItemCleanStart(*item.rightOrigin)
+		rightID := item.right.id
+		item.rightOrigin = &rightID
+	}
+
+	switch {
+	case (item.left != nil && item.left.kind == yjsKindGC) || (item.right != nil && item.right.kind == yjsKindGC):
+		item.parent = nil
+	case item.hasParentName:
+		item.parent = doc.rootType(item.parentName)
+	case item.parentID != nil:
+		item.parent = nil
+		if parentItem := doc.getItem(*item.parentID); parentItem.kind == yjsKindItem {
+			if content, ok := parentItem.content.(*yjsContentType); ok {
+				item.parent = content.t
+			}
+		}
+	default:
+		if item.left != nil {
+			item.parent, item.parentSub = item.left.parent, item.left.parentSub
+		} else if item.right != nil {
+			item.parent, item.parentSub = item.right.parent, item.right.parentSub
+		}
+	}
+}
+
+func sameYjsID(a, b *yjsID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// integrate inserts a resolved item into its parent, skipping the first offset units
+// the doc already has. It follows Item.integrate of Yjs so every replica orders the same way.
+func (doc *YjsDoc) integrate(item *yjsItem, offset int) {
+	if item.kind != yjsKindItem {
+		item.id.clock += offset
+		item.length -= offset
+		item.kind = yjsKindGC
+		doc.clients[item.id.client] = append(doc.clients[item.id.client], item)
+		return
+	}
+
+	if offset > 0 {
+		item.id.clock += offset
+		item.left = doc.getItemCleanEnd(yjsID{client: item.id.client, clock: item.id.clock - 1})
+		lastID := item.left.lastID()
+		item.origin = &lastID
+		item.content = item.content.splice(offset)
+		item.length -= offset
+	}
+
+	if item.parent == nil {
+		doc.integrate(&yjsItem{kind: yjsKindGC, id: item.id, length: item.length}, 0)
+		return
+	}
+	parent := item.parent
+
+	if (item.left == nil && (item.right == nil || item.right.left != nil)) || (item.left != nil && item.left.right != item.right) {
+		// Items inserted concurrently at the same place are ordered by client id
+		left := item.left
+		var o *yjsItem
+		switch {
+		case left != nil:
+			o = left.right
+		case item.parentSub != nil:
+			o = parent.keys[*item.parentSub]
+			for o != nil && o.left != nil {
+				o = o.left
+			}
+		default:
+			o = parent.start
+		}
+		conflicting := map[*yjsItem]bool{}
+		beforeOrigin := map[*yjsItem]bool{}
+		for o != nil && o != item.right {
+			beforeOrigin[o] = true
+			conflicting[o] = true
+			if sameYjsID(item.origin, o.origin) {
+				if o.id.client < item.id.client {
+					left = o
+					clear(conflicting)
+				} else if sameYjsID(item.rightOrigin, o.rightOrigin) {
+					break
+				}
+			} else if o.origin != nil && beforeOrigin[doc.getItem(*o.origin)] {
+				if !conflicting[doc.getItem(*o.origin)] {
+					left = o
+					clear(conflicting)
+				}
+			} else {
+				break
+			}
+			o = o.right
+		}
+		item.left = left
+	}
+
+	if item.left != nil {
+		item.right = item.left.right
+		item.left.right = item
+	} else {
+		var r *yjsItem
+		if item.parentSub != nil {
+			r = parent.keys[*item.parentSub]
+			for r != nil && r.left != nil {
+				r = r.left
+			}
+		} else {
+			r = parent.start
+			parent.start = item
+		}
+		item.right = r
+	}
+	if item.right != nil {
+		item.right.left = item
+	} else if item.parentSub != nil {
+		// The rightmost item is the value of the key, the one before is overwritten
+		parent.keys[*item.parentSub] = item
+		if item.left != nil {
+			doc.deleteItem(item.left)
+		}
+	}
+
+	if _, ok := item.content.(*yjsContentDeleted); ok {
+		item.deleted = true
+	}
+	if item.parentSub == nil && item.content.countable() && !item.deleted {
+		parent.length += item.length
+	}
+	doc.clients[item.id.client] = append(doc.clients[item.id.client], item)
+	if content, ok := item.content.(*yjsContentType); ok {
+		content.t.item = item
+	}
+
+	if (parent.item != nil && parent.item.deleted) || (item.parentSub != nil && item.right != nil) {
+		doc.deleteItem(item)
+	}
+}
+
+// deleteItem marks the item deleted and drops its content, the position stays for concurrent inserts.
+// Deleting a type deletes everything in it.
+func (doc *YjsDoc) deleteItem(item *yjsItem) {
+	if item.kind != yjsKindItem || item.deleted {
+		return
+	}
+	if item.parentSub == nil && item.content.countable() {
+		item.parent.length -= item.length
+	}
+	item.deleted = true
+
+	switch content := item.content.(type) {
+	case *yjsContentType:
+		for child := content.t.start; child != nil; child = child.right {
+			doc.deleteItem(child)
+		}
+		for _, child := range content.t.keys {
+			doc.deleteItem(child)
+		}
+	case *yjsContentDoc:
+	default:
+		item.content = &yjsContentDeleted{len: item.length}
+	}
+}
+
+// ApplyUpdate merges an update of a client. Structs whose dependencies are missing wait
+// for a later update, they are not an error. The update is planned against the state vector
+// first and only changes the doc once the plan is within the pending limits, so a refused
+// update leaves the doc as it was.
+func (doc *YjsDoc) ApplyUpdate(update []byte) (err error) {
+	structs, deletes, err := decodeYjsUpdate(update)
+	if err != nil {
+		return err
+	}
+	if len(doc.pending) > 0 {
+		pendingStructs, pendingDeletes, err := decodeYjsUpdate(doc.pending)
+		if err != nil {
+			return err
+		}
+		for client, items := range pendingStructs {
+			structs[client] = append(structs[client], items...)
+		}
+		for client, ranges := range pendingDeletes {
+			deletes[client] = append(deletes[client], ranges...)
+		}
+	}
+
+	plan, err := doc.planUpdate(structs, deletes)
+	if err != nil {
+		return err
+	}
+
+	// Decoding and planning rule out what Yjs would never write, this is the last resort
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", errYjsMalformed, r)
+		}
+	}()
+	for _, step := range plan.structs {
+		if step.item.kind == yjsKindItem {
+			doc.resolve(step.item)
+		}
+		doc.integrate(step.item, step.offset)
+	}
+	for _, r := range plan.deletes {
+		doc.deleteRange(r.client, r.clock, r.end)
+	}
+	doc.pending = plan.pending
+	return nil
+}
+
+// yjsUpdatePlan is what an update does to a doc, in the order it has to happen
+type yjsUpdatePlan struct {
+	structs []yjsPlannedStruct
+	deletes []yjsPlannedDelete
+	pending []byte // what waits for other updates, encoded as an update
+}
+
+type yjsPlannedStruct struct {
+	item   *yjsItem
+	offset int // units the doc already has
+}
+
+type yjsPlannedDelete struct {
+	client     uint64
+	clock, end int
+}
+
+// planUpdate finds the structs whose dependencies are in the doc or come first in the update,
+// in clock order for each client, and the deletes of structs that are there by then.
+// It only reads the state vector, the doc doesn't change.
+func (doc *YjsDoc) planUpdate(structs map[uint64][]*yjsItem, deletes map[uint64][]yjsDeleteRange) (*yjsUpdatePlan, error) {
+	plan := &yjsUpdatePlan{}
+	state := doc.StateVector()
+	missing := func(item *yjsItem) bool {
+		for _, id := range []*yjsID{item.origin, item.rightOrigin, item.parentID} {
+			if id != nil && id.clock >= state[id.client] {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, items := range structs {
+		slices.SortStableFunc(items, func(a, b *yjsItem) int { return a.id.clock - b.id.clock })
+	}
+	clients := sortedYjsClients(structs)
+	for progress := true; progress; {
+		progress = false
+		for _, client := range clients {
+			items := structs[client]
+			for len(items) > 0 {
+				item := items[0]
+				end := item.id.clock + item.length
+				if item.kind == yjsKindSkip || end <= state[client] {
+					items = items[1:]
+					continue
+				}
+				offset := state[client] - item.id.clock
+				if offset < 0 || (item.kind == yjsKindItem && missing(item)) {
+					break
+				}
+				plan.structs = append(plan.structs, yjsPlannedStruct{item: item, offset: offset})
+				state[client] = end
+				items = items[1:]
+				progress = true
+			}
+			structs[client] = items
+		}
+	}
+
+	count := 0
+	pendingStructs := map[uint64][]*yjsItem{}
+	for client, items := range structs {
+		if len(items) > 0 {
+			pendingStructs[client] = items
+			count += len(items)
+		}
+	}
+	pendingDeletes := map[uint64][]yjsDeleteRange{}
+	for _, client := range sortedYjsClients(deletes) {
+		for _, r := range deletes[client] {
+			if r.length < 1 {
+				continue
+			}
+			end := r.clock + r.length
+			if r.clock >= state[client] {
+				pendingDeletes[client] = append(pendingDeletes[client], r)
+				count++
+				continue
+			}
+			if end > state[client] {
+				pendingDeletes[client] = append(pendingDeletes[client], yjsDeleteRange{clock: state[client], length: end - state[client]})
+				count++
+				end = state[client]
+			}
+			plan.deletes = append(plan.deletes, yjsPlannedDelete{client: client, clock: r.clock, end: end})
+		}
+	}
+
+	if count > 0 {
+		plan.pending = encodeYjsUpdate(pendingStructs, pendingDeletes)
+	}
+	if count > maxYjsPendingStructs || len(plan.pending) > maxYjsPendingSize {
+		return nil, errYjsTooManyPending
+	}
+	return plan, nil
+}
+
+// deleteRange deletes the structs of the client from clock up to end
+func (doc *YjsDoc) deleteRange(client uint64, clock, end int) {
+	item := doc.getItemCleanStart(yjsID{client: client, clock: clock})
+	for {
+		if item.kind == yjsKindItem && !item.deleted {
+			if end < item.id.clock+item.length {
+				doc.splitItem(item, end-item.id.clock)
+			}
+			doc.deleteItem(item)
+		}
+		next := item.id.clock + item.length
+		if next >= end {
+			return
+		}
+		item = doc.getItem(yjsID{client: client, clock: next})
+	}
+}
+
+// EncodeStateAsUpdate writes everything the doc has and the given state vector doesn't,
+// an empty state vector gives the whole doc
+func (doc *YjsDoc) EncodeStateAsUpdate(sv map[uint64]int) []byte {
+	e := &yjsEncoder{}
+
+	// Clients with structs the state vector misses, with the clock to start from
+	missing := map[uint64]int{}
+	for client := range doc.clients {
+		if clock := sv[client]; clock < doc.state(client) {
+			missing[client] = clock
+		}
+	}
+	clients := sortedYjsClients(missing)
+	slices.Reverse(clients)
+	e.writeLen(len(clients))
+	for _, client := range clients {
+		structs := doc.clients[client]
+		clock := max(missing[client], structs[0].id.clock)
+		start := findYjsIndex(structs, clock)
+		e.writeLen(len(structs) - start)
+		e.writeVarUint(client)
+		e.writeLen(clock)
+		writeYjsStruct(e, structs[start], clock-structs[start].id.clock)
+		for _, item := range structs[start+1:] {
+			writeYjsStruct(e, item, 0)
+		}
+	}
+
+	doc.writeDeleteSet(e)
+	return e.buf
+}
+
+// writeDeleteSet writes the deleted ranges of every client, deletes are never diffed
+func (doc *YjsDoc) writeDeleteSet(e *yjsEncoder) {
+	deletes := map[uint64][]yjsDeleteRange{}
+	for client, structs := range doc.clients {
+		var ranges []yjsDeleteRange
+		for _, item := range structs {
+			if !item.isDeleted() {
+				continue
+			}
+			if n := len(ranges); n > 0 && ranges[n-1].clock+ranges[n-1].length == item.id.clock {
+				ranges[n-1].length += item.length
+			} else {
+				ranges = append(ranges, yjsDeleteRange{clock: item.id.clock, length: item.length})
+			}
+		}
+		if len(ranges) > 0 {
+			deletes[client] = ranges
+		}
+	}
+	writeYjsDeleteSet(e, deletes)
+}
+
+// Compact merges neighbouring structs of the same client that were split or typed one
+// unit at a time, like Yjs does after each transaction
+func (doc *YjsDoc) Compact() {
+	for client, structs := range doc.clients {
+		merged := structs[:1]
+		for _, right := range structs[1:] {
+			left := merged[len(merged)-1]
+			if doc.mergeStructs(left, right) {
+				continue
+			}
+			merged = append(merged, right)
+		}
+		clear(structs[len(merged):])
+		doc.clients[client] = merged
+	}
+}
+
+func (doc *YjsDoc) mergeStructs(left, right *yjsItem) bool {
+	if left.kind != right.kind || left.id.clock+left.length != right.id.clock {
+		return false
+	}
+	if left.kind == yjsKindGC {
+		left.length += right.length
+		return true
+	}
+	if left.right != right || !sameYjsID(right.origin, &yjsID{client: left.id.client, clock: left.id.clock + left.length - 1}) ||
+		!sameYjsID(left.rightOrigin, right.rightOrigin) || left.deleted != right.deleted || !left.content.merge(right.content) {
+		return false
+	}
+	left.length += right.length
+	left.right = right.right
+	if left.right != nil {
+		left.right.left = left
+	}
+	if right.parentSub != nil && right.parent.keys[*right.parentSub] == right {
+		right.parent.keys[*right.parentSub] = left
+	}
+	return true
+}
+
+// Text reads the root Y.Text name, embeds and formatting are left out
+func (doc *YjsDoc) Text(name string) string {
+	t, ok := doc.share[name]
+	if !ok {
+		return ""
+	}
+	var text []uint16
+	for item := t.start; item != nil; item = item.right {
+		if content, ok := item.content.(*yjsContentString); ok && !item.deleted {
+			text = append(text, content.text...)
+		}
+	}
+	return stringFromUTF16(text)
+}
+
+// SetText changes the root Y.Text name to text as client, only the part in the middle
+// that differs is replaced so concurrent edits elsewhere survive
+func (doc *YjsDoc) SetText(name, text string, client uint64) {
+	t := doc.rootType(name)
+
+	// What indexes of Y.Text count: strings, and one unit for embeds and types
+	var current []uint16
+	for item := t.start; item != nil; item = item.right {
+		if item.deleted || !item.content.countable() {
+			continue
+		}
+		if content, ok := item.content.(*yjsContentString); ok {
+			current = append(current, content.text...)
+		} else {
+			current = append(current, make([]uint16, item.length)...)
+		}
+	}
+
+	target := utf16FromString(text)
+	prefix := 0
+	for prefix < len(current) && prefix < len(target) && current[prefix] == target[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(current)-prefix && suffix < len(target)-prefix &&
+		current[len(current)-1-suffix] == target[len(target)-1-suffix] {
+		suffix++
+	}
+
+	if deleteEnd := len(current) - suffix; deleteEnd > prefix {
+		doc.deleteText(t, prefix, deleteEnd)
+	}
+	if insert := target[prefix : len(target)-suffix]; len(insert) > 0 {
+		doc.insertText(t, prefix, insert, client)
+	}
+}
+
+// findPosition returns the items around index of t, splitting the item holding it
+func (doc *YjsDoc) findPosition(t *yjsType, index int) (left, right *yjsItem) {
+	right = t.start
+	for right != nil && index > 0 {
+		if !right.deleted && right.content.countable() {
+			if index < right.length {
+				doc.getItemCleanStart(yjsID{client: right.id.client, clock: right.id.clock + index})
+			}
+			index -= right.length
+		}
+		left, right = right, right.right
+	}
+	return left, right
+}
+
+func (doc *YjsDoc) deleteText(t *yjsType, start, end int) {
+	_, item := doc.findPosition(t, start)
+	length := end - start
+	for ; item != nil && length > 0; item = item.right {
+		if item.deleted || !item.content.countable() {
+			continue
+		}
+		if length < item.length {
+			doc.getItemCleanStart(yjsID{client: item.id.client, clock: item.id.clock + length})
+		}
+		length -= item.length
+		doc.deleteItem(item)
+	}
+}
+
+func (doc *YjsDoc) insertText(t *yjsType, index int, text []uint16, client uint64) {
+	left, right := doc.findPosition(t, index)
+	item := &yjsItem{
+		kind:    yjsKindItem,
+		id:      yjsID{client: client, clock: doc.state(client)},
+		length:  len(text),
+		left:    left,
+		right:   right,
+		parent:  t,
+		content: &yjsContentString{text: text},
+	}
+	if left != nil {
+		lastID := left.lastID()
+		item.origin = &lastID
+	}
+	if right != nil {
+		rightID := right.id
+		item.rightOrigin = &rightID
+	}
+	doc.integrate(item, 0)
+}
+
+// Pending reports whether structs or deletes wait for updates the doc didn't get
+func (doc *YjsDoc) Pending() bool {
+	return len(doc.pending) > 0
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"unicode/utf16"
+)
+
+// Binary encoding of Yjs updates and state vectors (the v1 format of lib0/encoding),
+// see https://github.com/yjs/yjs/blob/main/INTERNALS.md
+
+var errYjsMalformed = errors.New("malformed yjs update")
+
+// Struct and content refs, the low 5 bits of an item's info byte
+const (
+	yjsRefGC      = 0
+	yjsRefDeleted = 1
+	yjsRefJSON    = 2
+	yjsRefBinary  = 3
+	yjsRefString  = 4
+	yjsRefEmbed   = 5
+	yjsRefFormat  = 6
+	yjsRefType    = 7
+	yjsRefAny     = 8
+	yjsRefDoc     = 9
+	yjsRefSkip    = 10
+)
+
+// Flags of an item's info byte
+const (
+	yjsHasOrigin      = 0x80
+	yjsHasRightOrigin = 0x40
+	yjsHasParentSub   = 0x20
+)
+
+// Type refs of shared types, Y.XmlElement and Y.XmlHook also write a name
+const (
+	yjsTypeXmlElement = 3
+	yjsTypeXmlHook    = 5
+)
+
+type yjsDecoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func newYjsDecoder(buf []byte) *yjsDecoder {
+	return &yjsDecoder{buf: buf}
+}
+
+// fail records the first error, reads return zero values from then on
+func (d *yjsDecoder) fail() {
+	if d.err == nil {
+		d.err = errYjsMalformed
+	}
+	d.pos = len(d.buf)
+}
+
+func (d *yjsDecoder) readByte() byte {
+	if d.pos >= len(d.buf) {
+		d.fail()
+		return 0
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *yjsDecoder) readBytes(n int) []byte {
+	if n < 0 || n > len(d.buf)-d.pos {
+		d.fail()
+		return nil
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+// readVarUint reads an unsigned LEB128 number, lib0 writes at most 53 bits
+func (d *yjsDecoder) readVarUint() uint64 {
+	var num uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b := d.readByte()
+		num |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return num
+		}
+	}
+	d.fail()
+	return 0
+}
+
+// readLen reads a length or clock, refusing values that can't be one
+func (d *yjsDecoder) readLen() int {
+	n := d.readVarUint()
+	if n > math.MaxInt32 {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+// readVarInt reads lib0's signed number: sign in bit 6 of the first byte
+func (d *yjsDecoder) readVarInt() {
+	b := d.readByte()
+	for b&0x80 != 0 {
+		b = d.readByte()
+	}
+}
+
+func (d *yjsDecoder) readVarBytes() []byte {
+	return d.readBytes(d.readLen())
+}
+
+func (d *yjsDecoder) readVarString() string {
+	return string(d.readVarBytes())
+}
+
+// readAny skips a lib0 "any" value and returns its encoding, it is only stored and written back
+func (d *yjsDecoder) readAny() []byte {
+	start := d.pos
+	d.skipAny(0)
+	return d.buf[start:d.pos]
+}
+
+func (d *yjsDecoder) skipAny(depth int) {
+	if depth > 100 {
+		d.fail()
+		return
+	}
+	switch d.readByte() {
+	case 127, 126, 121, 120: // undefined, null, false, true
+	case 125: // integer
+		d.readVarInt()
+	case 124: // float32
+		d.readBytes(4)
+	case 123, 122: // float64, bigint
+		d.readBytes(8)
+	case 119: // string
+		d.readVarBytes()
+	case 118: // object
+		for n := d.readLen(); n > 0 && d.err == nil; n-- {
+			d.readVarBytes()
+			d.skipAny(depth + 1)
+		}
+	case 117: // array
+		for n := d.readLen(); n > 0 && d.err == nil; n-- {
+			d.skipAny(depth + 1)
+		}
+	case 116: // Uint8Array
+		d.readVarBytes()
+	default:
+		d.fail()
+	}
+}
+
+func (d *yjsDecoder) readID() yjsID {
+	return yjsID{client: d.readVarUint(), clock: d.readLen()}
+}
+
+type yjsEncoder struct {
+	buf []byte
+}
+
+func (e *yjsEncoder) writeByte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *yjsEncoder) writeVarUint(num uint64) {
+	e.buf = binary.AppendUvarint(e.buf, num)
+}
+
+func (e *yjsEncoder) writeLen(n int) {
+	e.writeVarUint(uint64(n))
+}
+
+func (e *yjsEncoder) writeVarBytes(b []byte) {
+	e.writeLen(len(b))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *yjsEncoder) writeVarString(s string) {
+	e.writeVarBytes([]byte(s))
+}
+
+func (e *yjsEncoder) writeID(id yjsID) {
+	e.writeVarUint(id.client)
+	e.writeLen(id.clock)
+}
+
+// JavaScript strings are UTF-16, Yjs counts text lengths and offsets in UTF-16 code units
+func utf16FromString(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+func stringFromUTF16(units []uint16) string {
+	return string(utf16.Decode(units))
+}
+
+// decodeYjsStateVector reads the clock each client is known up to
+func decodeYjsStateVector(buf []byte) (map[uint64]int, error) {
+	d := newYjsDecoder(buf)
+	sv := map[uint64]int{}
+	for n := d.readLen(); n > 0 && d.err == nil; n-- {
+		client := d.readVarUint()
+		sv[client] = d.readLen()
+	}
+	return sv, d.err
+}
+
+func encodeYjsStateVector(sv map[uint64]int) []byte {
+	e := &yjsEncoder{}
+	clients := sortedYjsClients(sv)
+	e.writeLen(len(clients))
+	for _, client := range clients {
+		e.writeVarUint(client)
+		e.writeLen(sv[client])
+	}
+	return e.buf
+}
+
+// decodeYjsUpdate reads the structs, grouped by client, and the delete set of an update
+func decodeYjsUpdate(buf []byte) (map[uint64][]*yjsItem, map[uint64][]yjsDeleteRange, error) {
+	d := newYjsDecoder(buf)
+	structs := map[uint64][]*yjsItem{}
+	for clients := d.readLen(); clients > 0 && d.err == nil; clients-- {
+		count := d.readLen()
+		client := d.readVarUint()
+		clock := d.readLen()
+		for ; count > 0 && d.err == nil; count-- {
+			item := decodeYjsStruct(d, yjsID{client: client, clock: clock})
+			if d.err != nil {
+				break
+			}
+			structs[client] = append(structs[client], item)
+			clock += item.length
+		}
+	}
+
+	deletes := map[uint64][]yjsDeleteRange{}
+	for clients := d.readLen(); clients > 0 && d.err == nil; clients-- {
+		client := d.readVarUint()
+		for ranges := d.readLen(); ranges > 0 && d.err == nil; ranges-- {
+			clock := d.readLen()
+			length := d.readLen()
+			deletes[client] = append(deletes[client], yjsDeleteRange{clock: clock, length: length})
+		}
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return structs, deletes, nil
+}
+
+func decodeYjsStruct(d *yjsDecoder, id yjsID) *yjsItem {
+	info := d.readByte()
+	switch info & 0x1f {
+	case yjsRefGC:
+		return &yjsItem{kind: yjsKindGC, id: id, length: d.readLen()}
+	case yjsRefSkip:
+		return &yjsItem{kind: yjsKindSkip, id: id, length: d.readLen()}
+	}
+
+	item := &yjsItem{kind: yjsKindItem, id: id}
+	if info&yjsHasOrigin != 0 {
+		origin := d.readID()
+		item.origin = &origin
+	}
+	if info&yjsHasRightOrigin != 0 {
+		rightOrigin := d.readID()
+		item.rightOrigin = &rightOrigin
+	}
+	if info&(yjsHasOrigin|yjsHasRightOrigin) == 0 {
+		// Without neighbours the item says where it belongs
+		if d.readVarUint() == 1 {
+			item.parentName = d.readVarString()
+			item.hasParentName = true
+		} else {
+			parentID := d.readID()
+			item.parentID = &parentID
+		}
+		if info&yjsHasParentSub != 0 {
+			parentSub := d.readVarString()
+			item.parentSub = &parentSub
+		}
+	}
+	item.content = decodeYjsContent(d, info&0x1f)
+	if item.content != nil {
+		item.length = item.content.length()
+	}
+	if d.err == nil && (item.content == nil || item.length < 1) {
+		d.fail()
+	}
+	return item
+}
+
+func decodeYjsContent(d *yjsDecoder, ref byte) yjsContent {
+	switch ref {
+	case yjsRefDeleted:
+		return &yjsContentDeleted{len: d.readLen()}
+	case yjsRefJSON:
+		content := &yjsContentJSON{}
+		for n := d.readLen(); n > 0 && d.err == nil; n-- {
+			content.values = append(content.values, d.readVarString())
+		}
+		return content
+	case yjsRefBinary:
+		return &yjsContentBinary{data: d.readVarBytes()}
+	case yjsRefString:
+		return &yjsContentString{text: utf16FromString(d.readVarString())}
+	case yjsRefEmbed:
+		return &yjsContentEmbed{json: d.readVarString()}
+	case yjsRefFormat:
+		return &yjsContentFormat{key: d.readVarString(), json: d.readVarString()}
+	case yjsRefType:
+		content := &yjsContentType{typeRef: d.readVarUint(), t: newYjsType("")}
+		if content.typeRef == yjsTypeXmlElement || content.typeRef == yjsTypeXmlHook {
+			content.name = d.readVarString()
+		}
+		return content
+	case yjsRefAny:
+		content := &yjsContentAny{}
+		for n := d.readLen(); n > 0 && d.err == nil; n-- {
+			content.values = append(content.values, d.readAny())
+		}
+		return content
+	case yjsRefDoc:
+		return &yjsContentDoc{guid: d.readVarString(), opts: d.readAny()}
+	}
+	d.fail()
+	return nil
+}
+
+// writeYjsStruct writes the struct without its first offset clocks
+func writeYjsStruct(e *yjsEncoder, item *yjsItem, offset int) {
+	switch item.kind {
+	case yjsKindGC:
+		e.writeByte(yjsRefGC)
+		e.writeLen(item.length - offset)
+		return
+	case yjsKindSkip:
+		e.writeByte(yjsRefSkip)
+		e.writeLen(item.length - offset)
+		return
+	}
+
+	origin := item.origin
+	if offset > 0 {
+		origin = &yjsID{client: item.id.client, clock: item.id.clock + offset - 1}
+	}
+	info := item.content.ref()
+	if origin != nil {
+		info |= yjsHasOrigin
+	}
+	if item.rightOrigin != nil {
+		info |= yjsHasRightOrigin
+	}
+	if item.parentSub != nil {
+		info |= yjsHasParentSub
+	}
+	e.writeByte(info)
+	if origin != nil {
+		e.writeID(*origin)
+	}
+	if item.rightOrigin != nil {
+		e.writeID(*item.rightOrigin)
+	}
+	if origin == nil && item.rightOrigin == nil {
+		// Pending structs aren't integrated and only know where they belong
+		parentName, parentID := item.parentName, item.parentID
+		if item.parent != nil {
+			parentName, parentID = item.parent.name, nil
+			if item.parent.item != nil {
+				parentID = &item.parent.item.id
+			}
+		}
+		if parentID == nil {
+			e.writeVarUint(1)
+			e.writeVarString(parentName)
+		} else {
+			e.writeVarUint(0)
+			e.writeID(*parentID)
+		}
+		if item.parentSub != nil {
+			e.writeVarString(*item.parentSub)
+		}
+	}
+	item.content.write(e, offset)
+}
+
+// writeYjsDeleteSet writes the deleted ranges of each client
+func writeYjsDeleteSet(e *yjsEncoder, deletes map[uint64][]yjsDeleteRange) {
+	clients := sortedYjsClients(deletes)
+	slices.Reverse(clients)
+	e.writeLen(len(clients))
+	for _, client := range clients {
+		e.writeVarUint(client)
+		e.writeLen(len(deletes[client]))
+		for _, r := range deletes[client] {
+			e.writeLen(r.clock)
+			e.writeLen(r.length)
+		}
+	}
+}
+
+// encodeYjsUpdate writes decoded structs that weren't integrated, ordered by clock, and deletes
+// as an update. Gaps between the structs of a client become skips, overlaps are written once.
+func encodeYjsUpdate(structs map[uint64][]*yjsItem, deletes map[uint64][]yjsDeleteRange) []byte {
+	type part struct {
+		item   *yjsItem
+		offset int
+	}
+	parts := map[uint64][]part{}
+	for client, items := range structs {
+		clock := -1
+		for _, item := range items {
+			end := item.id.clock + item.length
+			if item.kind == yjsKindSkip || end <= clock {
+				// Skips of earlier updates may be filled by now
+				continue
+			}
+			if clock >= 0 && item.id.clock > clock {
+				parts[client] = append(parts[client], part{item: &yjsItem{kind: yjsKindSkip, id: yjsID{client: client, clock: clock}, length: item.id.clock - clock}})
+			}
+			parts[client] = append(parts[client], part{item: item, offset: max(clock-item.id.clock, 0)})
+			clock = end
+		}
+	}
+
+	e := &yjsEncoder{}
+	clients := sortedYjsClients(parts)
+	slices.Reverse(clients)
+	e.writeLen(len(clients))
+	for _, client := range clients {
+		e.writeLen(len(parts[client]))
+		e.writeVarUint(client)
+		e.writeLen(parts[client][0].item.id.clock)
+		for _, p := range parts[client] {
+			writeYjsStruct(e, p.item, p.offset)
+		}
+	}
+	writeYjsDeleteSet(e, deletes)
+	return e.buf
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// Updates written by the yjs library (v13, encodeStateAsUpdate), byte by byte
+var (
+	// client 1: ydoc.getText("content").insert(0, "hi")
+	yjsFixtureInsert = concatBytes(
+		[]byte{1, 1, 1, 0},   // 1 client with 1 struct: client 1 from clock 0
+		[]byte{4, 1},         // string item without origins, parent is a root type
+		yjsString("content"), // its name
+		yjsString("hi"),      // content
+		[]byte{0},            // no deletes
+	)
+	// client 2: ytext.insert(2, " there") after the above
+	yjsFixtureAppend = concatBytes(
+		[]byte{1, 1, 2, 0},
+		[]byte{0x84, 1, 1}, // string item with origin 1:1
+		yjsString(" there"),
+		[]byte{0},
+	)
+	// client 1: ytext.delete(0, 1), a delete set only
+	yjsFixtureDelete = []byte{0, 1, 1, 1, 0, 1}
+	// client 3: ydoc.getMap("meta").set("title", "x")
+	yjsFixtureMap = concatBytes(
+		[]byte{1, 1, 3, 0},
+		[]byte{0x28, 1}, // any item with a key, parent is a root type
+		yjsString("meta"),
+		yjsString("title"),
+		[]byte{1, 119}, // one value, a string
+		yjsString("x"),
+		[]byte{0},
+	)
+	// clients 2 and 3 both ytext.insert(2, ...) after yjsFixtureInsert
+	yjsFixtureConcurrentA = concatBytes([]byte{1, 1, 2, 0, 0x84, 1, 1}, yjsString("A"), []byte{0})
+	yjsFixtureConcurrentB = concatBytes([]byte{1, 1, 3, 0, 0x84, 1, 1}, yjsString("B"), []byte{0})
+)
+
+func concatBytes(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func yjsString(s string) []byte {
+	e := &yjsEncoder{}
+	e.writeVarString(s)
+	return e.buf
+}
+
+func applyYjsUpdates(t *testing.T, updates ...[]byte) *YjsDoc {
+	t.Helper()
+	doc := NewYjsDoc()
+	for _, update := range updates {
+		if err := doc.ApplyUpdate(update); err != nil {
+			t.Fatalf("ApplyUpdate(%v): %v", update, err)
+		}
+	}
+	return doc
+}
+
+func TestYjsApplyUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates [][]byte
+		text    string
+		pending bool
+		state   []byte // the whole doc encoded, when the test checks it
+	}{
+		{
+			name:    "insert",
+			updates: [][]byte{yjsFixtureInsert},
+			text:    "hi",
+			state:   yjsFixtureInsert,
+		},
+		{
+			name:    "append",
+			updates: [][]byte{yjsFixtureInsert, yjsFixtureAppend},
+			text:    "hi there",
+			// Clients are written from the highest id down, like Yjs does
+			state: concatBytes(
+				[]byte{2, 1, 2, 0, 0x84, 1, 1}, yjsString(" there"),
+				[]byte{1, 1, 0, 4, 1}, yjsString("content"), yjsString("hi"),
+				[]byte{0},
+			),
+		},
+		{
+			name:    "append waits for its origin",
+			updates: [][]byte{yjsFixtureAppend},
+			pending: true,
+		},
+		{
+			name:    "pending append is applied with its origin",
+			updates: [][]byte{yjsFixtureAppend, yjsFixtureInsert},
+			text:    "hi there",
+		},
+		{
+			name:    "delete",
+			updates: [][]byte{yjsFixtureInsert, yjsFixtureDelete},
+			text:    "i",
+			// The deleted unit is split off and listed in the delete set
+			state: concatBytes(
+				[]byte{1, 2, 1, 0, 1, 1}, yjsString("content"), []byte{1},
+				[]byte{0x84, 1, 0}, yjsString("i"),
+				[]byte{1, 1, 1, 0, 1},
+			),
+		},
+		{
+			name:    "delete waits for the deleted struct",
+			updates: [][]byte{yjsFixtureDelete},
+			pending: true,
+		},
+		{
+			name:    "pending delete is applied with the struct",
+			updates: [][]byte{yjsFixtureDelete, yjsFixtureInsert},
+			text:    "i",
+		},
+		{
+			name:    "map is kept as is",
+			updates: [][]byte{yjsFixtureMap},
+			state:   yjsFixtureMap,
+		},
+		{
+			name:    "update applied twice",
+			updates: [][]byte{yjsFixtureInsert, yjsFixtureInsert},
+			text:    "hi",
+			state:   yjsFixtureInsert,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := applyYjsUpdates(t, test.updates...)
+			if text := doc.Text("content"); text != test.text {
+				t.Errorf("Text() = %q, want %q", text, test.text)
+			}
+			if doc.Pending() != test.pending {
+				t.Errorf("Pending() = %v, want %v", doc.Pending(), test.pending)
+			}
+			if test.state != nil {
+				if state := doc.EncodeStateAsUpdate(nil); !bytes.Equal(state, test.state) {
+					t.Errorf("EncodeStateAsUpdate(nil) = %v, want %v", state, test.state)
+				}
+			}
+		})
+	}
+}
+
+func TestYjsConcurrentInserts(t *testing.T) {
+	// Inserts at the same place are ordered by client id, whichever arrives first
+	orders := map[string][][]byte{
+		"A then B": {yjsFixtureInsert, yjsFixtureConcurrentA, yjsFixtureConcurrentB},
+		"B then A": {yjsFixtureInsert, yjsFixtureConcurrentB, yjsFixtureConcurrentA},
+		"B first":  {yjsFixtureConcurrentB, yjsFixtureInsert, yjsFixtureConcurrentA},
+	}
+	var states [][]byte
+	for name, updates := range orders {
+		t.Run(name, func(t *testing.T) {
+			doc := applyYjsUpdates(t, updates...)
+			if text := doc.Text("content"); text != "hiAB" {
+				t.Errorf("Text() = %q, want %q", text, "hiAB")
+			}
+			states = append(states, doc.EncodeStateAsUpdate(nil))
+		})
+	}
+	for _, state := range states[1:] {
+		if !bytes.Equal(state, states[0]) {
+			t.Errorf("docs differ: %v and %v", state, states[0])
+		}
+	}
+}
+
+func TestYjsRoundTrip(t *testing.T) {
+	docs := map[string]*YjsDoc{
+		"text":    applyYjsUpdates(t, yjsFixtureInsert, yjsFixtureAppend, yjsFixtureDelete),
+		"map":     applyYjsUpdates(t, yjsFixtureMap),
+		"pending": applyYjsUpdates(t, yjsFixtureAppend, yjsFixtureMap),
+	}
+	for name, doc := range docs {
+		t.Run(name, func(t *testing.T) {
+			state := doc.EncodeStateAsUpdate(nil)
+			copied := applyYjsUpdates(t, state)
+			if again := copied.EncodeStateAsUpdate(nil); !bytes.Equal(again, state) {
+				t.Errorf("re-encoded %v, want %v", again, state)
+			}
+			if copied.Text("content") != doc.Text("content") {
+				t.Errorf("Text() = %q, want %q", copied.Text("content"), doc.Text("content"))
+			}
+		})
+	}
+}
+
+func TestYjsStateVectorDiff(t *testing.T) {
+	doc := applyYjsUpdates(t, yjsFixtureInsert, yjsFixtureAppend)
+	sv, err := decodeYjsStateVector(encodeYjsStateVector(map[uint64]int{1: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// What a client holding only the first insert is missing
+	if diff := doc.EncodeStateAsUpdate(sv); !bytes.Equal(diff, yjsFixtureAppend) {
+		t.Errorf("EncodeStateAsUpdate(%v) = %v, want %v", sv, diff, yjsFixtureAppend)
+	}
+}
+
+func TestYjsMalformedUpdate(t *testing.T) {
+	nested := []byte{1, 1, 4, 0, 0x28, 1}
+	nested = append(nested, yjsString("meta")...)
+	nested = append(nested, yjsString("deep")...)
+	nested = append(nested, 1)
+	for range 200 {
+		nested = append(nested, 117, 1) // arrays in arrays
+	}
+
+	tests := []struct {
+		name   string
+		update []byte
+	}{
+		{name: "empty", update: []byte{}},
+		{name: "truncated", update: yjsFixtureInsert[:len(yjsFixtureInsert)-3]},
+		{name: "unknown content", update: []byte{1, 1, 1, 0, 0x1f, 0}},
+		{name: "empty string", update: concatBytes([]byte{1, 1, 1, 0, 4, 1}, yjsString("content"), yjsString(""), []byte{0})},
+		{name: "clock too large", update: []byte{1, 1, 1, 0xff, 0xff, 0xff, 0xff, 0x0f, 0, 1, 0}},
+		{name: "varint too long", update: bytes.Repeat([]byte{0xff}, 20)},
+		{name: "string longer than the update", update: []byte{1, 1, 1, 0, 4, 1, 100, 'c'}},
+		{name: "any nested too deep", update: nested},
+		{name: "unknown any", update: concatBytes([]byte{1, 1, 5, 0, 0x28, 1}, yjsString("meta"), yjsString("k"), []byte{1, 42, 0})},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := applyYjsUpdates(t, yjsFixtureInsert)
+			before := doc.EncodeStateAsUpdate(nil)
+			if err := doc.ApplyUpdate(test.update); !errors.Is(err, errYjsMalformed) {
+				t.Fatalf("ApplyUpdate() = %v, want %v", err, errYjsMalformed)
+			}
+			if after := doc.EncodeStateAsUpdate(nil); !bytes.Equal(after, before) || doc.Pending() {
+				t.Errorf("doc changed to %v", after)
+			}
+		})
+	}
+}
+
+func TestYjsTooManyPending(t *testing.T) {
+	// Structs of client 5 from clock 10 on, clocks 0 to 9 never come
+	e := &yjsEncoder{}
+	e.writeLen(1)
+	e.writeLen(maxYjsPendingStructs + 1)
+	e.writeVarUint(5)
+	e.writeLen(10)
+	for range maxYjsPendingStructs + 1 {
+		writeYjsStruct(e, &yjsItem{kind: yjsKindGC, length: 1}, 0)
+	}
+	e.writeLen(0)
+
+	doc := applyYjsUpdates(t, yjsFixtureInsert)
+	before := doc.EncodeStateAsUpdate(nil)
+	if err := doc.ApplyUpdate(e.buf); !errors.Is(err, errYjsTooManyPending) {
+		t.Fatalf("ApplyUpdate() = %v, want %v", err, errYjsTooManyPending)
+	}
+	if after := doc.EncodeStateAsUpdate(nil); !bytes.Equal(after, before) || doc.Pending() {
+		t.Errorf("doc changed to %v", after)
+	}
+}
+
+// Replicas editing the same text converge whatever order the updates arrive in
+func TestYjsConvergence(t *testing.T) {
+	words := []string{"", "a", "hello", "héllo wörld", "🙂 smile", "the quick brown fox", "fox"}
+	for seed := int64(1); seed <= 5; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		docs := []*YjsDoc{NewYjsDoc(), NewYjsDoc(), NewYjsDoc()}
+		var updates [][]byte
+		for range 30 {
+			i := rng.Intn(len(docs))
+			sv := docs[i].StateVector()
+			text := docs[i].Text("content")
+			cut := rng.Intn(len(text) + 1)
+			for cut < len(text) && text[cut]&0xc0 == 0x80 {
+				cut++
+			}
+			docs[i].SetText("content", text[:cut]+words[rng.Intn(len(words))], uint64(i+1))
+			update := docs[i].EncodeStateAsUpdate(sv)
+			updates = append(updates, update)
+
+			// Some updates reach another replica right away
+			if j := rng.Intn(len(docs)); j != i {
+				if err := docs[j].ApplyUpdate(update); err != nil {
+					t.Fatalf("seed %d: ApplyUpdate: %v", seed, err)
+				}
+			}
+		}
+
+		for _, doc := range docs {
+			rng.Shuffle(len(updates), func(a, b int) { updates[a], updates[b] = updates[b], updates[a] })
+			for _, update := range updates {
+				if err := doc.ApplyUpdate(update); err != nil {
+					t.Fatalf("seed %d: ApplyUpdate: %v", seed, err)
+				}
+			}
+			if doc.Pending() {
+				t.Fatalf("seed %d: updates still pending", seed)
+			}
+		}
+		for _, doc := range docs[1:] {
+			if doc.Text("content") != docs[0].Text("content") {
+				t.Errorf("seed %d: Text() = %q and %q", seed, doc.Text("content"), docs[0].Text("content"))
+			}
+		}
+	}
+}
+
+func FuzzYjsApplyUpdate(f *testing.F) {
+	for _, update := range [][]byte{yjsFixtureInsert, yjsFixtureAppend, yjsFixtureDelete, yjsFixtureMap, yjsFixtureConcurrentA} {
+		f.Add(update)
+	}
+	f.Fuzz(func(t *testing.T, update []byte) {
+		doc := applyYjsUpdates(t, yjsFixtureInsert)
+		before := doc.EncodeStateAsUpdate(nil)
+		err := doc.ApplyUpdate(update)
+		switch {
+		case err == nil:
+		case err == errYjsMalformed || err == errYjsTooManyPending:
+			// Refused before the doc changed
+			if after := doc.EncodeStateAsUpdate(nil); !bytes.Equal(after, before) {
+				t.Fatalf("refused update changed the doc to %v", after)
+			}
+			return
+		default:
+			// A wrapped errYjsMalformed is a recovered panic
+			t.Fatalf("ApplyUpdate() = %v", err)
+		}
+
+		// Whatever was merged is written and read back the same
+		state := doc.EncodeStateAsUpdate(nil)
+		copied := NewYjsDoc()
+		if err := copied.ApplyUpdate(state); err != nil {
+			t.Fatalf("applying the encoded doc: %v", err)
+		}
+		if copied.Text("content") != doc.Text("content") {
+			t.Fatalf("Text() = %q after the round trip, want %q", copied.Text("content"), doc.Text("content"))
+		}
+	})
+}
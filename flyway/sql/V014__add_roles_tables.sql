@@ -0,0 +1,72 @@
+-- Role based access control, replaces admin_emails
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(50) PRIMARY KEY, -- e.g. users:read, checked by RequirePermission
+    description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission VARCHAR(50) NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    granted_by INTEGER REFERENCES users(id) ON DELETE SET NULL, -- NULL when granted by a command or migration
+    granted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles(role_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'See user accounts and their roles'),
+    ('roles:manage', 'Grant and revoke roles'),
+    ('security:read', 'See sign in attempts, lockouts and signing keys'),
+    ('security:manage', 'Clear sign in lockouts'),
+    ('keys:manage', 'Rotate and retire jwt signing keys'),
+    ('admin:query', 'Run SQL queries on the database')
+ON CONFLICT (name) DO NOTHING;
+
+-- Built-in roles
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Everything'),
+    ('support', 'Helps users with their accounts'),
+    ('auditor', 'Read-only access for audits')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, permissions.name
+FROM roles, permissions
+WHERE roles.name = 'admin'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, grants.permission
+FROM roles
+JOIN (VALUES
+    ('support', 'users:read'),
+    ('support', 'security:read'),
+    ('support', 'security:manage'),
+    ('auditor', 'users:read'),
+    ('auditor', 'security:read')
+) AS grants(role, permission) ON grants.role = roles.name
+ON CONFLICT DO NOTHING;
+
+-- Existing admins keep their rights, emails without an account are dropped
+INSERT INTO user_roles (user_id, role_id)
+SELECT users.id, roles.id
+FROM admin_emails
+JOIN users ON users.email = admin_emails.email::citext
+JOIN roles ON roles.name = 'admin'
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS admin_emails;
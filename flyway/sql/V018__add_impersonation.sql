@@ -0,0 +1,32 @@
+-- Sessions an admin opened as another user, user_id is the impersonated user
+ALTER TABLE user_sessions
+ADD COLUMN IF NOT EXISTS impersonator_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_impersonator_id ON user_sessions(impersonator_id) WHERE impersonator_id IS NOT NULL;
+
+-- Every request made while impersonating, and the start and end of each impersonation
+CREATE TABLE IF NOT EXISTS impersonation_log (
+    id BIGSERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL, -- the impersonated session, sessions are only deactivated
+    impersonator_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    impersonator_email CITEXT NOT NULL, -- kept when the admin is deleted
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    user_email CITEXT NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_log_created_at ON impersonation_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_impersonation_log_impersonator_email ON impersonation_log(impersonator_email, created_at);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:impersonate', 'Sign in as another user to see what they see')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, 'users:impersonate'
+FROM roles
+WHERE roles.name = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,17 @@
+-- Public read-only links to a note or a whole folder, for people without an account
+CREATE TABLE IF NOT EXISTS share_links (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE, -- who created the link
+    note_id INTEGER REFERENCES notes(id) ON DELETE CASCADE,
+    folder_id INTEGER REFERENCES folders(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL, -- sha256 of the token
+    password_hash TEXT, -- NULL when the link has no password
+    view_count INTEGER NOT NULL DEFAULT 0,
+    last_viewed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP, -- NULL never expires
+    revoked_at TIMESTAMP,
+    CHECK ((note_id IS NULL) <> (folder_id IS NULL)) -- either a note or a folder
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_user_id ON share_links(user_id);
@@ -0,0 +1,15 @@
+-- Links that make whoever opens them a member of a room, instead of inviting each email
+CREATE TABLE IF NOT EXISTS room_invite_links (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL, -- sha256 of the token
+    role VARCHAR(20) NOT NULL CHECK (role IN ('editor', 'commenter', 'viewer')), -- role of the members joining with the link
+    max_uses INTEGER CHECK (max_uses > 0), -- NULL for unlimited uses
+    use_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP, -- NULL never expires
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_invite_links_room_id ON room_invite_links(room_id);
@@ -0,0 +1,6 @@
+-- Yjs state of notes edited collaboratively over /api/ws, notes.content holds a snapshot of its text
+CREATE TABLE IF NOT EXISTS note_crdt_states (
+    note_id INTEGER PRIMARY KEY REFERENCES notes(id) ON DELETE CASCADE,
+    state BYTEA NOT NULL, -- Y.encodeStateAsUpdate of the whole document
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,12 @@
+-- Folders and notes belong to their room and go with it. user_id only records who created
+-- them, someone editing a shared room, so deleting that account must not delete the
+-- owner's folders and notes.
+ALTER TABLE folders
+ALTER COLUMN user_id DROP NOT NULL,
+DROP CONSTRAINT folders_user_id_fkey,
+ADD CONSTRAINT folders_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE notes
+ALTER COLUMN user_id DROP NOT NULL,
+DROP CONSTRAINT notes_user_id_fkey,
+ADD CONSTRAINT notes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,24 @@
+-- The SQL console role only reads the tables listed here, tables added later stay hidden until
+-- they are added. Password hashes, recovery codes, tokens and the audit logs (audit:read) are left out.
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE SELECT ON TABLES FROM steamed_admin_query;
+REVOKE ALL ON ALL TABLES IN SCHEMA public FROM steamed_admin_query;
+
+GRANT SELECT (id, username, email, created_at, email_verified_at, disabled_at) ON users TO steamed_admin_query;
+
+GRANT SELECT ON
+    rooms,
+    folders,
+    notes,
+    note_crdt_states,
+    room_members,
+    admin_emails,
+    roles,
+    permissions,
+    role_permissions,
+    user_roles,
+    user_identities,
+    sign_in_attempts,
+    sign_in_lockouts,
+    rate_limit_buckets,
+    admin_saved_queries
+TO steamed_admin_query;
@@ -0,0 +1,4 @@
+-- Recovery codes are now hashed like passwords (argon2id with a salt per code), codes
+-- generated before stay a sha256 until the user generates new ones
+ALTER TABLE user_recovery_codes
+ALTER COLUMN code_hash TYPE VARCHAR(255);
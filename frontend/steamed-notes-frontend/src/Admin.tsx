@@ -3,6 +3,7 @@ import React, { useState } from "react";
 interface QueryResult {
   columns: string[];
   rows: any[][] | null;
+  truncated?: boolean;
   error?: string;
 }
 
@@ -24,8 +25,8 @@ const AdminPage: React.FC = () => {
         body: JSON.stringify({ query }),
       });
       if (!response.ok) {
-        const errorData = await response.json();
-        throw new Error(errorData.error || "Failed to execute query");
+        const errorText = await response.text();
+        throw new Error(errorText.trim() || "Failed to execute query");
       }
       const data: QueryResult = await response.json();
       setResult(data);
@@ -36,6 +37,32 @@ const AdminPage: React.FC = () => {
     }
   };
 
+  // Large results are streamed as CSV and saved as a file
+  const downloadCsv = async () => {
+    setIsLoading(true);
+    try {
+      const response = await fetch("/api/admin", {
+        method: "POST",
+        body: JSON.stringify({ query, format: "csv" }),
+      });
+      if (!response.ok) {
+        const errorText = await response.text();
+        throw new Error(errorText.trim() || "Failed to export query");
+      }
+      const blob = await response.blob();
+      const url = URL.createObjectURL(blob);
+      const link = document.createElement("a");
+      link.href = url;
+      link.download = "query.csv";
+      link.click();
+      URL.revokeObjectURL(url);
+    } catch (error) {
+      setResult({ columns: [], rows: [], error: String(error) });
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
   return (
     <div className="p-4 min-h-screen bg-gray-100">
       <h1 className="text-2xl font-bold mb-4 text-gray-800">Admin - Run SQL Queries</h1>
@@ -44,7 +71,7 @@ const AdminPage: React.FC = () => {
           htmlFor="sqlQuery"
           className="block text-sm font-medium text-gray-700 mb-1"
         >
-          Enter SQL Query (SELECT only):
+          Enter SQL Query (SELECT or VALUES, read-only):
         </label>
         <textarea
           id="sqlQuery"
@@ -61,12 +88,24 @@ const AdminPage: React.FC = () => {
       >
         {isLoading ? "Executing..." : "Execute Query"}
       </button>
+      <button
+        onClick={downloadCsv}
+        disabled={isLoading}
+        className="ml-2 bg-gray-500 text-white px-4 py-2 rounded disabled:bg-gray-300 hover:bg-gray-600 transition-colors"
+      >
+        Download CSV
+      </button>
       {result && (
         <div className="mt-4">
           {result.error ? (
             <p className="text-red-500 font-medium">{result.error}</p>
           ) : result.columns.length > 0 ? (
             <div className="overflow-x-auto">
+              {result.truncated && (
+                <p className="text-yellow-700 mb-2">
+                  Showing the first {result.rows?.length} rows, the result was truncated.
+                </p>
+              )}
               <table className="min-w-full border-collapse border border-gray-300 bg-white">
                 <thead>
                   <tr>
@@ -15,12 +15,23 @@ import Shortcuts from "./staticPages/Shortcuts";
 import RequireAuth from "./RequireAuth";
 import SessionsPage from "./SessionsPage";
 import ChangePasswordPage from "./ChangePasswordPage";
+import ResetPasswordPage from "./ResetPasswordPage";
 
+type Impersonation = {
+  email: string;
+  impersonator_email: string;
+};
+
+const stopImpersonation = async () => {
+  await fetch('/api/users/impersonate/stop', { method: 'POST', credentials: 'include' })
+  window.location.assign('/')
+}
 
 function App() {
   const [signedIn, setSignedIn] = useState(false);
   const [ hasCheckedSignIn, setHasCheckedSignIn] = useState(false);
   const [loggedOutToggle, setLoggedOut] = useState(false);
+  const [impersonation, setImpersonation] = useState<Impersonation | null>(null);
 
   useEffect(() => {
     const checkAuth = async () => {
@@ -35,6 +46,10 @@ function App() {
         if (res.ok) {
           console.log("Sign in successful")
           setSignedIn(true);
+          const data = await res.json();
+          setImpersonation(data.impersonating ? data : null);
+        } else {
+          setImpersonation(null);
         }
       } catch (err) {
         console.error("Auth check failed:", err);
@@ -47,6 +62,16 @@ function App() {
 
   return (
     <BrowserRouter>
+      {impersonation && (
+        <div className="bg-red-600 text-white text-sm px-4 py-2 flex items-center justify-between">
+          <span>
+            Viewing as {impersonation.email} (impersonated by {impersonation.impersonator_email}). Password, session and sign in changes are blocked.
+          </span>
+          <button onClick={stopImpersonation} className="ml-4 bg-white text-red-600 px-3 py-1 rounded hover:bg-red-100">
+            Stop impersonating
+          </button>
+        </div>
+      )}
       <Routes>
         <Route
           path="/signin"
@@ -62,6 +87,7 @@ function App() {
         <Route path="/admin" element={<AdminPage/>} />
         <Route path="/about" element={<About/>} />
         <Route path="/shortcuts" element={<Shortcuts/>} />
+        <Route path="/reset-password" element={<ResetPasswordPage/>} />
         <Route path="/sessions" element={<RequireAuth isSignedIn={signedIn} hasCheckedSignIn={hasCheckedSignIn}><SessionsPage /></RequireAuth>} />
         <Route path="/change-password" element={<RequireAuth isSignedIn={signedIn} hasCheckedSignIn={hasCheckedSignIn}><ChangePasswordPage /></RequireAuth>} />
 
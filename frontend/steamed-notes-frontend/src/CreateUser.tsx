@@ -22,7 +22,11 @@ export default function SignupForm() {
 
   const validateForm = (): Partial<FormData> => {
     const newErrors: Partial<FormData> = {};
-    if (!formData.name.trim()) newErrors.name = "Name is required";
+    if (!formData.name.trim()) {
+      newErrors.name = "Name is required";
+    } else if (!/^[A-Za-z0-9_.-]{3,50}$/.test(formData.name.trim())) {
+      newErrors.name = "Name must be 3-50 letters, digits, '_', '.' or '-'";
+    }
     if (!formData.email.trim()) {
       newErrors.email = "Email is required";
     } else if (!/\S+@\S+\.\S+/.test(formData.email)) {
@@ -30,8 +34,8 @@ export default function SignupForm() {
     }
     if (!formData.password) {
       newErrors.password = "Password is required";
-    } else if (formData.password.length < 6) {
-      newErrors.password = "Password must be at least 6 characters";
+    } else if (formData.password.length < 8) {
+      newErrors.password = "Password must be at least 8 characters";
     }
     return newErrors;
   };
@@ -44,25 +48,30 @@ export default function SignupForm() {
     if (Object.keys(validationErrors).length === 0) {
       setIsSubmitting(true);
       try {
-        // TODO: Implement API call for signup
-        fetch('/api/adduser', {
+        fetch('/api/signup', {
           method: 'POST',
           headers: { 'Content-Type': 'application/json' },
           body: JSON.stringify({
-            username: formData.name,
-            email: formData.email,
-            passwordhash: formData.password
+            username: formData.name.trim(),
+            email: formData.email.trim(),
+            password: formData.password
           })
         }).then(
-          res => {
-            // Reset form after successful submission
-            setFormData({ name: "", email: "", password: "" });
+          async res => {
             setIsSubmitting(false);
             if (res.ok) {
-              console.log("Form submitted:", formData);
+              // Reset form after successful submission
+              setFormData({ name: "", email: "", password: "" });
               navigate('/signin');
             } else {
-              setErrors({ email: "An error occurred during signup" });
+              const message = (await res.text()).trim();
+              if (res.status === 409 && message.startsWith("Username")) {
+                setErrors({ name: message });
+              } else if (res.status === 409 || res.status === 400) {
+                setErrors({ email: message || "An error occurred during signup" });
+              } else {
+                setErrors({ email: "An error occurred during signup" });
+              }
             }
           }
         );
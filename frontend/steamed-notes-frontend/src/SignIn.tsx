@@ -42,6 +42,34 @@ const Typewriter: React.FC<{ text: string; speed: number }> = ({ text, speed })
   );
 };
 
+interface OIDCProvider {
+  name: string;
+  display_name: string;
+  login_url: string;
+}
+
+// 2FA is on, exchange the pending token and a code for the session
+const completeSecondFactor = async (mfaToken: string): Promise<{ username: string } | null> => {
+  const code = prompt("Enter the code from your authenticator app, or a recovery code");
+  if (!code) {
+    return null;
+  }
+  const isRecoveryCode = code.trim().length > 6;
+  const mfaRes = await fetch(`/api/signin/2fa`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(isRecoveryCode
+      ? { mfa_token: mfaToken, recovery_code: code }
+      : { mfa_token: mfaToken, code: code }),
+    credentials: "include",
+  });
+  if (!mfaRes.ok) {
+    alert("Sign-in failed: " + (await mfaRes.text()));
+    return null;
+  }
+  return mfaRes.json();
+};
+
 interface SignInProp {
   setHasSignIn: (hasSignedIn: boolean) => void;
 }
@@ -54,6 +82,7 @@ export const SignIn: React.FC<SignInProp> = ({ setHasSignIn }) => {
   const [errors, setErrors] = useState<Partial<FormData>>({});
   const [isSubmitting, setIsSubmitting] = useState(false);
   const [isPasswordVisible, setIsPasswordVisible] = useState(false);
+  const [providers, setProviders] = useState<OIDCProvider[]>([]);
   let navigate = useNavigate();
   let location = useLocation();
   let from = (location.state as { from?: Location })?.from?.pathname || "/rooms";
@@ -62,6 +91,26 @@ export const SignIn: React.FC<SignInProp> = ({ setHasSignIn }) => {
     from = "/rooms"
   } 
 
+  useEffect(() => {
+    fetch(`/api/oidc/providers`)
+      .then((res) => (res.ok ? res.json() : []))
+      .then(setProviders)
+      .catch(() => setProviders([]));
+
+    // A provider sign in of a 2FA user comes back here with a pending token
+    const mfaToken = new URLSearchParams(location.hash.slice(1)).get("mfa_token");
+    if (mfaToken) {
+      window.history.replaceState(null, "", location.pathname);
+      completeSecondFactor(mfaToken).then((resJson) => {
+        if (resJson) {
+          localStorage.setItem("username", resJson.username);
+          setHasSignIn(true);
+          navigate(from);
+        }
+      });
+    }
+  }, []);
+
 
   const validateForm = (): Partial<FormData> => {
     const newErrors: Partial<FormData> = {};
@@ -93,7 +142,14 @@ export const SignIn: React.FC<SignInProp> = ({ setHasSignIn }) => {
           credentials: "include",
         });
         if (res.ok) {
-          const resJson: { username: string } = await res.json();
+          let resJson: { username: string; mfa_required?: boolean; mfa_token?: string } = await res.json();
+          if (resJson.mfa_required) {
+            const mfaJson = await completeSecondFactor(resJson.mfa_token!);
+            if (!mfaJson) {
+              return;
+            }
+            resJson = mfaJson;
+          }
           localStorage.setItem("username", resJson.username);
           setHasSignIn(true);
           navigate(from);
@@ -192,6 +248,19 @@ export const SignIn: React.FC<SignInProp> = ({ setHasSignIn }) => {
             {isSubmitting ? "Signing In..." : "Sign In"}
           </button>
         </form>
+        {providers.length > 0 && (
+          <div className="mt-4 space-y-2">
+            {providers.map((provider) => (
+              <a
+                key={provider.name}
+                href={provider.login_url}
+                className="w-full flex justify-center py-2 px-4 border border-gray-300 rounded-md shadow-sm text-sm font-medium text-gray-700 bg-white hover:bg-gray-50"
+              >
+                Sign in with {provider.display_name}
+              </a>
+            ))}
+          </div>
+        )}
         <p className="mt-4 text-center text-sm text-gray-600">
           Don't have an account?{" "}
           <Link
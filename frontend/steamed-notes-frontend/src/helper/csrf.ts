@@ -0,0 +1,38 @@
+// Adds the X-CSRF-Token header the backend wants on every mutating /api request.
+// The token is the csrf_token cookie, /api/csrf sets it when the browser has none yet.
+
+const SAFE_METHODS = ["GET", "HEAD", "OPTIONS", "TRACE"];
+
+const readCsrfCookie = () =>
+  document.cookie
+    .split("; ")
+    .find((cookie) => cookie.startsWith("csrf_token="))
+    ?.substring("csrf_token=".length);
+
+export const installCsrfFetch = () => {
+  const originalFetch = window.fetch.bind(window);
+
+  const getCsrfToken = async () => {
+    const token = readCsrfCookie();
+    if (token) {
+      return token;
+    }
+    const res = await originalFetch("/api/csrf", { credentials: "include" });
+    const json: { csrf_token: string } = await res.json();
+    return json.csrf_token;
+  };
+
+  window.fetch = async (input: RequestInfo | URL, init?: RequestInit) => {
+    const url = input instanceof Request ? input.url : input.toString();
+    const method = (init?.method ?? (input instanceof Request ? input.method : "GET")).toUpperCase();
+    const sameOrigin = new URL(url, window.location.href).origin === window.location.origin;
+
+    if (!sameOrigin || SAFE_METHODS.includes(method)) {
+      return originalFetch(input, init);
+    }
+
+    const headers = new Headers(init?.headers ?? (input instanceof Request ? input.headers : undefined));
+    headers.set("X-CSRF-Token", await getCsrfToken());
+    return originalFetch(input, { ...init, headers });
+  };
+};